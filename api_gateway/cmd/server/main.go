@@ -14,7 +14,9 @@ import (
 	"github.com/canxphung/DA_CNPM_242/api_gateway/internal/auth"
 	"github.com/canxphung/DA_CNPM_242/api_gateway/internal/config"
 	"github.com/canxphung/DA_CNPM_242/api_gateway/internal/handler"
+	"github.com/canxphung/DA_CNPM_242/api_gateway/internal/health"
 	"github.com/canxphung/DA_CNPM_242/api_gateway/internal/middleware"
+	"github.com/canxphung/DA_CNPM_242/api_gateway/internal/proxy"
 	"github.com/gorilla/mux"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -27,7 +29,7 @@ func main() {
 	cfg := config.LoadConfig()
 
 	// Initialize logger
-	logger := initLogger(cfg.Logging)
+	logger, logLevel := initLogger(cfg.Logging)
 	defer logger.Sync()
 
 	logger.Info("Starting API Gateway",
@@ -36,54 +38,100 @@ func main() {
 	)
 
 	// Create JWT manager
-	jwtManager := auth.NewJWTManager(&cfg.JWT)
-
-	// Create auth middleware
-	authMiddleware := auth.NewAuthMiddleware(jwtManager, logger)
+	jwtManager, err := auth.NewJWTManager(&cfg.JWT, logger)
+	if err != nil {
+		logger.Fatal("Failed to create JWT manager", zap.Error(err))
+	}
 
 	// Create Prometheus registry
 	registry := prometheus.NewRegistry()
 	registry.MustRegister(prometheus.NewGoCollector())
 	registry.MustRegister(prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}))
 
+	// Create auth middleware
+	revocations := auth.NewMemoryRevocationStore(registry)
+	authMiddleware := auth.NewAuthMiddleware(jwtManager, &cfg.Auth, &cfg.Services, revocations, logger)
+
 	// Create metrics middleware
-	metricsMiddleware := middleware.NewMetricsMiddleware(registry)
+	metricsMiddleware := middleware.NewMetricsMiddleware(registry, &cfg.Metrics, cfg.Services.List)
+
+	// Create metrics shared by the service proxies
+	proxyMetrics := proxy.NewMetrics(registry)
+
+	// Check backend health in the background; proxies fail fast to backends that are down
+	healthChecker := health.NewChecker(&cfg.HealthCheck, backendHealthTargets(cfg, logger), registry, logger)
+
+	// Create panic recovery middleware
+	recoveryMiddleware := middleware.NewRecoveryMiddleware(registry, logger)
+
+	// Create client IP filter middleware
+	ipFilterMiddleware := middleware.NewIPFilterMiddleware(&cfg.IPFilter, cfg.Server.TrustedProxyHops, logger)
+
+	// Create security headers middleware
+	securityHeadersMiddleware := middleware.NewSecurityHeadersMiddleware(&cfg.Security)
 
 	// // Create logging middleware
 	loggingMiddleware := middleware.NewLoggingMiddleware(logger)
 
-	// Create CORS middleware - UPDATED: Pass logger to CORS middleware
-	corsMiddleware := middleware.NewCORSMiddleware([]string{
-		"http://localhost:5173", // Vite default dev server
-		"http://localhost:3000", // Create React App default
-		"http://localhost:3001", // Alternative port
-		"http://localhost:4173", // Vite preview
-		"http://127.0.0.1:5173", // Alternative localhost
-		"http://127.0.0.1:3000", // Alternative localhost
-	}, logger) // Pass logger to CORS middleware
+	// Create CORS middleware; the service proxies share its allowed origins
+	corsMiddleware := middleware.NewCORSMiddleware(&cfg.CORS, logger)
+
+	// Create the rejection response shared by all load-protection features
+	overloadResponder := middleware.NewOverloadResponder(&cfg.Overload)
+
+	// Create per-client rate limit middleware (only applied when rps is set)
+	rateLimitMiddleware := middleware.NewRateLimitMiddleware(&cfg.RateLimit, cfg.Services.List, cfg.Server.TrustedProxyHops, overloadResponder, registry, logger)
+
+	// Create response cache middleware
+	cacheMiddleware := middleware.NewCacheMiddleware(&cfg.Cache, registry, logger)
+
+	// Create stream idle timeout middleware
+	streamIdleMiddleware := middleware.NewStreamIdleMiddleware(cfg.Server.StreamIdleTimeout, logger)
+
+	// Create streaming connection limit middleware
+	streamLimitMiddleware := middleware.NewStreamLimitMiddleware(&cfg.Streaming, overloadResponder, registry, logger)
+
+	// Create concurrency limit middleware (only applied when maxInFlight is set)
+	concurrencyMiddleware := middleware.NewConcurrencyMiddleware(&cfg.Concurrency, overloadResponder, registry, logger)
+
+	// Create request body size limit middleware
+	bodyLimitMiddleware := middleware.NewBodyLimitMiddleware(&cfg.Request, logger)
+
+	// Create Content-Length validation middleware (only applied when enabled)
+	contentLengthMiddleware := middleware.NewContentLengthMiddleware(&cfg.Request, logger)
 
 	// Create router
 	router := mux.NewRouter()
 
-	// NEW: Handle OPTIONS requests for all routes globally
+	// Match OPTIONS on every path, so that preflights reach the CORS
+	// middleware, which answers them against the allowed origins
 	router.Methods("OPTIONS").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		logger.Debug("Global OPTIONS handler processing request",
-			zap.String("path", r.URL.Path),
-			zap.String("origin", r.Header.Get("Origin")))
-
-		w.Header().Set("Access-Control-Allow-Origin", r.Header.Get("Origin"))
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS, PATCH, HEAD")
-		w.Header().Set("Access-Control-Allow-Headers", "Accept, Authorization, Content-Type, X-CSRF-Token, X-Requested-With, Origin, X-Request-ID")
-		w.Header().Set("Access-Control-Allow-Credentials", "true")
-		w.Header().Set("Access-Control-Max-Age", "86400") // 24 hours
 		w.WriteHeader(http.StatusOK)
 	})
 
 	// Apply common middleware - ORDER IS IMPORTANT!
-	// CORS must come first to handle preflight requests
+	// Recovery wraps everything; CORS comes next to handle preflight requests
+	router.Use(recoveryMiddleware.Recover)
+	router.Use(securityHeadersMiddleware.SetHeaders)
 	router.Use(corsMiddleware.EnableCORS)
 	router.Use(loggingMiddleware.LogRequest)
 	router.Use(metricsMiddleware.CollectMetrics)
+	if len(cfg.IPFilter.Rules) > 0 {
+		router.Use(ipFilterMiddleware.FilterIPs)
+	}
+	// Per-IP limits run ahead of auth, so unauthenticated floods are counted too
+	if cfg.RateLimit.RPS > 0 {
+		router.Use(rateLimitMiddleware.LimitByIP)
+	}
+	router.Use(streamLimitMiddleware.LimitStreams)
+	router.Use(streamIdleMiddleware.EnforceIdleTimeout)
+	if cfg.Concurrency.MaxInFlight > 0 {
+		router.Use(concurrencyMiddleware.LimitConcurrency)
+	}
+	router.Use(bodyLimitMiddleware.LimitBodySize)
+	if cfg.Request.ValidateContentLength {
+		router.Use(contentLengthMiddleware.ValidateContentLength)
+	}
 
 	// Health check endpoint (không cần auth) - register trước khi apply auth middleware
 	router.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
@@ -101,6 +149,21 @@ func main() {
 		w.WriteHeader(http.StatusOK)
 		fmt.Fprintf(w, `{"status":"healthy","version":"v1"}`)
 	}).Methods("GET")
+
+	// Per-backend health from the background checker; 503 while any backend is down (không cần auth)
+	router.Handle("/health/backends", handler.NewBackendHealthHandler(healthChecker, logger)).Methods("GET")
+
+	// Aggregated gateway and backend status (không cần auth)
+	statusHandler := handler.NewStatusHandler(healthChecker, logger)
+	router.Handle("/api/v1/status", statusHandler).Methods("GET")
+
+	// Running build, uptime and backend URLs (không cần auth)
+	router.Handle("/status", handler.NewBuildStatusHandler(cfg.Services.List, logger)).Methods("GET")
+
+	// Runtime log level (admin only)
+	logLevelHandler := handler.NewLogLevelHandler(logLevel, logger)
+	router.Handle("/admin/loglevel", authMiddleware.Authenticate(authMiddleware.RequireRole("admin")(logLevelHandler))).Methods("GET", "POST")
+
 	router.HandleFunc("/debug/echo", func(w http.ResponseWriter, r *http.Request) {
 		logger := logger.With(
 			zap.String("handler", "debug-echo"),
@@ -197,6 +260,9 @@ func main() {
 
 		// Stream data
 		for i := 0; i < 10; i++ {
+			if r.Context().Err() != nil {
+				return
+			}
 			fmt.Fprintf(w, "Chunk %d: %s\n", i, time.Now().Format(time.RFC3339))
 			flusher.Flush()
 			time.Sleep(100 * time.Millisecond)
@@ -214,8 +280,36 @@ func main() {
 	// Then apply auth middleware to all API v1 routes
 	apiV1.Use(authMiddleware.Authenticate)
 
+	// Per-user limits run after auth so authenticated users are keyed by user ID
+	if cfg.RateLimit.UserRPS > 0 {
+		apiV1.Use(rateLimitMiddleware.LimitByUser)
+	}
+	if cfg.RateLimit.Reads.RPS > 0 || cfg.RateLimit.Writes.RPS > 0 || len(cfg.RateLimit.Services) > 0 {
+		apiV1.Use(rateLimitMiddleware.LimitByMethod)
+	}
+
+	// Cached responses are keyed per user unless the route is shared, so caching also follows auth
+	// The cache follows the safe-method policy of the proxy's retries
+	if len(cfg.Cache.Routes) > 0 {
+		apiV1.Use(middleware.NewSafeMethodPolicy(cfg.Proxy.SafeRoutes).MarkSafeRequests)
+		apiV1.Use(cacheMiddleware.CacheResponses)
+	}
+
 	// Setup service handlers với API v1 subrouter
-	setupServiceHandlers(apiV1, cfg, logger)
+	setupServiceHandlers(apiV1, cfg, proxyMetrics, overloadResponder, corsMiddleware, healthChecker, logger)
+
+	// API paths that match no service get a structured 404 instead of mux's
+	// plain one. NotFoundHandler bypasses the router middleware, so CORS is applied here.
+	// Registered paths hit with an unsupported method get 405 with an Allow header
+	methodNotAllowedHandler := handler.NewMethodNotAllowedHandler(router, cfg.Server.MethodNotAllowedStatus, logger)
+	router.MethodNotAllowedHandler = corsMiddleware.EnableCORS(methodNotAllowedHandler)
+
+	var servicePrefixes []string
+	for _, service := range cfg.Services.List {
+		servicePrefixes = append(servicePrefixes, "/api/v1"+service.Prefixes[0]+"/")
+	}
+	unmatchedRouteHandler := handler.NewUnmatchedRouteHandler("/api/v1", servicePrefixes, methodNotAllowedHandler, registry, logger)
+	apiV1.NotFoundHandler = corsMiddleware.EnableCORS(unmatchedRouteHandler)
 
 	// Create HTTP server
 	server := &http.Server{
@@ -226,15 +320,17 @@ func main() {
 		IdleTimeout:  120 * time.Second,
 	}
 
+	healthChecker.Start()
+
 	// Start server in a goroutine
 	go func() {
+		var services []string
+		for _, service := range cfg.Services.List {
+			services = append(services, service.ID+": "+service.URL)
+		}
 		logger.Info("Server listening",
 			zap.String("addr", server.Addr),
-			zap.Strings("services", []string{
-				"user-auth: " + cfg.Services.UserAuthServiceURL,
-				"core-operations: " + cfg.Services.CoreOperationServiceURL,
-				"greenhouse-ai: " + cfg.Services.AIServiceURL,
-			}),
+			zap.Strings("services", services),
 		)
 		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 			logger.Fatal("Server error", zap.Error(err))
@@ -257,46 +353,56 @@ func main() {
 		logger.Fatal("Server forced to shutdown", zap.Error(err))
 	}
 
+	healthChecker.Stop()
+	jwtManager.Stop()
+
 	logger.Info("Server exited properly")
 }
 
-// setupServiceHandlers initializes and registers the handlers for all services
-func setupServiceHandlers(apiV1Router *mux.Router, cfg *config.Config, logger *zap.Logger) {
-	// User & Auth Service
-	logger.Info("Setting up User & Auth service handler",
-		zap.String("url", cfg.Services.UserAuthServiceURL))
-
-	userAuthHandler, err := handler.NewUserAuthHandler(cfg.Services.UserAuthServiceURL, logger)
-	if err != nil {
-		logger.Fatal("Failed to create user & auth handler", zap.Error(err))
-	}
-	userAuthHandler.RegisterRoutes(apiV1Router)
-
-	// Core Operation Service
-	logger.Info("Setting up Core Operation service handler",
-		zap.String("url", cfg.Services.CoreOperationServiceURL))
-
-	coreOperationHandler, err := handler.NewCoreOperationHandler(cfg.Services.CoreOperationServiceURL, logger)
-	if err != nil {
-		logger.Fatal("Failed to create core operation handler", zap.Error(err))
+// backendHealthTargets returns the health check targets of the services. Each
+// service's services.healthPaths entry is checked at the backend path the
+// proxy maps it to, unless healthCheck.paths overrides it; services with
+// neither are not checked. Every weighted target is checked, canaries included.
+func backendHealthTargets(cfg *config.Config, logger *zap.Logger) []health.Target {
+	var targets []health.Target
+	for _, service := range cfg.Services.List {
+		healthPath, ok := cfg.HealthCheck.Paths[service.ID]
+		if !ok {
+			gatewayPath, found := cfg.Services.HealthPaths[service.ID]
+			if !found {
+				continue
+			}
+			var err error
+			if healthPath, err = proxy.BackendPath(service, cfg.Proxy.Services[service.ID].Rewrite, gatewayPath); err != nil {
+				logger.Error("Failed to map health path, not checking service",
+					zap.String("service", service.ID), zap.Error(err))
+				continue
+			}
+		}
+		targets = append(targets, proxy.HealthTargets(service.ID, service.URL, healthPath)...)
 	}
-	coreOperationHandler.RegisterRoutes(apiV1Router)
+	return targets
+}
 
-	// Greenhouse AI Service
-	logger.Info("Setting up Greenhouse AI service handler",
-		zap.String("url", cfg.Services.AIServiceURL))
+func setupServiceHandlers(apiV1Router *mux.Router, cfg *config.Config, proxyMetrics *proxy.Metrics, overload *middleware.OverloadResponder, cors *middleware.CORSMiddleware, checker *health.Checker, logger *zap.Logger) {
+	for _, service := range cfg.Services.List {
+		logger.Info("Setting up service handler",
+			zap.String("service", service.ID),
+			zap.String("url", service.URL))
 
-	aiHandler, err := handler.NewAIHandler(cfg.Services.AIServiceURL, logger)
-	if err != nil {
-		logger.Fatal("Failed to create AI handler", zap.Error(err))
+		serviceHandler, err := handler.NewServiceHandler(service, &cfg.Proxy, proxyMetrics, overload, cors, checker, logger)
+		if err != nil {
+			logger.Fatal("Failed to create service handler", zap.String("service", service.ID), zap.Error(err))
+		}
+		serviceHandler.RegisterRoutes(apiV1Router)
 	}
-	aiHandler.RegisterRoutes(apiV1Router)
 
 	logger.Info("All service handlers registered successfully")
 }
 
-// initLogger initializes the logger based on configuration
-func initLogger(cfg config.LoggingConfig) *zap.Logger {
+// initLogger initializes the logger based on configuration. The returned
+// level controls the logger and can be changed at runtime.
+func initLogger(cfg config.LoggingConfig) (*zap.Logger, zap.AtomicLevel) {
 	var zapConfig zap.Config
 
 	// Choose log level
@@ -319,8 +425,8 @@ func initLogger(cfg config.LoggingConfig) *zap.Logger {
 	if err != nil {
 		// Fall back to a basic logger if there's an error
 		fmt.Printf("Failed to create logger: %v. Using default logger.\n", err)
-		return zap.NewExample()
+		return zap.NewExample(zap.IncreaseLevel(zapConfig.Level)), zapConfig.Level
 	}
 
-	return logger
+	return logger, zapConfig.Level
 }
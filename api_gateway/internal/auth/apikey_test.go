@@ -0,0 +1,106 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/canxphung/DA_CNPM_242/api_gateway/internal/config"
+)
+
+const testAPIKey = "sensor-gateway-key"
+
+func hashAPIKey(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(hash[:])
+}
+
+// userRecorder records the user that reached the handler
+type userRecorder struct {
+	user *User
+}
+
+func (u *userRecorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	u.user = GetUserFromContext(r.Context())
+	w.WriteHeader(http.StatusOK)
+}
+
+// serveWithCredentials sends a GET with an optional bearer token and API key
+func serveWithCredentials(handler http.Handler, path, token, apiKey string) int {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	if apiKey != "" {
+		req.Header.Set(apiKeyHeader, apiKey)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec.Code
+}
+
+func newAPIKeyMiddleware(t *testing.T) *AuthMiddleware {
+	t.Helper()
+	middleware, _ := newTestAuthMiddleware(t, config.AuthConfig{
+		APIKeys: []config.APIKey{{Name: "sensor-gateway", Hash: hashAPIKey(testAPIKey), UserID: "svc-sensors", Role: "service"}},
+		RoleRequirements: []config.RoleRequirement{
+			{PathPrefix: "/api/v1/user-auth/admin", Roles: []string{"admin"}},
+		},
+	})
+	return middleware
+}
+
+func TestAPIKeyAuthentication(t *testing.T) {
+	middleware := newAPIKeyMiddleware(t)
+
+	tests := []struct {
+		name       string
+		apiKey     string
+		path       string
+		wantStatus int
+		wantUser   *User
+	}{
+		{"valid key", testAPIKey, "/api/v1/core-operations/readings", http.StatusOK, &User{ID: "svc-sensors", Role: "service"}},
+		{"invalid key", "wrong-key", "/api/v1/core-operations/readings", http.StatusUnauthorized, nil},
+		{"hash instead of key", hashAPIKey(testAPIKey), "/api/v1/core-operations/readings", http.StatusUnauthorized, nil},
+		{"role requirement applies", testAPIKey, "/api/v1/user-auth/admin/users", http.StatusForbidden, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := &userRecorder{}
+			status := serveWithCredentials(middleware.Authenticate(recorder), tt.path, "", tt.apiKey)
+			if status != tt.wantStatus {
+				t.Fatalf("status %d, want %d", status, tt.wantStatus)
+			}
+			if tt.wantUser == nil {
+				if recorder.user != nil {
+					t.Errorf("handler reached with user %+v", recorder.user)
+				}
+				return
+			}
+			if recorder.user == nil || *recorder.user != *tt.wantUser {
+				t.Errorf("user = %+v, want %+v", recorder.user, tt.wantUser)
+			}
+		})
+	}
+}
+
+func TestJWTTakesPrecedenceOverAPIKey(t *testing.T) {
+	middleware := newAPIKeyMiddleware(t)
+	token := signHS256(t, testSecret, userAuthClaims())
+
+	recorder := &userRecorder{}
+	if status := serveWithCredentials(middleware.Authenticate(recorder), "/api/v1/core-operations/plants", token, testAPIKey); status != http.StatusOK {
+		t.Fatalf("status %d, want 200", status)
+	}
+	if recorder.user == nil || recorder.user.ID != "user-1" || recorder.user.Role != "user" {
+		t.Errorf("user = %+v, want the JWT's user-1", recorder.user)
+	}
+
+	// An invalid token is not rescued by a valid key
+	if status := serveWithCredentials(middleware.Authenticate(okHandler), "/api/v1/core-operations/plants", "not-a-jwt", testAPIKey); status != http.StatusUnauthorized {
+		t.Errorf("invalid token with a valid key: status %d, want 401", status)
+	}
+}
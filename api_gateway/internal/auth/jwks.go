@@ -0,0 +1,260 @@
+package auth
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/canxphung/DA_CNPM_242/api_gateway/internal/config"
+	"go.uber.org/zap"
+)
+
+// maxJWKSBytes bounds the size of a JWKS document
+const maxJWKSBytes = 1 << 20
+
+// jwks is the JSON Web Key Set document served by the identity provider
+type jwks struct {
+	Keys []jwk `json:"keys"`
+}
+
+// jwk is one key of a JWKS; only RSA signing keys are used
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// jwksCache holds the signing keys fetched from a JWKS URL, keyed by kid.
+// The set is refreshed every refreshInterval and when a token names an
+// unknown kid, at most once per minRefreshInterval. A failed refresh keeps
+// the last good set. It is safe for concurrent use.
+type jwksCache struct {
+	url                string
+	refreshInterval    time.Duration
+	minRefreshInterval time.Duration
+	client             *http.Client
+	logger             *zap.Logger
+
+	mu          sync.RWMutex
+	keys        map[string]*rsa.PublicKey
+	lastRefresh time.Time
+
+	// refreshMu serialises refreshes so concurrent misses fetch once
+	refreshMu sync.Mutex
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// newJWKSCache creates the cache and warms it up with a first fetch, so the
+// first authenticated request does not pay for it. The warmup waits at most
+// cfg.WarmupTimeout; when it fails the gateway still starts and the keys are
+// fetched again on the next refresh or miss.
+func newJWKSCache(cfg *config.JWKSConfig, logger *zap.Logger) *jwksCache {
+	cache := &jwksCache{
+		url:                cfg.URL,
+		refreshInterval:    cfg.RefreshInterval,
+		minRefreshInterval: cfg.MinRefreshInterval,
+		client:             &http.Client{Timeout: cfg.Timeout},
+		logger:             logger,
+		keys:               map[string]*rsa.PublicKey{},
+	}
+	cache.warmup(cfg.WarmupTimeout)
+	return cache
+}
+
+// warmup fetches the key set once, giving up after timeout
+func (c *jwksCache) warmup(timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	c.refreshMu.Lock()
+	defer c.refreshMu.Unlock()
+
+	count, err := c.refreshLocked(ctx)
+	if err != nil {
+		c.logger.Warn("JWKS warmup failed, keys will be fetched on first use",
+			zap.String("url", c.url),
+			zap.Duration("timeout", timeout),
+			zap.Error(err))
+		return
+	}
+	c.logger.Info("JWKS keys loaded", zap.String("url", c.url), zap.Int("keys", count))
+}
+
+// start refreshes the key set once per refresh interval until stop is called
+func (c *jwksCache) start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	c.cancel = cancel
+	c.done = make(chan struct{})
+
+	go func() {
+		defer close(c.done)
+
+		ticker := time.NewTicker(c.refreshInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				c.refresh(ctx)
+			}
+		}
+	}()
+}
+
+// stop ends the periodic refresh
+func (c *jwksCache) stop() {
+	if c.cancel == nil {
+		return
+	}
+	c.cancel()
+	<-c.done
+}
+
+// key returns the public key for kid, refreshing the set first when kid is
+// unknown and the last refresh is older than the minimum refresh interval
+func (c *jwksCache) key(kid string) (*rsa.PublicKey, error) {
+	if key, ok := c.lookup(kid); ok {
+		return key, nil
+	}
+
+	c.refreshMu.Lock()
+	defer c.refreshMu.Unlock()
+
+	// Another request may have refreshed while this one waited
+	if key, ok := c.lookup(kid); ok {
+		return key, nil
+	}
+	c.mu.RLock()
+	stale := time.Since(c.lastRefresh) >= c.minRefreshInterval
+	c.mu.RUnlock()
+
+	if stale {
+		c.logRefresh(c.refreshLocked(context.Background()))
+		if key, ok := c.lookup(kid); ok {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+// lookup returns the cached key for kid
+func (c *jwksCache) lookup(kid string) (*rsa.PublicKey, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	key, ok := c.keys[kid]
+	return key, ok
+}
+
+// refresh replaces the key set with a freshly fetched one, keeping the
+// current set when the fetch fails
+func (c *jwksCache) refresh(ctx context.Context) {
+	c.refreshMu.Lock()
+	defer c.refreshMu.Unlock()
+
+	count, err := c.refreshLocked(ctx)
+	if ctx.Err() != nil {
+		// Stopped while fetching
+		return
+	}
+	c.logRefresh(count, err)
+}
+
+// refreshLocked is refresh for callers holding refreshMu. It returns the
+// number of keys fetched.
+func (c *jwksCache) refreshLocked(ctx context.Context) (int, error) {
+	keys, err := c.fetch(ctx)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// Failed refreshes count too, so an unreachable IdP is not hammered on every miss
+	c.lastRefresh = time.Now()
+	if err != nil {
+		return 0, err
+	}
+	c.keys = keys
+	return len(keys), nil
+}
+
+// logRefresh logs the outcome of a refresh after startup
+func (c *jwksCache) logRefresh(count int, err error) {
+	if err != nil {
+		c.logger.Warn("Failed to refresh JWKS, keeping the last key set",
+			zap.String("url", c.url),
+			zap.Error(err))
+		return
+	}
+	c.logger.Debug("JWKS refreshed", zap.String("url", c.url), zap.Int("keys", count))
+}
+
+// fetch downloads and parses the key set
+func (c *jwksCache) fetch(ctx context.Context) (map[string]*rsa.PublicKey, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	var set jwks
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxJWKSBytes)).Decode(&set); err != nil {
+		return nil, fmt.Errorf("failed to decode JWKS: %w", err)
+	}
+
+	keys := make(map[string]*rsa.PublicKey, len(set.Keys))
+	for _, key := range set.Keys {
+		if key.Kty != "RSA" || (key.Use != "" && key.Use != "sig") || (key.Alg != "" && key.Alg != "RS256") {
+			continue
+		}
+		publicKey, err := key.rsaPublicKey()
+		if err != nil {
+			c.logger.Warn("Skipping invalid JWKS key", zap.String("kid", key.Kid), zap.Error(err))
+			continue
+		}
+		keys[key.Kid] = publicKey
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("JWKS contains no usable RSA signing keys")
+	}
+	return keys, nil
+}
+
+// rsaPublicKey decodes the key's base64url modulus and exponent
+func (k jwk) rsaPublicKey() (*rsa.PublicKey, error) {
+	n, err := base64.RawURLEncoding.DecodeString(k.N)
+	if err != nil {
+		return nil, fmt.Errorf("invalid modulus: %w", err)
+	}
+	e, err := base64.RawURLEncoding.DecodeString(k.E)
+	if err != nil {
+		return nil, fmt.Errorf("invalid exponent: %w", err)
+	}
+	exponent := new(big.Int).SetBytes(e)
+	if len(n) == 0 || !exponent.IsInt64() || exponent.Int64() < 3 || exponent.Int64() > 1<<31-1 {
+		return nil, errors.New("invalid RSA key parameters")
+	}
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}, nil
+}
@@ -0,0 +1,239 @@
+package auth
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/canxphung/DA_CNPM_242/api_gateway/internal/config"
+	"github.com/golang-jwt/jwt/v5"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// fakeJWKS serves a key set that the test can rotate or make fail
+type fakeJWKS struct {
+	mu      sync.Mutex
+	keys    map[string]*rsa.PrivateKey
+	status  int
+	fetches atomic.Int32
+	server  *httptest.Server
+}
+
+func newFakeJWKS(t *testing.T, keys map[string]*rsa.PrivateKey) *fakeJWKS {
+	t.Helper()
+	f := &fakeJWKS{keys: keys, status: http.StatusOK}
+	f.server = httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(f.server.Close)
+	return f
+}
+
+func (f *fakeJWKS) serve(w http.ResponseWriter, r *http.Request) {
+	f.fetches.Add(1)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.status != http.StatusOK {
+		w.WriteHeader(f.status)
+		return
+	}
+	var set jwks
+	for kid, key := range f.keys {
+		set.Keys = append(set.Keys, jwk{
+			Kty: "RSA",
+			Kid: kid,
+			Use: "sig",
+			Alg: "RS256",
+			N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(set)
+}
+
+// set replaces the served keys and status
+func (f *fakeJWKS) set(keys map[string]*rsa.PrivateKey, status int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.keys = keys
+	f.status = status
+}
+
+// signWithKid signs userAuthClaims with key, naming kid in the header
+func signWithKid(t *testing.T, key *rsa.PrivateKey, kid string) string {
+	t.Helper()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, userAuthClaims())
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+	return signed
+}
+
+func newJWKSManager(t *testing.T, url string, refreshInterval, minRefreshInterval time.Duration) *JWTManager {
+	t.Helper()
+	return newTestJWTManager(t, config.JWTConfig{JWKS: config.JWKSConfig{
+		URL:                url,
+		RefreshInterval:    refreshInterval,
+		MinRefreshInterval: minRefreshInterval,
+		Timeout:            time.Second,
+		WarmupTimeout:      time.Second,
+	}})
+}
+
+func TestJWKSValidatesEitherKey(t *testing.T) {
+	key1, key2 := generateRSAKey(t), generateRSAKey(t)
+	idp := newFakeJWKS(t, map[string]*rsa.PrivateKey{"key-1": key1, "key-2": key2})
+	manager := newJWKSManager(t, idp.server.URL, time.Hour, time.Hour)
+
+	for kid, key := range map[string]*rsa.PrivateKey{"key-1": key1, "key-2": key2} {
+		if _, err := manager.ValidateToken(signWithKid(t, key, kid)); err != nil {
+			t.Errorf("token signed with %s: %v", kid, err)
+		}
+	}
+	// A kid must match the key that signed the token
+	if _, err := manager.ValidateToken(signWithKid(t, key1, "key-2")); err == nil {
+		t.Error("token signed with key-1 but naming key-2 was accepted")
+	}
+}
+
+func TestJWKSRotatedOutKeyFails(t *testing.T) {
+	key1, key2 := generateRSAKey(t), generateRSAKey(t)
+	idp := newFakeJWKS(t, map[string]*rsa.PrivateKey{"key-1": key1, "key-2": key2})
+	manager := newJWKSManager(t, idp.server.URL, 20*time.Millisecond, time.Hour)
+
+	oldToken := signWithKid(t, key1, "key-1")
+	if _, err := manager.ValidateToken(oldToken); err != nil {
+		t.Fatalf("before rotation: %v", err)
+	}
+
+	idp.set(map[string]*rsa.PrivateKey{"key-2": key2}, http.StatusOK)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if _, err := manager.ValidateToken(oldToken); err != nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("token signed with the rotated-out key still validates")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, err := manager.ValidateToken(signWithKid(t, key2, "key-2")); err != nil {
+		t.Errorf("remaining key after rotation: %v", err)
+	}
+}
+
+func TestJWKSUnknownKidRefreshes(t *testing.T) {
+	key1, key2 := generateRSAKey(t), generateRSAKey(t)
+	idp := newFakeJWKS(t, map[string]*rsa.PrivateKey{"key-1": key1})
+	manager := newJWKSManager(t, idp.server.URL, time.Hour, 0)
+
+	// The provider starts signing with a new key before the next scheduled refresh
+	idp.set(map[string]*rsa.PrivateKey{"key-1": key1, "key-2": key2}, http.StatusOK)
+	if _, err := manager.ValidateToken(signWithKid(t, key2, "key-2")); err != nil {
+		t.Errorf("token with a new kid: %v", err)
+	}
+}
+
+func TestJWKSMinRefreshInterval(t *testing.T) {
+	idp := newFakeJWKS(t, map[string]*rsa.PrivateKey{"key-1": generateRSAKey(t)})
+	manager := newJWKSManager(t, idp.server.URL, time.Hour, time.Hour)
+	stranger := generateRSAKey(t)
+
+	before := idp.fetches.Load()
+	for i := 0; i < 5; i++ {
+		if _, err := manager.ValidateToken(signWithKid(t, stranger, "unknown")); err == nil {
+			t.Fatal("token with an unknown kid was accepted")
+		}
+	}
+	if fetches := idp.fetches.Load() - before; fetches != 0 {
+		t.Errorf("unknown kids fetched the JWKS %d times within the minimum interval, want 0", fetches)
+	}
+}
+
+func TestJWKSFetchFailureKeepsLastGoodSet(t *testing.T) {
+	key1 := generateRSAKey(t)
+	idp := newFakeJWKS(t, map[string]*rsa.PrivateKey{"key-1": key1})
+	manager := newJWKSManager(t, idp.server.URL, 20*time.Millisecond, time.Hour)
+
+	idp.set(nil, http.StatusInternalServerError)
+	before := idp.fetches.Load()
+	deadline := time.Now().Add(2 * time.Second)
+	for idp.fetches.Load() < before+2 {
+		if time.Now().After(deadline) {
+			t.Fatal("JWKS was not refetched")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if _, err := manager.ValidateToken(signWithKid(t, key1, "key-1")); err != nil {
+		t.Errorf("after failed refreshes: %v, want the last good key set kept", err)
+	}
+}
+
+func TestJWKSWarmupLoadsKeysAtStartup(t *testing.T) {
+	idp := newFakeJWKS(t, map[string]*rsa.PrivateKey{"key-1": generateRSAKey(t), "key-2": generateRSAKey(t)})
+	core, logs := observer.New(zapcore.InfoLevel)
+	cache := newJWKSCache(&config.JWKSConfig{URL: idp.server.URL, Timeout: time.Second, WarmupTimeout: time.Second}, zap.New(core))
+
+	// Filled before any token asks for a key
+	for _, kid := range []string{"key-1", "key-2"} {
+		if _, ok := cache.lookup(kid); !ok {
+			t.Errorf("%s not loaded by the warmup", kid)
+		}
+	}
+	if fetches := idp.fetches.Load(); fetches != 1 {
+		t.Errorf("fetches = %d, want 1", fetches)
+	}
+	loaded := logs.FilterMessage("JWKS keys loaded").All()
+	if len(loaded) != 1 || loaded[0].ContextMap()["keys"] != int64(2) {
+		t.Errorf("startup log = %v, want one Info entry with 2 keys", loaded)
+	}
+}
+
+func TestJWKSWarmupFailureStillStarts(t *testing.T) {
+	key := generateRSAKey(t)
+	idp := newFakeJWKS(t, map[string]*rsa.PrivateKey{"key-1": key})
+	idp.set(nil, http.StatusServiceUnavailable)
+	core, logs := observer.New(zapcore.InfoLevel)
+	cache := newJWKSCache(&config.JWKSConfig{URL: idp.server.URL, Timeout: time.Second, WarmupTimeout: time.Second}, zap.New(core))
+
+	if warnings := logs.FilterMessage("JWKS warmup failed, keys will be fetched on first use").FilterLevelExact(zapcore.WarnLevel).Len(); warnings != 1 {
+		t.Errorf("warmup failure warnings = %d, want 1", warnings)
+	}
+
+	// The IdP recovers; the first token fetches the keys lazily
+	idp.set(map[string]*rsa.PrivateKey{"key-1": key}, http.StatusOK)
+	if _, err := cache.key("key-1"); err != nil {
+		t.Errorf("lazy fetch after a failed warmup: %v", err)
+	}
+}
+
+func TestJWKSWarmupTimeout(t *testing.T) {
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	t.Cleanup(slow.Close)
+	t.Cleanup(func() { close(release) })
+
+	start := time.Now()
+	newJWKSCache(&config.JWKSConfig{URL: slow.URL, Timeout: time.Minute, WarmupTimeout: 50 * time.Millisecond}, zap.NewNop())
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("warmup blocked startup for %v, want it cut off at the warmup timeout", elapsed)
+	}
+}
@@ -1,12 +1,18 @@
 package auth
 
 import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"os"
+	"strconv"
 	"time"
 
 	"github.com/canxphung/DA_CNPM_242/api_gateway/internal/config"
 	"github.com/golang-jwt/jwt/v5"
+	"go.uber.org/zap"
 )
 
 // Claims defines the custom JWT claims structure
@@ -18,18 +24,74 @@ type Claims struct {
 
 // JWTManager handles JWT token operations
 type JWTManager struct {
-	secretKey         []byte
+	secretKey []byte
+	// publicKey validates RS256 tokens from the identity provider; nil when not configured
+	publicKey *rsa.PublicKey
+	// jwks holds the identity provider's rotating RS256 keys; nil when not configured
+	jwks         *jwksCache
+	validMethods []string
+	leeway       time.Duration
+	issuer       string
+	// idpIssuer is the issuer expected on RS256 tokens (empty = not checked)
+	idpIssuer         string
+	audience          string
 	expiration        time.Duration
 	refreshExpiration time.Duration
+	userIDClaims      []string
 }
 
-// NewJWTManager creates a new JWT manager
-func NewJWTManager(config *config.JWTConfig) *JWTManager {
-	return &JWTManager{
+// NewJWTManager creates a new JWT manager. Tokens are issued with HS256; they
+// are also accepted signed with RS256 when a public key or JWKS URL is
+// configured. Call Stop to end the JWKS refresh.
+func NewJWTManager(config *config.JWTConfig, logger *zap.Logger) (*JWTManager, error) {
+	manager := &JWTManager{
 		secretKey:         []byte(config.SecretKey),
+		validMethods:      []string{jwt.SigningMethodHS256.Alg()},
+		leeway:            config.Leeway,
+		issuer:            config.Issuer,
+		idpIssuer:         config.IdentityProviderIssuer,
+		audience:          config.Audience,
 		expiration:        time.Duration(config.ExpirationMinutes) * time.Minute,
 		refreshExpiration: time.Duration(config.RefreshExpirationHours) * time.Hour,
+		userIDClaims:      config.UserIDClaims,
 	}
+
+	if config.PublicKeyPath != "" {
+		publicKey, err := loadRSAPublicKey(config.PublicKeyPath)
+		if err != nil {
+			return nil, err
+		}
+		manager.publicKey = publicKey
+	}
+	if config.JWKS.URL != "" {
+		manager.jwks = newJWKSCache(&config.JWKS, logger)
+		manager.jwks.start()
+	}
+	if manager.publicKey != nil || manager.jwks != nil {
+		manager.validMethods = append(manager.validMethods, jwt.SigningMethodRS256.Alg())
+	}
+
+	return manager, nil
+}
+
+// Stop ends the periodic JWKS refresh, if any
+func (m *JWTManager) Stop() {
+	if m.jwks != nil {
+		m.jwks.stop()
+	}
+}
+
+// loadRSAPublicKey reads a PEM-encoded RSA public key
+func loadRSAPublicKey(path string) (*rsa.PublicKey, error) {
+	pemData, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read JWT public key: %w", err)
+	}
+	publicKey, err := jwt.ParseRSAPublicKeyFromPEM(pemData)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse JWT public key %s: %w", path, err)
+	}
+	return publicKey, nil
 }
 
 // GenerateToken creates a new JWT token for the given user
@@ -43,27 +105,75 @@ func (m *JWTManager) GenerateToken(userID, role string) (string, error) {
 			ExpiresAt: jwt.NewNumericDate(now.Add(m.expiration)),
 			IssuedAt:  jwt.NewNumericDate(now),
 			NotBefore: jwt.NewNumericDate(now),
-			Issuer:    "agriculture-iot-gateway",
+			Issuer:    m.issuer,
+			// jti, so that the token can be revoked on logout
+			ID: newTokenID(),
 		},
 	}
+	if m.audience != "" {
+		claims.Audience = jwt.ClaimStrings{m.audience}
+	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 
 	return token.SignedString(m.secretKey)
 }
 
-// ValidateToken validates a JWT token and returns the claims
+// newTokenID returns a random token identifier
+func newTokenID() string {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(id)
+}
+
+// ValidateToken validates a JWT token and returns the claims. Locally issued
+// (HS256) tokens must carry the configured issuer and identity provider
+// (RS256) tokens the provider's, when set; all must name the configured
+// audience, if any.
 func (m *JWTManager) ValidateToken(tokenString string) (*Claims, error) {
+	// The expected issuer depends on who signed the token, so the alg header
+	// picks it; the signature and alg are still verified below
+	issuer := m.issuer
+	if unverified, _, err := jwt.NewParser().ParseUnverified(tokenString, &Claims{}); err == nil &&
+		unverified.Method.Alg() == jwt.SigningMethodRS256.Alg() {
+		issuer = m.idpIssuer
+	}
+
+	options := []jwt.ParserOption{
+		jwt.WithValidMethods(m.validMethods),
+		jwt.WithLeeway(m.leeway),
+	}
+	if issuer != "" {
+		options = append(options, jwt.WithIssuer(issuer))
+	}
+	if m.audience != "" {
+		options = append(options, jwt.WithAudience(m.audience))
+	}
+
 	token, err := jwt.ParseWithClaims(
 		tokenString,
 		&Claims{},
 		func(token *jwt.Token) (interface{}, error) {
-			// Validate the signing algorithm
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+			// The key is chosen by the alg header, but only among the configured
+			// methods (see WithValidMethods), so an HS256 token cannot be
+			// verified with the public key as its secret
+			switch token.Method.Alg() {
+			case jwt.SigningMethodHS256.Alg():
+				return m.secretKey, nil
+			case jwt.SigningMethodRS256.Alg():
+				// Tokens naming a key are checked against the JWKS
+				if kid, _ := token.Header["kid"].(string); kid != "" && m.jwks != nil {
+					return m.jwks.key(kid)
+				}
+				if m.publicKey != nil {
+					return m.publicKey, nil
+				}
 			}
-			return m.secretKey, nil
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
 		},
+		options...,
 	)
 
 	if err != nil {
@@ -75,5 +185,46 @@ func (m *JWTManager) ValidateToken(tokenString string) (*Claims, error) {
 		return nil, errors.New("invalid token")
 	}
 
+	m.resolveUserID(tokenString, claims)
+
 	return claims, nil
 }
+
+// resolveUserID sets Claims.UserID from the first configured claim that carries
+// a value, so tokens from standard OIDC providers (which use "sub") are supported
+func (m *JWTManager) resolveUserID(tokenString string, claims *Claims) {
+	var raw jwt.MapClaims
+
+	for _, name := range m.userIDClaims {
+		switch name {
+		case "user_id":
+			if claims.UserID != "" {
+				return
+			}
+		case "sub":
+			if claims.Subject != "" {
+				claims.UserID = claims.Subject
+				return
+			}
+		default:
+			// Other claim names are read from the raw payload; the signature
+			// has already been verified above
+			if raw == nil {
+				raw = jwt.MapClaims{}
+				if _, _, err := jwt.NewParser().ParseUnverified(tokenString, raw); err != nil {
+					return
+				}
+			}
+			switch value := raw[name].(type) {
+			case string:
+				if value != "" {
+					claims.UserID = value
+					return
+				}
+			case float64:
+				claims.UserID = strconv.FormatFloat(value, 'f', -1, 64)
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,367 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/canxphung/DA_CNPM_242/api_gateway/internal/config"
+	"github.com/golang-jwt/jwt/v5"
+	"go.uber.org/zap"
+)
+
+const testSecret = "test-secret"
+
+func newTestJWTManager(t *testing.T, cfg config.JWTConfig) *JWTManager {
+	t.Helper()
+	if cfg.SecretKey == "" {
+		cfg.SecretKey = testSecret
+	}
+	if cfg.ExpirationMinutes == 0 {
+		cfg.ExpirationMinutes = 60
+	}
+	if len(cfg.UserIDClaims) == 0 {
+		cfg.UserIDClaims = []string{"user_id", "sub"}
+	}
+	manager, err := NewJWTManager(&cfg, zap.NewNop())
+	if err != nil {
+		t.Fatalf("NewJWTManager: %v", err)
+	}
+	t.Cleanup(manager.Stop)
+	return manager
+}
+
+// signHS256 signs claims the way user_auth_service does
+func signHS256(t *testing.T, secret string, claims jwt.MapClaims) string {
+	t.Helper()
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(secret))
+	if err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+	return token
+}
+
+// userAuthClaims are the claims of a user_auth_service access token: no iss, no jti
+func userAuthClaims() jwt.MapClaims {
+	return jwt.MapClaims{
+		"sub":   "user-1",
+		"email": "user@example.com",
+		"role":  "user",
+		"type":  "access",
+		"exp":   time.Now().Add(time.Hour).Unix(),
+	}
+}
+
+func TestValidateTokenIssuer(t *testing.T) {
+	withIssuer := func(iss string) jwt.MapClaims {
+		claims := userAuthClaims()
+		claims["iss"] = iss
+		return claims
+	}
+
+	tests := []struct {
+		name     string
+		issuer   string
+		claims   jwt.MapClaims
+		wantErr  error
+		wantUser string
+	}{
+		{name: "no issuer configured, token without iss", claims: userAuthClaims(), wantUser: "user-1"},
+		{name: "no issuer configured, token with iss", claims: withIssuer("anyone"), wantUser: "user-1"},
+		{name: "matching issuer", issuer: "gateway", claims: withIssuer("gateway"), wantUser: "user-1"},
+		{name: "wrong issuer", issuer: "gateway", claims: withIssuer("someone-else"), wantErr: jwt.ErrTokenInvalidIssuer},
+		{name: "missing issuer when configured", issuer: "gateway", claims: userAuthClaims(), wantErr: jwt.ErrTokenRequiredClaimMissing},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			manager := newTestJWTManager(t, config.JWTConfig{Issuer: tt.issuer})
+
+			claims, err := manager.ValidateToken(signHS256(t, testSecret, tt.claims))
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("err = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ValidateToken: %v", err)
+			}
+			if claims.UserID != tt.wantUser {
+				t.Errorf("UserID = %q, want %q", claims.UserID, tt.wantUser)
+			}
+		})
+	}
+}
+
+func TestGenerateTokenRoundTrip(t *testing.T) {
+	manager := newTestJWTManager(t, config.JWTConfig{Issuer: "gateway", Audience: "api"})
+
+	token, err := manager.GenerateToken("user-1", "admin")
+	if err != nil {
+		t.Fatalf("GenerateToken: %v", err)
+	}
+	claims, err := manager.ValidateToken(token)
+	if err != nil {
+		t.Fatalf("ValidateToken: %v", err)
+	}
+	if claims.UserID != "user-1" || claims.Role != "admin" || claims.Issuer != "gateway" || claims.ID == "" {
+		t.Errorf("claims = %+v", claims)
+	}
+}
+
+// writeRSAPublicKey writes key's public half as PEM and returns the path and PEM bytes
+func writeRSAPublicKey(t *testing.T, key *rsa.PrivateKey) (string, []byte) {
+	t.Helper()
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatalf("marshal public key: %v", err)
+	}
+	pemData := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+	path := filepath.Join(t.TempDir(), "jwt.pub")
+	if err := os.WriteFile(path, pemData, 0o600); err != nil {
+		t.Fatalf("write public key: %v", err)
+	}
+	return path, pemData
+}
+
+func generateRSAKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate RSA key: %v", err)
+	}
+	return key
+}
+
+// signWith signs claims with method and key
+func signWith(t *testing.T, method jwt.SigningMethod, key interface{}, claims jwt.MapClaims) string {
+	t.Helper()
+	token, err := jwt.NewWithClaims(method, claims).SignedString(key)
+	if err != nil {
+		t.Fatalf("sign %s: %v", method.Alg(), err)
+	}
+	return token
+}
+
+// signRS256HeaderWithHMAC builds a token whose header says RS256 but whose
+// signature is an HMAC keyed with secret, as in an alg confusion attack
+func signRS256HeaderWithHMAC(t *testing.T, secret []byte, claims jwt.MapClaims) string {
+	t.Helper()
+	signingString, err := jwt.NewWithClaims(jwt.SigningMethodRS256, claims).SigningString()
+	if err != nil {
+		t.Fatalf("signing string: %v", err)
+	}
+	signature, err := jwt.SigningMethodHS256.Sign(signingString, secret)
+	if err != nil {
+		t.Fatalf("hmac: %v", err)
+	}
+	return signingString + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestValidateTokenSigningMethods(t *testing.T) {
+	key := generateRSAKey(t)
+	publicKeyPath, publicKeyPEM := writeRSAPublicKey(t, key)
+	withRSA := config.JWTConfig{PublicKeyPath: publicKeyPath}
+
+	tests := []struct {
+		name    string
+		cfg     config.JWTConfig
+		token   func(t *testing.T) string
+		wantErr bool
+	}{
+		{
+			name:  "HS256 with the shared secret",
+			token: func(t *testing.T) string { return signHS256(t, testSecret, userAuthClaims()) },
+		},
+		{
+			name:    "HS256 with another secret",
+			token:   func(t *testing.T) string { return signHS256(t, "other-secret", userAuthClaims()) },
+			wantErr: true,
+		},
+		{
+			name: "alg none",
+			token: func(t *testing.T) string {
+				return signWith(t, jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, userAuthClaims())
+			},
+			wantErr: true,
+		},
+		{
+			name: "HS384",
+			token: func(t *testing.T) string {
+				return signWith(t, jwt.SigningMethodHS384, []byte(testSecret), userAuthClaims())
+			},
+			wantErr: true,
+		},
+		{
+			name: "HS512",
+			token: func(t *testing.T) string {
+				return signWith(t, jwt.SigningMethodHS512, []byte(testSecret), userAuthClaims())
+			},
+			wantErr: true,
+		},
+		{
+			name:    "RS256 without a public key configured",
+			token:   func(t *testing.T) string { return signWith(t, jwt.SigningMethodRS256, key, userAuthClaims()) },
+			wantErr: true,
+		},
+		{
+			name:  "RS256 with the configured key",
+			cfg:   withRSA,
+			token: func(t *testing.T) string { return signWith(t, jwt.SigningMethodRS256, key, userAuthClaims()) },
+		},
+		{
+			name: "RS256 with another key",
+			cfg:  withRSA,
+			token: func(t *testing.T) string {
+				return signWith(t, jwt.SigningMethodRS256, generateRSAKey(t), userAuthClaims())
+			},
+			wantErr: true,
+		},
+		{
+			name:    "RS256 header with an HMAC keyed by the public key",
+			cfg:     withRSA,
+			token:   func(t *testing.T) string { return signRS256HeaderWithHMAC(t, publicKeyPEM, userAuthClaims()) },
+			wantErr: true,
+		},
+		{
+			name:    "HS256 keyed by the public key",
+			cfg:     withRSA,
+			token:   func(t *testing.T) string { return signHS256(t, string(publicKeyPEM), userAuthClaims()) },
+			wantErr: true,
+		},
+		{
+			name: "PS256 with the configured key",
+			cfg:  withRSA,
+			token: func(t *testing.T) string {
+				return signWith(t, jwt.SigningMethodPS256, key, userAuthClaims())
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			manager := newTestJWTManager(t, tt.cfg)
+
+			claims, err := manager.ValidateToken(tt.token(t))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("token accepted with claims %+v", claims)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ValidateToken: %v", err)
+			}
+			if claims.UserID != "user-1" {
+				t.Errorf("UserID = %q, want user-1", claims.UserID)
+			}
+		})
+	}
+}
+
+func TestValidateTokenIdentityProviderIssuer(t *testing.T) {
+	key := generateRSAKey(t)
+	publicKeyPath, _ := writeRSAPublicKey(t, key)
+	manager := newTestJWTManager(t, config.JWTConfig{
+		PublicKeyPath:          publicKeyPath,
+		Issuer:                 "gateway",
+		IdentityProviderIssuer: "https://idp.example.com",
+	})
+
+	idpClaims := userAuthClaims()
+	idpClaims["iss"] = "https://idp.example.com"
+	if _, err := manager.ValidateToken(signWith(t, jwt.SigningMethodRS256, key, idpClaims)); err != nil {
+		t.Errorf("RS256 token from the identity provider: %v", err)
+	}
+
+	// The gateway's own issuer is not accepted on provider tokens
+	gatewayClaims := userAuthClaims()
+	gatewayClaims["iss"] = "gateway"
+	_, err := manager.ValidateToken(signWith(t, jwt.SigningMethodRS256, key, gatewayClaims))
+	if !errors.Is(err, jwt.ErrTokenInvalidIssuer) {
+		t.Errorf("RS256 token with the gateway issuer: err = %v, want %v", err, jwt.ErrTokenInvalidIssuer)
+	}
+
+	// Nor the provider's on HS256 tokens
+	if _, err := manager.ValidateToken(signHS256(t, testSecret, idpClaims)); !errors.Is(err, jwt.ErrTokenInvalidIssuer) {
+		t.Errorf("HS256 token with the provider issuer: err = %v, want %v", err, jwt.ErrTokenInvalidIssuer)
+	}
+}
+
+func TestValidateTokenUserIDClaims(t *testing.T) {
+	withClaims := func(extra jwt.MapClaims) jwt.MapClaims {
+		claims := userAuthClaims()
+		delete(claims, "sub")
+		for name, value := range extra {
+			claims[name] = value
+		}
+		return claims
+	}
+
+	tests := []struct {
+		name         string
+		userIDClaims []string
+		claims       jwt.MapClaims
+		want         string
+	}{
+		{"user_id first", []string{"user_id", "sub"}, withClaims(jwt.MapClaims{"user_id": "42", "sub": "oidc-1"}), "42"},
+		{"falls back to sub", []string{"user_id", "sub"}, withClaims(jwt.MapClaims{"sub": "oidc-1"}), "oidc-1"},
+		{"sub first", []string{"sub", "user_id"}, withClaims(jwt.MapClaims{"user_id": "42", "sub": "oidc-1"}), "oidc-1"},
+		{"custom string claim", []string{"uid", "sub"}, withClaims(jwt.MapClaims{"uid": "u-7", "sub": "oidc-1"}), "u-7"},
+		{"custom numeric claim", []string{"uid"}, withClaims(jwt.MapClaims{"uid": 1234567}), "1234567"},
+		{"empty custom claim is skipped", []string{"uid", "sub"}, withClaims(jwt.MapClaims{"uid": "", "sub": "oidc-1"}), "oidc-1"},
+		{"no configured claim present", []string{"uid"}, withClaims(jwt.MapClaims{"sub": "oidc-1"}), ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			manager := newTestJWTManager(t, config.JWTConfig{UserIDClaims: tt.userIDClaims})
+			claims, err := manager.ValidateToken(signHS256(t, testSecret, tt.claims))
+			if err != nil {
+				t.Fatalf("ValidateToken: %v", err)
+			}
+			if claims.UserID != tt.want {
+				t.Errorf("UserID = %q, want %q", claims.UserID, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateTokenLeeway(t *testing.T) {
+	tests := []struct {
+		name   string
+		leeway time.Duration
+		claim  string
+		offset time.Duration
+		valid  bool
+	}{
+		{"expired within the leeway", 30 * time.Second, "exp", -10 * time.Second, true},
+		{"expired past the leeway", 30 * time.Second, "exp", -60 * time.Second, false},
+		{"not yet valid within the leeway", 30 * time.Second, "nbf", 10 * time.Second, true},
+		{"not yet valid past the leeway", 30 * time.Second, "nbf", 60 * time.Second, false},
+		{"no leeway", 0, "exp", -10 * time.Second, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			manager := newTestJWTManager(t, config.JWTConfig{Leeway: tt.leeway})
+			claims := userAuthClaims()
+			claims[tt.claim] = time.Now().Add(tt.offset).Unix()
+
+			_, err := manager.ValidateToken(signHS256(t, testSecret, claims))
+			if tt.valid && err != nil {
+				t.Errorf("ValidateToken: %v, want the token accepted", err)
+			}
+			if !tt.valid && err == nil {
+				t.Error("ValidateToken accepted the token")
+			}
+		})
+	}
+}
@@ -2,9 +2,13 @@ package auth
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"net/http"
 	"strings"
+	"time"
 
+	"github.com/canxphung/DA_CNPM_242/api_gateway/internal/config"
 	"go.uber.org/zap"
 )
 
@@ -13,23 +17,51 @@ type contextKey string
 
 const userContextKey contextKey = "user"
 
+// apiKeyHeader carries an API key, accepted when no Authorization header is sent
+const apiKeyHeader = "X-API-Key"
+
 // User represents the authenticated user (thông tin được lấy từ JWT)
 type User struct {
 	ID   string
 	Role string
 }
 
-// AuthMiddleware provides JWT authentication middleware
+// AuthMiddleware provides JWT and API key authentication middleware
 type AuthMiddleware struct {
-	jwtManager *JWTManager
-	logger     *zap.Logger
+	jwtManager  *JWTManager
+	publicPaths []PublicPath
+	revocations RevocationStore
+	logoutPaths []string
+	// apiKeys maps the hex SHA-256 of each API key to its user
+	apiKeys          map[string]apiKeyUser
+	roleRequirements []roleRequirement
+	logger           *zap.Logger
+}
+
+// apiKeyUser is the user an API key authenticates as
+type apiKeyUser struct {
+	name string
+	user User
 }
 
-// NewAuthMiddleware creates a new auth middleware
-func NewAuthMiddleware(jwtManager *JWTManager, logger *zap.Logger) *AuthMiddleware {
+// NewAuthMiddleware creates a new auth middleware.
+// The services' health endpoints are made public as exact paths, and services
+// that do not require auth are public entirely. Tokens found in revocations
+// are rejected; a successful logout through the gateway revokes the caller's token.
+func NewAuthMiddleware(jwtManager *JWTManager, cfg *config.AuthConfig, services *config.ServicesConfig, revocations RevocationStore, logger *zap.Logger) *AuthMiddleware {
+	apiKeys := make(map[string]apiKeyUser, len(cfg.APIKeys))
+	for _, key := range cfg.APIKeys {
+		apiKeys[key.Hash] = apiKeyUser{name: key.Name, user: User{ID: key.UserID, Role: key.Role}}
+	}
+
 	return &AuthMiddleware{
-		jwtManager: jwtManager,
-		logger:     logger,
+		jwtManager:       jwtManager,
+		publicPaths:      buildPublicPaths(cfg, services, logger),
+		revocations:      revocations,
+		logoutPaths:      cfg.Revocation.LogoutPaths,
+		apiKeys:          apiKeys,
+		roleRequirements: buildRoleRequirements(cfg.RoleRequirements),
+		logger:           logger,
 	}
 }
 
@@ -51,76 +83,15 @@ func (m *AuthMiddleware) Authenticate(next http.Handler) http.Handler {
 			return
 		}
 
-		// Danh sách các đường dẫn công khai (không yêu cầu xác thực).
-		// Các đường dẫn này phải là *đường dẫn đầy đủ mà Gateway nhận được từ client*.
-		publicPaths := []string{
-			// Gateway's own common endpoints
-			"/",              // Gateway root endpoint
-			"/health",        // Gateway health check
-			"/metrics",       // Prometheus metrics endpoint
-			"/api/v1/health", // Common API versioned health check
-
-			// === User & Auth Service (Node.js) endpoints ===
-			"/api/v1/user-auth/auth/login",         // User login endpoint
-			"/api/v1/user-auth/auth/admin/login",   // Admin login endpoint
-			"/api/v1/user-auth/auth/register",      // User registration endpoint
-			"/api/v1/user-auth/auth/refresh-token", // Refresh access token
-			"/api/v1/user-auth/auth/docs",          // Swagger UI for Auth Service
-			"/api/v1/user-auth/auth",               // Root of Auth service
-			"/api/v1/user-auth/monitoring/health",  // Health check for monitoring
-			// user profile and operations
-			"/api/v1/user-auth/users",  // gốc
-			"/api/v1/user-auth/users/", // để dùng với strings.HasPrefix
-
-			// === Core Operations Service (Python/FastAPI) endpoints ===
-			// Hỗ trợ cả hai dạng tiền tố "/api/v1/core-operations" và "/api/v1/core-operation"
-			"/api/v1/core-operations", "/api/v1/core-operation", // Root endpoint
-			"/api/v1/core-operations/", "/api/v1/core-operation/", // Root endpoint with trailing slash
-			"/api/v1/core-operations/health", "/api/v1/core-operation/health", // Health check
-			"/api/v1/core-operations/version", "/api/v1/core-operation/version", // Version info
-			"/api/v1/core-operations/docs", "/api/v1/core-operation/docs", // Swagger UI
-
-			// System Config endpoints
-			"/api/v1/core-operations/system/config", "/api/v1/core-operation/system/config", // GET system config
-
-			// Sensor Data endpoints (NẾU MUỐN CÔNG KHAI - xóa nếu cần authentication)
-			"/api/v1/core-operations/sensors/", "/api/v1/core-operation/sensors/", // List available sensors
-			"/api/v1/core-operations/sensors/collect", "/api/v1/core-operation/sensors/collect", // Collect sensor data
-			"/api/v1/core-operations/sensors/snapshot", "/api/v1/core-operation/sensors/snapshot", // Environmental snapshot
-			"/api/v1/core-operations/sensors/light", "/api/v1/core-operation/sensors/light", // Light sensor data
-			"/api/v1/core-operations/sensors/temperature", "/api/v1/core-operation/sensors/temperature", // Temperature data
-			"/api/v1/core-operations/sensors/humidity", "/api/v1/core-operation/sensors/humidity", // Humidity data
-			"/api/v1/core-operations/sensors/soil_moisture", "/api/v1/core-operation/sensors/soil_moisture", // Soil moisture
-			"/api/v1/core-operations/sensors/analyze/soil_moisture", "/api/v1/core-operation/sensors/analyze/soil_moisture", // Analysis
-
-			// Status endpoints
-			"/api/v1/core-operations/control/status", "/api/v1/core-operation/control/status", // Irrigation system status
-			"/api/v1/core-operations/control/pump/status", "/api/v1/core-operation/control/pump/status", // Pump status
-			"/api/v1/core-operations/control/schedules", "/api/v1/core-operation/control/schedules", // List irrigation schedules
-			"/api/v1/core-operations/control/auto", "/api/v1/core-operation/control/auto", // Auto-irrigation config
-
-			// === Greenhouse AI Service (Python/FastAPI) endpoints ===
-			"/api/v1/greenhouse-ai",        // Root endpoint
-			"/api/v1/greenhouse-ai/health", // Health check
-			"/api/v1/greenhouse-ai/docs",   // Swagger UI
-
-			// Sensors & data endpoints
-			"/api/v1/greenhouse-ai/api/sensors/current", // Current sensor data
-			"/api/v1/greenhouse-ai/api/sensors/history", // Sensor history
-
-			// Analytics endpoints cho data công khai
-			"/api/v1/greenhouse-ai/api/analytics/model-performance", // Model performance
-		}
-
 		// Kiểm tra xem đường dẫn hiện tại có phải là công khai hay không
 		isPublic := false
-		for _, path := range publicPaths {
-			// Kiểm tra khớp chính xác hoặc đường dẫn con bắt đầu bằng tiền tố công khai
-			if r.URL.Path == path || strings.HasPrefix(r.URL.Path, path) {
+		for _, path := range m.publicPaths {
+			if path.matches(r.URL.Path) {
 				isPublic = true
 				m.logger.Debug("Public path match found",
 					zap.String("request_path", r.URL.Path),
-					zap.String("matched_path", path))
+					zap.String("matched_path", path.Path),
+					zap.Bool("prefix", path.Prefix))
 				break
 			}
 		}
@@ -136,6 +107,10 @@ func (m *AuthMiddleware) Authenticate(next http.Handler) http.Handler {
 
 		// Nếu không phải đường dẫn công khai, kiểm tra Authorization header
 		authHeader := r.Header.Get("Authorization")
+		if authHeader == "" && r.Header.Get(apiKeyHeader) != "" {
+			m.authenticateAPIKey(w, r, next)
+			return
+		}
 		if authHeader == "" {
 			m.logger.Debug("No authorization header present for protected path",
 				zap.String("path", r.URL.Path),
@@ -170,6 +145,17 @@ func (m *AuthMiddleware) Authenticate(next http.Handler) http.Handler {
 			return
 		}
 
+		revocationKey := tokenRevocationKey(tokenString, claims)
+		if m.revocations.IsRevoked(revocationKey) {
+			m.logger.Warn("Revoked token",
+				zap.String("user_id", claims.UserID),
+				zap.String("path", r.URL.Path),
+				zap.String("client_ip", r.RemoteAddr),
+			)
+			http.Error(w, "Token has been revoked", http.StatusUnauthorized)
+			return
+		}
+
 		// Nếu token hợp lệ, thêm thông tin người dùng vào context của request
 		user := &User{
 			ID:   claims.UserID,
@@ -183,11 +169,119 @@ func (m *AuthMiddleware) Authenticate(next http.Handler) http.Handler {
 			zap.String("path", r.URL.Path),
 		)
 
+		if !m.authorize(w, r, user) {
+			return
+		}
+
+		if r.Method == http.MethodPost && m.isLogoutPath(r.URL.Path) {
+			recorder := &statusRecorder{ResponseWriter: w}
+			next.ServeHTTP(recorder, r.WithContext(ctx))
+			m.revokeOnLogout(recorder.status, revocationKey, claims)
+			return
+		}
+
 		// Cho phép request đi tiếp với context đã cập nhật
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
+// authenticateAPIKey authenticates a request by its X-API-Key header, for
+// clients that cannot obtain a JWT
+func (m *AuthMiddleware) authenticateAPIKey(w http.ResponseWriter, r *http.Request, next http.Handler) {
+	hash := sha256.Sum256([]byte(r.Header.Get(apiKeyHeader)))
+	key, ok := m.apiKeys[hex.EncodeToString(hash[:])]
+	if !ok {
+		m.logger.Warn("Invalid API key",
+			zap.String("path", r.URL.Path),
+			zap.String("client_ip", r.RemoteAddr),
+		)
+		http.Error(w, "Invalid API key", http.StatusUnauthorized)
+		return
+	}
+
+	user := key.user
+	m.logger.Debug("Request authenticated with API key",
+		zap.String("key", key.name),
+		zap.String("user_id", user.ID),
+		zap.String("role", user.Role),
+		zap.String("path", r.URL.Path),
+	)
+	if !m.authorize(w, r, &user) {
+		return
+	}
+	next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), userContextKey, &user)))
+}
+
+// isLogoutPath reports whether path is one of the configured logout endpoints
+func (m *AuthMiddleware) isLogoutPath(path string) bool {
+	path = strings.TrimSuffix(path, "/")
+	for _, logoutPath := range m.logoutPaths {
+		if path == strings.TrimSuffix(logoutPath, "/") {
+			return true
+		}
+	}
+	return false
+}
+
+// tokenRevocationKey identifies a token in the revocation store: its jti, or
+// for tokens without one (such as user_auth_service's) a hash of the token
+func tokenRevocationKey(tokenString string, claims *Claims) string {
+	if claims.ID != "" {
+		return claims.ID
+	}
+	hash := sha256.Sum256([]byte(tokenString))
+	return "sha256:" + hex.EncodeToString(hash[:])
+}
+
+// revokeOnLogout revokes the token once the backend has accepted the logout
+func (m *AuthMiddleware) revokeOnLogout(status int, revocationKey string, claims *Claims) {
+	if status < http.StatusOK || status >= http.StatusMultipleChoices {
+		return
+	}
+
+	var expiresAt time.Time
+	if claims.ExpiresAt != nil {
+		// The token is still accepted for the leeway past its expiry
+		expiresAt = claims.ExpiresAt.Time.Add(m.jwtManager.leeway)
+	}
+	m.revocations.Revoke(revocationKey, expiresAt)
+	m.logger.Info("Token revoked on logout",
+		zap.String("user_id", claims.UserID),
+		zap.Time("expires_at", expiresAt))
+}
+
+// statusRecorder captures the status code a handler writes
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (sr *statusRecorder) WriteHeader(code int) {
+	if sr.status == 0 && (code < 100 || code > 199) {
+		sr.status = code
+	}
+	sr.ResponseWriter.WriteHeader(code)
+}
+
+func (sr *statusRecorder) Write(data []byte) (int, error) {
+	if sr.status == 0 {
+		sr.status = http.StatusOK
+	}
+	return sr.ResponseWriter.Write(data)
+}
+
+// Flush implements the http.Flusher interface
+func (sr *statusRecorder) Flush() {
+	if flusher, ok := sr.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap returns the underlying ResponseWriter for http.ResponseController
+func (sr *statusRecorder) Unwrap() http.ResponseWriter {
+	return sr.ResponseWriter
+}
+
 // GetUserFromContext extracts the user from the request context.
 // Đây là hàm tiện ích để các handler có thể lấy thông tin người dùng.
 func GetUserFromContext(ctx context.Context) *User {
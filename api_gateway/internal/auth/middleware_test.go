@@ -0,0 +1,176 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/canxphung/DA_CNPM_242/api_gateway/internal/config"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+const testLogoutPath = "/api/v1/user-auth/auth/logout"
+
+func newTestAuthMiddleware(t *testing.T, cfg config.AuthConfig) (*AuthMiddleware, *JWTManager) {
+	t.Helper()
+	manager := newTestJWTManager(t, config.JWTConfig{})
+	if cfg.Revocation.LogoutPaths == nil {
+		cfg.Revocation.LogoutPaths = []string{testLogoutPath}
+	}
+	middleware := NewAuthMiddleware(manager, &cfg, &config.ServicesConfig{},
+		NewMemoryRevocationStore(prometheus.NewRegistry()), zap.NewNop())
+	return middleware, manager
+}
+
+// serveWithToken sends a request through handler and returns the status
+func serveWithToken(handler http.Handler, method, path, token string) int {
+	req := httptest.NewRequest(method, path, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec.Code
+}
+
+var okHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+})
+
+func TestLogoutRevokesToken(t *testing.T) {
+	tests := []struct {
+		name  string
+		token func(t *testing.T, manager *JWTManager) string
+	}{
+		{
+			// user_auth_service tokens have no jti, so they are revoked by hash
+			name: "token without jti",
+			token: func(t *testing.T, manager *JWTManager) string {
+				claims := userAuthClaims()
+				claims["sub"] = "user-" + t.Name()
+				return signHS256(t, testSecret, claims)
+			},
+		},
+		{
+			name: "token with jti",
+			token: func(t *testing.T, manager *JWTManager) string {
+				token, err := manager.GenerateToken("user-1", "user")
+				if err != nil {
+					t.Fatal(err)
+				}
+				return token
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			middleware, manager := newTestAuthMiddleware(t, config.AuthConfig{})
+			handler := middleware.Authenticate(okHandler)
+
+			token := tt.token(t, manager)
+			other := signHS256(t, testSecret, jwt.MapClaims{
+				"sub": "user-2", "role": "user", "exp": time.Now().Add(time.Hour).Unix(),
+			})
+
+			if code := serveWithToken(handler, http.MethodGet, "/api/v1/core-operation/plants", token); code != http.StatusOK {
+				t.Fatalf("before logout: status %d, want 200", code)
+			}
+			if code := serveWithToken(handler, http.MethodPost, testLogoutPath, token); code != http.StatusOK {
+				t.Fatalf("logout: status %d, want 200", code)
+			}
+			if code := serveWithToken(handler, http.MethodGet, "/api/v1/core-operation/plants", token); code != http.StatusUnauthorized {
+				t.Errorf("after logout: status %d, want 401", code)
+			}
+			if code := serveWithToken(handler, http.MethodGet, "/api/v1/core-operation/plants", other); code != http.StatusOK {
+				t.Errorf("other token after logout: status %d, want 200", code)
+			}
+		})
+	}
+}
+
+func TestFailedLogoutDoesNotRevoke(t *testing.T) {
+	middleware, _ := newTestAuthMiddleware(t, config.AuthConfig{})
+	failing := middleware.Authenticate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	handler := middleware.Authenticate(okHandler)
+	token := signHS256(t, testSecret, userAuthClaims())
+
+	serveWithToken(failing, http.MethodPost, testLogoutPath, token)
+	if code := serveWithToken(handler, http.MethodGet, "/api/v1/core-operation/plants", token); code != http.StatusOK {
+		t.Errorf("after failed logout: status %d, want 200", code)
+	}
+}
+
+func TestRevocationExpiresWithToken(t *testing.T) {
+	store := NewMemoryRevocationStore(prometheus.NewRegistry())
+
+	store.Revoke("expired", time.Now().Add(-time.Second))
+	if store.IsRevoked("expired") {
+		t.Error("an already expired token was stored")
+	}
+
+	store.Revoke("short", time.Now().Add(50*time.Millisecond))
+	if !store.IsRevoked("short") {
+		t.Fatal("token not revoked")
+	}
+	time.Sleep(100 * time.Millisecond)
+	if store.IsRevoked("short") {
+		t.Error("revocation outlived the token's expiry")
+	}
+}
+
+// expiryRecorder records the expiry each revocation was stored with
+type expiryRecorder struct {
+	*MemoryRevocationStore
+	expiresAt time.Time
+}
+
+func (r *expiryRecorder) Revoke(key string, expiresAt time.Time) {
+	r.expiresAt = expiresAt
+	r.MemoryRevocationStore.Revoke(key, expiresAt)
+}
+
+func TestLogoutRevocationCoversLeeway(t *testing.T) {
+	const leeway = 30 * time.Second
+	tests := []struct {
+		name   string
+		expiry time.Duration
+	}{
+		{"valid token", time.Hour},
+		// Still accepted thanks to the leeway, so it must stay revoked
+		{"token expired within the leeway", -10 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			manager := newTestJWTManager(t, config.JWTConfig{Leeway: leeway})
+			revocations := &expiryRecorder{MemoryRevocationStore: NewMemoryRevocationStore(prometheus.NewRegistry())}
+			middleware := NewAuthMiddleware(manager, &config.AuthConfig{
+				Revocation: config.RevocationConfig{LogoutPaths: []string{testLogoutPath}},
+			}, &config.ServicesConfig{}, revocations, zap.NewNop())
+			handler := middleware.Authenticate(okHandler)
+
+			expiresAt := time.Now().Add(tt.expiry).Truncate(time.Second)
+			claims := userAuthClaims()
+			claims["exp"] = expiresAt.Unix()
+			token := signHS256(t, testSecret, claims)
+
+			if code := serveWithToken(handler, http.MethodGet, "/api/v1/core-operation/plants", token); code != http.StatusOK {
+				t.Fatalf("before logout: status %d, want 200", code)
+			}
+			if code := serveWithToken(handler, http.MethodPost, testLogoutPath, token); code != http.StatusOK {
+				t.Fatalf("logout: status %d, want 200", code)
+			}
+			if !revocations.expiresAt.Equal(expiresAt.Add(leeway)) {
+				t.Errorf("revoked until %v, want the expiry plus the leeway %v", revocations.expiresAt, expiresAt.Add(leeway))
+			}
+			if code := serveWithToken(handler, http.MethodGet, "/api/v1/core-operation/plants", token); code != http.StatusUnauthorized {
+				t.Errorf("after logout: status %d, want 401", code)
+			}
+		})
+	}
+}
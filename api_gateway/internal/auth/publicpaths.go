@@ -0,0 +1,141 @@
+package auth
+
+import (
+	"strings"
+
+	"github.com/canxphung/DA_CNPM_242/api_gateway/internal/config"
+	"go.uber.org/zap"
+)
+
+// PublicPath là một đường dẫn không yêu cầu xác thực.
+// Path phải là *đường dẫn đầy đủ mà Gateway nhận được từ client*.
+type PublicPath struct {
+	Path string
+	// Prefix makes every path that starts with Path public, not only Path itself
+	Prefix bool
+}
+
+// matches reports whether the request path is covered by this public path.
+// A prefix covers itself and the paths below it, whole segments only, so
+// "/sensors/light/*" covers "/sensors/light/1" but not "/sensors/lighting".
+func (p PublicPath) matches(path string) bool {
+	if p.Prefix {
+		base := strings.TrimSuffix(p.Path, "/")
+		return path == base || strings.HasPrefix(path, base+"/")
+	}
+	return path == p.Path
+}
+
+// parsePublicPath converts a configured path into a PublicPath.
+// A trailing "/*" marks a prefix match, anything else is an exact match.
+func parsePublicPath(spec string) PublicPath {
+	if strings.HasSuffix(spec, "/*") {
+		return PublicPath{Path: strings.TrimSuffix(spec, "*"), Prefix: true}
+	}
+	return PublicPath{Path: spec}
+}
+
+// gatewayAPIPrefix is the gateway path below which services are routed
+const gatewayAPIPrefix = "/api/v1"
+
+func exact(path string) PublicPath  { return PublicPath{Path: path} }
+func prefix(path string) PublicPath { return PublicPath{Path: path, Prefix: true} }
+
+// servicePublicPaths makes each backend's health endpoint public as an exact
+// path, so monitoring can reach it while the rest of the service stays
+// protected, and makes every path of services that do not require auth public
+func servicePublicPaths(services *config.ServicesConfig) []PublicPath {
+	var paths []PublicPath
+	for _, service := range services.List {
+		for _, servicePrefix := range service.Prefixes {
+			gatewayPrefix := gatewayAPIPrefix + servicePrefix
+			if !service.AuthRequired {
+				paths = append(paths, exact(gatewayPrefix), prefix(gatewayPrefix+"/"))
+				continue
+			}
+			if healthPath, ok := services.HealthPaths[service.ID]; ok {
+				paths = append(paths, exact(gatewayPrefix+healthPath))
+			}
+		}
+	}
+	return paths
+}
+
+// buildPublicPaths applies the configured per-service overrides to the
+// configured public paths (see config.AuthConfig.PublicPaths) and adds the
+// paths derived from the services.
+// Removing an exact path drops the entry with that path; removing a "/*"
+// prefix drops every entry at or below it.
+func buildPublicPaths(cfg *config.AuthConfig, services *config.ServicesConfig, logger *zap.Logger) []PublicPath {
+	paths := servicePublicPaths(services)
+
+	for _, service := range overriddenServices(cfg) {
+		override := cfg.PublicPathOverrides[service]
+
+		for _, spec := range cfg.PublicPaths[service] {
+			path := parsePublicPath(spec)
+			if removedBy(path, override.Remove) {
+				// Health checks must stay reachable for orchestration and monitoring
+				if isHealthPath(path.Path) {
+					logger.Warn("Ignoring removal of health check public path",
+						zap.String("service", service),
+						zap.String("path", path.Path))
+					paths = append(paths, path)
+					continue
+				}
+				logger.Info("Public path removed by configuration",
+					zap.String("service", service),
+					zap.String("path", path.Path),
+					zap.Bool("prefix", path.Prefix))
+				continue
+			}
+			paths = append(paths, path)
+		}
+
+		for _, spec := range override.Add {
+			path := parsePublicPath(spec)
+			logger.Info("Public path added by configuration",
+				zap.String("service", service),
+				zap.String("path", path.Path),
+				zap.Bool("prefix", path.Prefix))
+			paths = append(paths, path)
+		}
+	}
+
+	return paths
+}
+
+// overriddenServices returns the services with public paths or overrides,
+// so that services without public paths can still add some
+func overriddenServices(cfg *config.AuthConfig) []string {
+	services := make([]string, 0, len(cfg.PublicPaths)+len(cfg.PublicPathOverrides))
+	for service := range cfg.PublicPaths {
+		services = append(services, service)
+	}
+	for service := range cfg.PublicPathOverrides {
+		if _, ok := cfg.PublicPaths[service]; !ok {
+			services = append(services, service)
+		}
+	}
+	return services
+}
+
+// removedBy reports whether a default public path is dropped by any removal spec
+func removedBy(path PublicPath, removals []string) bool {
+	for _, spec := range removals {
+		removal := parsePublicPath(spec)
+		if removal.Prefix {
+			if strings.HasPrefix(path.Path, removal.Path) || path.Path == strings.TrimSuffix(removal.Path, "/") {
+				return true
+			}
+		} else if path.Path == removal.Path {
+			return true
+		}
+	}
+	return false
+}
+
+// isHealthPath reports whether the path is a health check endpoint
+func isHealthPath(path string) bool {
+	return strings.HasSuffix(strings.TrimSuffix(path, "/"), "/health")
+}
@@ -0,0 +1,152 @@
+package auth
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/canxphung/DA_CNPM_242/api_gateway/internal/config"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// newPublicPathHandler guards okHandler with an auth middleware built from cfg and services
+func newPublicPathHandler(t *testing.T, cfg config.AuthConfig, services config.ServicesConfig) http.Handler {
+	t.Helper()
+	middleware := NewAuthMiddleware(newTestJWTManager(t, config.JWTConfig{}), &cfg, &services,
+		NewMemoryRevocationStore(prometheus.NewRegistry()), zap.NewNop())
+	return middleware.Authenticate(okHandler)
+}
+
+// checkPublic asserts which paths pass without a token and which get a 401
+func checkPublic(t *testing.T, handler http.Handler, public, protected []string) {
+	t.Helper()
+	for _, path := range public {
+		if code := serveWithToken(handler, http.MethodGet, path, ""); code != http.StatusOK {
+			t.Errorf("%s: status %d, want public", path, code)
+		}
+	}
+	for _, path := range protected {
+		if code := serveWithToken(handler, http.MethodGet, path, ""); code != http.StatusUnauthorized {
+			t.Errorf("%s: status %d, want 401", path, code)
+		}
+	}
+}
+
+func TestParsePublicPath(t *testing.T) {
+	tests := []struct {
+		spec string
+		want PublicPath
+	}{
+		{"/api/v1/status", PublicPath{Path: "/api/v1/status"}},
+		{"/api/v1/core-operations/", PublicPath{Path: "/api/v1/core-operations/"}},
+		{"/health/*", PublicPath{Path: "/health/", Prefix: true}},
+		// Only a trailing "/*" is a prefix
+		{"/health*", PublicPath{Path: "/health*"}},
+	}
+	for _, tt := range tests {
+		if got := parsePublicPath(tt.spec); got != tt.want {
+			t.Errorf("parsePublicPath(%q) = %+v, want %+v", tt.spec, got, tt.want)
+		}
+	}
+}
+
+func TestPublicPathMatching(t *testing.T) {
+	handler := newPublicPathHandler(t, config.AuthConfig{PublicPaths: map[string][]string{
+		"core-operations": {
+			"/api/v1/core-operations/sensors",
+			"/api/v1/core-operations/sensors/light/*",
+		},
+	}}, config.ServicesConfig{})
+
+	checkPublic(t, handler,
+		[]string{
+			"/api/v1/core-operations/sensors",
+			"/api/v1/core-operations/sensors/light",
+			"/api/v1/core-operations/sensors/light/",
+			"/api/v1/core-operations/sensors/light/1",
+			"/api/v1/core-operations/sensors/light/1/history",
+		},
+		[]string{
+			// An exact path covers nothing below or beside it
+			"/api/v1/core-operations/sensors/",
+			"/api/v1/core-operations/sensors/1",
+			"/api/v1/core-operations/sensors-secret",
+			// A prefix covers whole segments only
+			"/api/v1/core-operations/sensors/lighting",
+			"/api/v1/core-operations/sensors/light-admin/1",
+			"/api/v1/core-operations",
+		})
+}
+
+func TestPublicPathOverrides(t *testing.T) {
+	handler := newPublicPathHandler(t, config.AuthConfig{
+		PublicPaths: map[string][]string{
+			"core-operations": {
+				"/api/v1/core-operations/version/*",
+				"/api/v1/core-operations/sensors",
+				"/api/v1/core-operations/sensors/light/*",
+				"/api/v1/core-operations/sensors/humidity/*",
+				"/api/v1/core-operations/health/*",
+			},
+		},
+		PublicPathOverrides: map[string]config.PublicPathOverride{
+			"core-operations": {
+				Add: []string{"/api/v1/core-operations/plants/catalog"},
+				Remove: []string{
+					"/api/v1/core-operations/sensors",
+					"/api/v1/core-operations/sensors/*",
+					"/api/v1/core-operations/health/*",
+				},
+			},
+			// A service without public paths can still add some
+			"greenhouse-ai": {Add: []string{"/api/v1/greenhouse-ai/docs/*"}},
+		},
+	}, config.ServicesConfig{})
+
+	checkPublic(t, handler,
+		[]string{
+			"/api/v1/core-operations/version",
+			"/api/v1/core-operations/plants/catalog",
+			"/api/v1/greenhouse-ai/docs/index.html",
+			// Health checks are kept despite the removal
+			"/api/v1/core-operations/health",
+		},
+		[]string{
+			"/api/v1/core-operations/sensors",
+			"/api/v1/core-operations/sensors/light/1",
+			"/api/v1/core-operations/sensors/humidity",
+			"/api/v1/core-operations/plants/catalog/1",
+			"/api/v1/greenhouse-ai",
+		})
+}
+
+func TestServicePublicPaths(t *testing.T) {
+	handler := newPublicPathHandler(t, config.AuthConfig{}, config.ServicesConfig{
+		List: []config.ServiceDefinition{
+			{ID: "irrigation", Prefixes: []string{"/irrigation", "/water"}, AuthRequired: true},
+			{ID: "weather", Prefixes: []string{"/weather"}},
+			{ID: "billing", Prefixes: []string{"/billing"}, AuthRequired: true},
+		},
+		HealthPaths: map[string]string{"irrigation": "/health"},
+	})
+
+	checkPublic(t, handler,
+		[]string{
+			// Each prefix's health endpoint is public as an exact path
+			"/api/v1/irrigation/health",
+			"/api/v1/water/health",
+			// A service that does not require auth is public throughout
+			"/api/v1/weather",
+			"/api/v1/weather/",
+			"/api/v1/weather/forecast/today",
+		},
+		[]string{
+			"/api/v1/irrigation",
+			"/api/v1/irrigation/valves/3",
+			"/api/v1/irrigation/health/details",
+			"/api/v1/irrigation/healthz",
+			"/api/v1/weatherx/forecast",
+			// No health path is configured for billing
+			"/api/v1/billing/health",
+		})
+}
@@ -0,0 +1,61 @@
+package auth
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/canxphung/DA_CNPM_242/api_gateway/internal/store"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// RevocationStore records revoked tokens until they expire, keyed by their
+// jti claim or, for tokens without one, a hash of the token. Implementations
+// backed by a shared store (e.g. Redis) let several gateway instances see the
+// same revocations.
+type RevocationStore interface {
+	// Revoke marks the token as revoked until expiresAt
+	Revoke(key string, expiresAt time.Time)
+	// IsRevoked reports whether the token has been revoked
+	IsRevoked(key string) bool
+}
+
+// pruneEvery is how many revocations pass between sweeps of expired entries
+const pruneEvery = 100
+
+// MemoryRevocationStore is a RevocationStore local to this gateway instance
+type MemoryRevocationStore struct {
+	// Unbounded: evicting a live entry would make its token valid again.
+	// Entries only live as long as their token, and expired ones are pruned.
+	revoked *store.Store[struct{}]
+	writes  atomic.Int64
+}
+
+// NewMemoryRevocationStore creates an in-memory revocation store
+func NewMemoryRevocationStore(reg prometheus.Registerer) *MemoryRevocationStore {
+	return &MemoryRevocationStore{
+		revoked: store.New[struct{}]("revoked_tokens", 0, 0, reg),
+	}
+}
+
+// Revoke implements RevocationStore
+func (s *MemoryRevocationStore) Revoke(key string, expiresAt time.Time) {
+	// Tokens without an expiry stay revoked
+	var ttl time.Duration
+	if !expiresAt.IsZero() {
+		if ttl = time.Until(expiresAt); ttl <= 0 {
+			// Already expired, so validation rejects it anyway
+			return
+		}
+	}
+	s.revoked.SetWithTTL(key, struct{}{}, ttl)
+
+	if s.writes.Add(1)%pruneEvery == 0 {
+		s.revoked.DeleteExpired()
+	}
+}
+
+// IsRevoked implements RevocationStore
+func (s *MemoryRevocationStore) IsRevoked(key string) bool {
+	_, revoked := s.revoked.Get(key)
+	return revoked
+}
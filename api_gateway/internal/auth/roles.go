@@ -0,0 +1,84 @@
+package auth
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/canxphung/DA_CNPM_242/api_gateway/internal/config"
+	"go.uber.org/zap"
+)
+
+// roleRequirement restricts the paths at or below prefix to the given roles
+type roleRequirement struct {
+	prefix string
+	roles  map[string]bool
+}
+
+// buildRoleRequirements compiles the configured requirements, longest prefix first
+func buildRoleRequirements(requirements []config.RoleRequirement) []roleRequirement {
+	compiled := make([]roleRequirement, 0, len(requirements))
+	for _, requirement := range requirements {
+		roles := make(map[string]bool, len(requirement.Roles))
+		for _, role := range requirement.Roles {
+			roles[role] = true
+		}
+		compiled = append(compiled, roleRequirement{
+			prefix: strings.TrimRight(requirement.PathPrefix, "/"),
+			roles:  roles,
+		})
+	}
+	sort.SliceStable(compiled, func(i, j int) bool {
+		return len(compiled[i].prefix) > len(compiled[j].prefix)
+	})
+	return compiled
+}
+
+// authorize checks the user's role against the most specific requirement for
+// the request path, answering 403 when it is not allowed. Paths without a
+// requirement are open to every authenticated user.
+func (m *AuthMiddleware) authorize(w http.ResponseWriter, r *http.Request, user *User) bool {
+	for _, requirement := range m.roleRequirements {
+		// Whole segments only, so "/admin" does not cover "/administrators"
+		if r.URL.Path != requirement.prefix && !strings.HasPrefix(r.URL.Path, requirement.prefix+"/") {
+			continue
+		}
+		if requirement.roles[user.Role] {
+			return true
+		}
+		m.logger.Warn("Access denied: role not allowed",
+			zap.String("user_id", user.ID),
+			zap.String("role", user.Role),
+			zap.String("path", r.URL.Path),
+			zap.String("required_prefix", requirement.prefix),
+		)
+		http.Error(w, "Insufficient permissions", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// RequireRole answers 403 unless the authenticated user has one of roles.
+// It must run after Authenticate.
+func (m *AuthMiddleware) RequireRole(roles ...string) func(http.Handler) http.Handler {
+	allowed := make(map[string]bool, len(roles))
+	for _, role := range roles {
+		allowed[role] = true
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user := GetUserFromContext(r.Context())
+			if user == nil || !allowed[user.Role] {
+				fields := []zap.Field{zap.String("path", r.URL.Path), zap.Strings("required_roles", roles)}
+				if user != nil {
+					fields = append(fields, zap.String("user_id", user.ID), zap.String("role", user.Role))
+				}
+				m.logger.Warn("Access denied: role not allowed", fields...)
+				http.Error(w, "Insufficient permissions", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package auth
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/canxphung/DA_CNPM_242/api_gateway/internal/config"
+)
+
+func tokenForRole(t *testing.T, role string) string {
+	t.Helper()
+	claims := userAuthClaims()
+	claims["role"] = role
+	return signHS256(t, testSecret, claims)
+}
+
+func TestRoleRequirements(t *testing.T) {
+	middleware, _ := newTestAuthMiddleware(t, config.AuthConfig{
+		RoleRequirements: []config.RoleRequirement{
+			{PathPrefix: "/api/v1/user-auth/auth/admin", Roles: []string{"admin"}},
+			// A more specific prefix overrides the broader one
+			{PathPrefix: "/api/v1/user-auth/auth/admin/reports/", Roles: []string{"admin", "auditor"}},
+		},
+	})
+	handler := middleware.Authenticate(okHandler)
+
+	tests := []struct {
+		role string
+		path string
+		want int
+	}{
+		{"user", "/api/v1/user-auth/auth/admin", http.StatusForbidden},
+		{"user", "/api/v1/user-auth/auth/admin/users", http.StatusForbidden},
+		{"admin", "/api/v1/user-auth/auth/admin/users", http.StatusOK},
+		{"auditor", "/api/v1/user-auth/auth/admin/users", http.StatusForbidden},
+		{"auditor", "/api/v1/user-auth/auth/admin/reports/monthly", http.StatusOK},
+		{"admin", "/api/v1/user-auth/auth/admin/reports/monthly", http.StatusOK},
+		// Paths outside the prefixes, including ones that only share their text, are unaffected
+		{"user", "/api/v1/user-auth/auth/profile", http.StatusOK},
+		{"user", "/api/v1/user-auth/auth/administrators", http.StatusOK},
+		{"user", "/api/v1/core-operations/plants", http.StatusOK},
+	}
+	for _, tt := range tests {
+		if got := serveWithToken(handler, http.MethodGet, tt.path, tokenForRole(t, tt.role)); got != tt.want {
+			t.Errorf("%s on %s: status %d, want %d", tt.role, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestRequireRole(t *testing.T) {
+	middleware, _ := newTestAuthMiddleware(t, config.AuthConfig{})
+	handler := middleware.Authenticate(middleware.RequireRole("admin", "operator")(okHandler))
+
+	for role, want := range map[string]int{"admin": http.StatusOK, "operator": http.StatusOK, "user": http.StatusForbidden, "": http.StatusForbidden} {
+		if got := serveWithToken(handler, http.MethodGet, "/admin/loglevel", tokenForRole(t, role)); got != want {
+			t.Errorf("role %q: status %d, want %d", role, got, want)
+		}
+	}
+
+	// Without Authenticate in front there is no user, which is refused
+	if got := serveWithToken(middleware.RequireRole("admin")(okHandler), http.MethodGet, "/admin/loglevel", ""); got != http.StatusForbidden {
+		t.Errorf("no user: status %d, want 403", got)
+	}
+}
@@ -0,0 +1,62 @@
+// Package buildinfo reports which build of the gateway is running. Version,
+// Commit and BuildTime are set at build time:
+//
+//	go build -ldflags "-X github.com/canxphung/DA_CNPM_242/api_gateway/internal/buildinfo.Version=1.2.0 \
+//	  -X github.com/canxphung/DA_CNPM_242/api_gateway/internal/buildinfo.Commit=$(git rev-parse HEAD) \
+//	  -X github.com/canxphung/DA_CNPM_242/api_gateway/internal/buildinfo.BuildTime=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+package buildinfo
+
+import (
+	"runtime"
+	"runtime/debug"
+	"time"
+)
+
+// Set through -ldflags -X; the defaults mark a local build
+var (
+	Version   = "dev"
+	Commit    = "unknown"
+	BuildTime = "unknown"
+)
+
+// started is when the process started
+var started = time.Now()
+
+// Info describes the running build
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildTime string `json:"build_time"`
+	GoVersion string `json:"go_version"`
+}
+
+// Get returns the build information. Without an injected commit, the VCS
+// revision recorded by the Go toolchain is used when available.
+func Get() Info {
+	info := Info{
+		Version:   Version,
+		Commit:    Commit,
+		BuildTime: BuildTime,
+		GoVersion: runtime.Version(),
+	}
+	if info.Commit == "unknown" {
+		if build, ok := debug.ReadBuildInfo(); ok {
+			for _, setting := range build.Settings {
+				if setting.Key == "vcs.revision" {
+					info.Commit = setting.Value
+				}
+			}
+		}
+	}
+	return info
+}
+
+// StartTime returns when the process started
+func StartTime() time.Time {
+	return started
+}
+
+// Uptime returns how long the process has been running
+func Uptime() time.Duration {
+	return time.Since(started)
+}
@@ -1,7 +1,12 @@
 package config
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"log"
+	"net/http"
+	"net/netip"
+	"strings"
 	"time"
 
 	"github.com/joho/godotenv"
@@ -10,10 +15,23 @@ import (
 
 // Config holds all configuration for our application
 type Config struct {
-	Server   ServerConfig
-	Services ServicesConfig
-	JWT      JWTConfig
-	Logging  LoggingConfig
+	Server      ServerConfig
+	Services    ServicesConfig
+	JWT         JWTConfig
+	Logging     LoggingConfig
+	Proxy       ProxyConfig
+	Request     RequestConfig
+	Auth        AuthConfig
+	Metrics     MetricsConfig
+	Streaming   StreamingConfig
+	Concurrency ConcurrencyConfig
+	Overload    OverloadConfig
+	RateLimit   RateLimitConfig
+	HealthCheck HealthCheckConfig
+	Cache       CacheConfig
+	CORS        CORSConfig
+	Security    SecurityHeadersConfig
+	IPFilter    IPFilterConfig
 }
 
 // ServerConfig holds all server-related configuration
@@ -22,20 +40,179 @@ type ServerConfig struct {
 	ReadTimeout     time.Duration
 	WriteTimeout    time.Duration
 	ShutdownTimeout time.Duration
+	// StreamIdleTimeout cancels streaming responses that send nothing for this long (0 disables)
+	StreamIdleTimeout time.Duration
+	// MethodNotAllowedStatus is returned for a registered path hit with an
+	// unsupported method: 405 (with an Allow header) or 404
+	MethodNotAllowedStatus int
+	// TrustedProxyHops is the number of proxies in front of the gateway whose
+	// X-Forwarded-For entries are trusted when resolving the client IP
+	// (0 = use the connection's address and ignore X-Forwarded-For)
+	TrustedProxyHops int
 }
 
-// ServicesConfig holds the URLs for all microservices
+// ServicesConfig holds the backend services the gateway routes to
 type ServicesConfig struct {
+	// List is the routed services. When services.list is not configured it
+	// holds user-auth, core-operations and greenhouse-ai at the URLs below.
+	List []ServiceDefinition
+	// Service URLs are a single backend URL, or weighted stable and canary
+	// targets: "http://stable:8002:9;http://canary:8002:1"
 	UserAuthServiceURL      string
 	CoreOperationServiceURL string
 	AIServiceURL            string
+	// HealthPaths are each backend's health endpoint relative to its gateway
+	// prefix (/api/v1/<service>), keyed by service ID. Each one is public as an
+	// exact path and is what the health checker polls (see HealthCheckConfig.Paths).
+	HealthPaths map[string]string
+}
+
+// ServiceDefinition describes one backend service and how it is routed
+type ServiceDefinition struct {
+	// ID names the service in per-service settings, metrics and logs
+	ID string
+	// URL is the backend URL, or weighted stable and canary targets
+	URL string
+	// Prefixes are the gateway paths below /api/v1 routed to the service (default "/<id>")
+	Prefixes []string
+	// AuthRequired protects every path except the configured public paths;
+	// false makes the whole service public (default true)
+	AuthRequired bool
+	// Timeout bounds the wait for the backend's response headers; it is the
+	// default for proxy.services.<id>.responseHeaderTimeout (0 = built-in default)
+	Timeout time.Duration
+}
+
+// serviceDefinitionSpec is a services.list entry as written in the config file.
+// AuthRequired is a pointer so that leaving it out can default to true.
+type serviceDefinitionSpec struct {
+	ID           string
+	URL          string
+	Prefixes     []string
+	AuthRequired *bool
+	Timeout      time.Duration
+}
+
+// loadServiceList reads services.list, or builds the list of the three
+// built-in services from their URL settings when it is not configured
+func loadServiceList(services ServicesConfig) []ServiceDefinition {
+	if !viper.IsSet("services.list") {
+		return []ServiceDefinition{
+			{ID: "user-auth", URL: services.UserAuthServiceURL, Prefixes: []string{"/user-auth"}, AuthRequired: true},
+			{ID: "core-operations", URL: services.CoreOperationServiceURL, Prefixes: []string{"/core-operations", "/core-operation"}, AuthRequired: true},
+			{ID: "greenhouse-ai", URL: services.AIServiceURL, Prefixes: []string{"/greenhouse-ai"}, AuthRequired: true},
+		}
+	}
+
+	var specs []serviceDefinitionSpec
+	if err := viper.UnmarshalKey("services.list", &specs); err != nil {
+		log.Fatalf("Invalid services list: %s", err)
+	}
+
+	list := make([]ServiceDefinition, 0, len(specs))
+	seen := make(map[string]bool, len(specs))
+	for _, spec := range specs {
+		if spec.ID == "" || spec.URL == "" {
+			log.Fatalf("Services need an id and a url: %+v", spec)
+		}
+		if seen[spec.ID] {
+			log.Fatalf("Duplicate service id in services list: %s", spec.ID)
+		}
+		seen[spec.ID] = true
+		if spec.Timeout < 0 {
+			log.Fatalf("Invalid timeout for service %s: %s", spec.ID, spec.Timeout)
+		}
+
+		service := ServiceDefinition{
+			ID:           spec.ID,
+			URL:          spec.URL,
+			AuthRequired: spec.AuthRequired == nil || *spec.AuthRequired,
+			Timeout:      spec.Timeout,
+		}
+		if len(spec.Prefixes) == 0 {
+			spec.Prefixes = []string{spec.ID}
+		}
+		for _, prefix := range spec.Prefixes {
+			if prefix = strings.Trim(prefix, "/"); prefix == "" {
+				log.Fatalf("Empty prefix for service %s", spec.ID)
+			}
+			service.Prefixes = append(service.Prefixes, "/"+prefix)
+		}
+		list = append(list, service)
+	}
+	return list
 }
 
 // JWTConfig holds JWT configuration
 type JWTConfig struct {
-	SecretKey              string
+	SecretKey string
+	// PublicKeyPath is a PEM-encoded RSA public key used to validate RS256
+	// tokens from the identity provider (empty accepts HS256 tokens only)
+	PublicKeyPath string
+	// JWKS fetches rotating RS256 keys from the identity provider
+	JWKS                   JWKSConfig
 	ExpirationMinutes      int
 	RefreshExpirationHours int
+	// UserIDClaims lists the claims holding the user identifier, in order of preference
+	UserIDClaims []string
+	// Leeway tolerates clock skew when checking the exp, nbf and iat claims
+	Leeway time.Duration
+	// Issuer is set on locally issued tokens and required on HS256 tokens
+	// (empty = not checked; user_auth_service tokens carry no iss)
+	Issuer string
+	// IdentityProviderIssuer is required on RS256 tokens (empty = not checked)
+	IdentityProviderIssuer string
+	// Audience is set on locally issued tokens and required on all tokens (empty = not checked)
+	Audience string
+}
+
+// JWKSConfig controls fetching signing keys from a JSON Web Key Set URL.
+// Tokens are matched to a key by their kid header.
+type JWKSConfig struct {
+	// URL of the key set (empty disables JWKS)
+	URL string
+	// RefreshInterval is how often the key set is fetched again
+	RefreshInterval time.Duration
+	// MinRefreshInterval limits the refreshes triggered by tokens with an unknown kid
+	MinRefreshInterval time.Duration
+	Timeout            time.Duration
+	// WarmupTimeout bounds the fetch at startup; a failed warmup does not stop the gateway
+	WarmupTimeout time.Duration
+}
+
+// CORSConfig holds the Cross-Origin Resource Sharing settings shared by the
+// CORS middleware and the proxy's own responses
+type CORSConfig struct {
+	// AllowedOrigins lists the allowed origins; "*" allows any origin and a
+	// "*" inside an entry (e.g. "http://*.localhost:5173") matches any text there
+	AllowedOrigins []string
+}
+
+// SecurityHeadersConfig sets the security headers added to gateway responses.
+// An empty value disables that header; headers set by a backend are kept.
+type SecurityHeadersConfig struct {
+	// ContentTypeOptions sends X-Content-Type-Options: nosniff
+	ContentTypeOptions bool
+	FrameOptions       string
+	ReferrerPolicy     string
+	// ContentSecurityPolicy is off by default: backends serve their own docs UIs
+	ContentSecurityPolicy string
+	// StrictTransportSecurity is only sent on requests received over TLS
+	StrictTransportSecurity string
+}
+
+// IPFilterConfig restricts paths by client IP
+type IPFilterConfig struct {
+	Rules []IPFilterRule
+}
+
+// IPFilterRule applies to requests at or below PathPrefix ("/" for all).
+// Entries are CIDR ranges or single IPs, IPv4 or IPv6. A client in Deny is
+// refused; when Allow is set, so is every client outside it.
+type IPFilterRule struct {
+	PathPrefix string
+	Allow      []string
+	Deny       []string
 }
 
 // LoggingConfig holds logging configuration
@@ -44,6 +221,353 @@ type LoggingConfig struct {
 	Format string
 }
 
+// ProxyConfig holds configuration for the reverse proxy layer
+type ProxyConfig struct {
+	// TraceLevel controls the consolidated per-request routing trace.
+	// Supported values: "off", "debug", "info".
+	TraceLevel string
+	// UserAgent is sent to backends instead of the client's User-Agent.
+	// "{service}" is replaced by the service ID; empty forwards the client's value.
+	UserAgent string
+	// PreserveUserAgent copies the client's User-Agent into X-Original-User-Agent
+	PreserveUserAgent bool
+	// SafeRoutes lets routes opt extra methods into safe (retryable, cacheable)
+	// handling; GET, HEAD and OPTIONS are always safe
+	SafeRoutes []SafeRoute
+	// Retry replays safe requests that failed to reach the backend
+	Retry RetryConfig
+	// Services holds per-service proxy settings keyed by service ID
+	Services map[string]ServiceProxyConfig
+}
+
+// SafeRoute marks Methods on gateway paths starting with PathPrefix as safe,
+// e.g. a POST query endpoint with no side effects
+type SafeRoute struct {
+	PathPrefix string
+	Methods    []string
+}
+
+// defaultRewriteRules reproduce the backends' path layouts when a service has
+// no rewrite configured. They run after the service's gateway prefixes are stripped.
+var defaultRewriteRules = map[string][]RewriteRule{
+	"user-auth": {
+		{Name: "api-v1", AddPrefix: "/api/v1"},
+	},
+	"core-operations": {
+		{Name: "add-api-prefix", AddPrefix: "/api", Unless: []string{"/api/", "/health", "/version", "/docs"}},
+	},
+	"greenhouse-ai": {
+		{Name: "add-api-prefix", AddPrefix: "/api", Unless: []string{"/api", "/health", "/docs"}},
+	},
+}
+
+// defaultServiceRules strips the service's gateway prefixes and then applies
+// its built-in rules, if it has any
+func defaultServiceRules(service ServiceDefinition) []RewriteRule {
+	var rules []RewriteRule
+	for i, prefix := range service.Prefixes {
+		name := "strip-service-prefix"
+		if i > 0 {
+			name = "strip-service-alias"
+		}
+		rules = append(rules, RewriteRule{Name: name, StripPrefix: prefix})
+	}
+	return append(rules, defaultRewriteRules[service.ID]...)
+}
+
+// defaultAIMaxStreams is the streaming session cap applied to greenhouse-ai when none is configured
+const defaultAIMaxStreams = 20
+
+// defaultAIColdStartIdle is how long greenhouse-ai may sit idle before it is treated as cold
+const defaultAIColdStartIdle = 10 * time.Minute
+
+// ServiceProxyConfig holds proxy settings for a single backend service
+type ServiceProxyConfig struct {
+	Versioning VersioningConfig
+	Dedup      DedupConfig
+	// MaxStreams caps concurrent streaming (SSE/upgrade) sessions to this service; 0 = unlimited
+	MaxStreams int
+	// UserAgent overrides ProxyConfig.UserAgent for this service
+	UserAgent string
+	// ResponseHeaderTimeout bounds the wait for the backend's response headers (0 = built-in default)
+	ResponseHeaderTimeout time.Duration
+	// ColdStart retries requests that time out while the backend is warming up
+	ColdStart ColdStartConfig
+	// MaxResponseBytes aborts backend responses larger than this (0 = unlimited)
+	MaxResponseBytes int64
+	// LimitStreamingResponses applies MaxResponseBytes to event streams too
+	LimitStreamingResponses bool
+	// Rewrite maps the gateway path, after /api/v1 is removed, to the backend
+	// path. Rules run in order, each on the previous rule's result.
+	Rewrite []RewriteRule
+}
+
+// RewriteRule is one step of a service's path rewrite. A rule applies when the
+// path starts with one of When (or When is empty) and with none of Unless;
+// it then strips StripPrefix, prepends AddPrefix and replaces Regex matches
+// with Replacement, in that order. StripPrefix matches whole path segments,
+// When and Unless are plain string prefixes.
+type RewriteRule struct {
+	// Name identifies the rule in the routing trace
+	Name        string
+	When        []string
+	Unless      []string
+	StripPrefix string
+	AddPrefix   string
+	Regex       string
+	Replacement string
+}
+
+// RetryConfig retries safe requests on connection errors and 502/503/504
+// responses, with exponential backoff and jitter between attempts
+type RetryConfig struct {
+	// MaxRetries is the number of retries after the first attempt (0 disables retries)
+	MaxRetries int
+	// BaseDelay is the backoff before the first retry; it doubles for each further one
+	BaseDelay time.Duration
+	// MaxDelay caps the backoff between attempts
+	MaxDelay time.Duration
+	// MaxBodyBytes is the largest request body buffered for replay; larger requests are not retried
+	MaxBodyBytes int64
+}
+
+// ColdStartConfig retries a request once when the backend's response headers
+// time out during its warmup window: after gateway start, or after the backend
+// has answered nothing for IdleAfter (e.g. a model that was unloaded).
+type ColdStartConfig struct {
+	Enabled   bool
+	IdleAfter time.Duration
+}
+
+// DedupConfig collapses identical concurrent writes (same client, path and body)
+// into a single backend call. Disabled unless Enabled is set.
+type DedupConfig struct {
+	Enabled bool
+	// Window keeps a completed write's response for replaying late duplicates (default 500ms)
+	Window time.Duration
+	// MaxBodyBytes is the largest body considered for deduplication (default 64KiB)
+	MaxBodyBytes int64
+	// MaxEntries caps the tracked writes; the least recently used are evicted (default 10000)
+	MaxEntries int
+}
+
+// VersioningConfig routes requests to alternative backends based on a version header.
+// Requests without the header, or with an unknown version, use the service's default backend.
+type VersioningConfig struct {
+	// Header carries the requested API version (default "Accept-Version")
+	Header string
+	// Versions maps a header value to the backend serving that version
+	Versions map[string]VersionTarget
+}
+
+// VersionTarget describes the backend for one API version
+type VersionTarget struct {
+	// URL of the backend; empty keeps the service's default backend
+	URL string
+	// PathPrefix is prepended to the rewritten backend path
+	PathPrefix string
+}
+
+// RequestConfig holds validation settings for incoming request bodies
+type RequestConfig struct {
+	// ValidateContentLength buffers small fixed-length bodies and rejects
+	// requests whose body is shorter than the declared Content-Length
+	ValidateContentLength bool
+	// ContentLengthBufferLimit is the largest declared body size (bytes) that is buffered for validation
+	ContentLengthBufferLimit int64
+	// MaxBodyBytes caps request bodies; larger ones get 413. 0 disables the cap.
+	MaxBodyBytes int64
+	// BodyLimits override MaxBodyBytes below a path prefix; the longest matching prefix wins
+	BodyLimits []BodyLimit
+}
+
+// BodyLimit caps request bodies at or below PathPrefix at MaxBytes (0 = no cap)
+type BodyLimit struct {
+	PathPrefix string
+	MaxBytes   int64
+}
+
+// AuthConfig holds gateway authentication settings
+type AuthConfig struct {
+	// PublicPaths are the public (unauthenticated) path specs keyed by service
+	// ID, or "gateway" for the gateway's own endpoints: the built-in set, with
+	// any service's list replaced by its configured paths
+	PublicPaths map[string][]string
+	// PublicPathOverrides adjusts the public paths, keyed like PublicPaths
+	PublicPathOverrides map[string]PublicPathOverride
+	Revocation          RevocationConfig
+	// APIKeys authenticate server-to-server clients sending X-API-Key
+	APIKeys []APIKey
+	// RoleRequirements restrict protected paths to certain roles
+	RoleRequirements []RoleRequirement
+}
+
+// RoleRequirement allows only the listed roles at or below PathPrefix.
+// When several prefixes match a path, the longest one applies.
+type RoleRequirement struct {
+	PathPrefix string
+	Roles      []string
+}
+
+// APIKey maps an API key to the user it authenticates as. Only the key's
+// SHA-256 hash is configured, hex-encoded.
+type APIKey struct {
+	// Name identifies the key in logs
+	Name   string
+	Hash   string
+	UserID string
+	Role   string
+}
+
+// RevocationConfig controls token revocation
+type RevocationConfig struct {
+	// LogoutPaths are the gateway paths whose successful POST revokes the caller's token
+	LogoutPaths []string
+}
+
+// PublicPathOverride replaces, adds or removes public paths for one service.
+// A path ending in "/*" is a prefix match on it and everything below it
+// (whole segments only); any other path is an exact match.
+type PublicPathOverride struct {
+	// Paths replaces the service's built-in public paths when set
+	Paths  []string
+	Add    []string
+	Remove []string
+}
+
+// MetricsConfig holds Prometheus metrics configuration
+type MetricsConfig struct {
+	// SummaryQuantiles are the request duration quantiles exported per service
+	// by the latency summary, in addition to the histogram
+	SummaryQuantiles []float64
+	// SummaryServices limits the summary to these service IDs; empty means all services
+	SummaryServices []string
+}
+
+// StreamingConfig holds limits for long-lived streaming connections
+type StreamingConfig struct {
+	// MaxConnections caps concurrent streaming connections across the gateway (0 = unlimited)
+	MaxConnections int
+	// Routes are path prefixes whose responses are always treated as streams
+	Routes []string
+}
+
+// ConcurrencyConfig holds the in-flight request limit and its priority reservation
+type ConcurrencyConfig struct {
+	// MaxInFlight caps concurrent non-streaming requests across the gateway (0 = unlimited)
+	MaxInFlight int
+	// ReservedPriority slots out of MaxInFlight are usable only by priority requests
+	ReservedPriority int
+	// QueueTimeout is how long a request waits for a free slot before it is rejected
+	QueueTimeout time.Duration
+	// PriorityPaths are path prefixes (health checks, control commands) that may use the reserved slots
+	PriorityPaths []string
+}
+
+// OverloadConfig shapes the response shared by all load-protection features
+type OverloadConfig struct {
+	// RateLimitStatus is returned when a client exceeds its request rate
+	RateLimitStatus int
+	// CapacityStatus is returned when a concurrency or streaming cap is reached
+	CapacityStatus int
+	// RetryAfter is advertised when the rejecting mechanism has no backoff of its own
+	RetryAfter time.Duration
+}
+
+// RateLimitConfig holds the per-client token bucket limits
+type RateLimitConfig struct {
+	// RPS is the sustained requests per second allowed per client IP, for every
+	// request and route, checked before authentication
+	RPS float64
+	// Burst is how many requests a client may make at once above the sustained rate
+	Burst int
+	// UserRPS and UserBurst additionally apply to authenticated /api/v1
+	// requests, keyed by user ID.
+	// A UserRPS of 0 applies RPS and Burst per user instead.
+	// Rate limiting is disabled when both RPS and UserRPS are 0.
+	UserRPS   float64
+	UserBurst int
+	// MaxClients caps the number of client buckets kept in memory
+	MaxClients int
+	// Reads and Writes additionally limit each client per service, keyed by
+	// user ID when authenticated and client IP otherwise. Reads are GET, HEAD
+	// and OPTIONS requests, writes every other method. They apply to every
+	// service unless Services overrides them; an RPS of 0 is unlimited.
+	Reads  MethodRateLimit
+	Writes MethodRateLimit
+	// Services overrides Reads and Writes per service ID
+	Services map[string]ServiceRateLimit
+}
+
+// MethodRateLimit is the token bucket for one class of methods
+type MethodRateLimit struct {
+	RPS   float64
+	Burst int
+}
+
+// ServiceRateLimit overrides the read and write limits for one service;
+// a class with no RPS set keeps the default
+type ServiceRateLimit struct {
+	Reads  MethodRateLimit
+	Writes MethodRateLimit
+}
+
+// withDefault returns the limit, or def when no RPS is set. A limit without
+// a burst takes the default's.
+func (l MethodRateLimit) withDefault(def MethodRateLimit) MethodRateLimit {
+	if l.RPS == 0 {
+		return def
+	}
+	if l.Burst == 0 {
+		l.Burst = def.Burst
+	}
+	return l
+}
+
+// HealthCheckConfig controls the background health checks of the backends
+type HealthCheckConfig struct {
+	// Interval is the time between checks of each backend
+	Interval time.Duration
+	// Timeout bounds a single check
+	Timeout time.Duration
+	// FailureThreshold is how many consecutive failed checks mark a backend down
+	FailureThreshold int
+	// FailFast answers requests to a down backend with 503 instead of proxying them
+	FailFast bool
+	// Paths override the health endpoint checked on a backend, as a path on
+	// the backend itself (not below the gateway prefix), keyed by service ID.
+	// Without an override the checked path is the service's
+	// Services.HealthPaths entry mapped through its rewrite rules.
+	Paths map[string]string
+}
+
+// CacheConfig holds the in-memory response cache for GET routes.
+// Caching is disabled when no routes are configured.
+type CacheConfig struct {
+	// MaxEntries caps the cached responses; the least recently used are evicted
+	MaxEntries int
+	// MaxBodyBytes is the largest response body that is cached, and the
+	// largest request body a cache key is computed from
+	MaxBodyBytes int64
+	// Routes are the cacheable gateway paths; the first matching prefix applies
+	Routes []CacheRoute
+}
+
+// CacheRoute enables caching of successful responses to safe requests below
+// PathPrefix: GET and HEAD, and the methods proxy.safeRoutes declares safe
+type CacheRoute struct {
+	PathPrefix string
+	TTL        time.Duration
+	// QueryParams are the query parameters that select a distinct response;
+	// empty means the whole query string is part of the cache key
+	QueryParams []string
+	// Vary lists request headers whose values select a distinct response (e.g. Accept-Version)
+	Vary []string
+	// Shared serves one cached response to every client; otherwise each
+	// authenticated user (and anonymous clients as a group) gets their own entry
+	Shared bool
+}
+
 // LoadConfig loads the configuration from environment variables and config files
 func LoadConfig() *Config {
 	// Load .env file if it exists
@@ -77,23 +601,113 @@ func LoadConfig() *Config {
 	viper.SetDefault("server.readTimeout", "30s")
 	viper.SetDefault("server.writeTimeout", "30s")
 	viper.SetDefault("server.shutdownTimeout", "5s")
+	viper.SetDefault("server.streamIdleTimeout", "30s")
 
 	viper.SetDefault("jwt.expirationMinutes", 30)
 	viper.SetDefault("jwt.refreshExpirationHours", 24)
+	viper.SetDefault("jwt.userIDClaims", []string{"user_id", "sub"})
+	viper.SetDefault("jwt.leeway", "30s")
+	viper.SetDefault("jwt.issuer", "")
+	viper.SetDefault("auth.revocation.logoutPaths", []string{"/api/v1/user-auth/auth/logout"})
+
+	viper.SetDefault("jwt.jwks.refreshInterval", "15m")
+	viper.SetDefault("jwt.jwks.minRefreshInterval", "30s")
+	viper.SetDefault("jwt.jwks.timeout", "5s")
+	viper.SetDefault("jwt.jwks.warmupTimeout", "10s")
+
+	viper.SetDefault("cors.allowedOrigins", []string{
+		"http://localhost:5173", // Vite default dev server
+		"http://localhost:3000", // Create React App default
+		"http://localhost:3001", // Alternative port
+		"http://localhost:4173", // Vite preview
+		"http://127.0.0.1:5173", // Alternative localhost
+		"http://127.0.0.1:3000", // Alternative localhost
+	})
+
+	viper.SetDefault("security.contentTypeOptions", true)
+	viper.SetDefault("security.frameOptions", "DENY")
+	viper.SetDefault("security.referrerPolicy", "strict-origin-when-cross-origin")
+	viper.SetDefault("security.strictTransportSecurity", "max-age=31536000; includeSubDomains")
 
 	viper.SetDefault("logging.level", "info")
 	viper.SetDefault("logging.format", "json")
 
+	viper.SetDefault("server.methodNotAllowedStatus", http.StatusMethodNotAllowed)
+
+	viper.SetDefault("services.healthPaths.user-auth", "/monitoring/health")
+	viper.SetDefault("services.healthPaths.core-operations", "/health")
+	viper.SetDefault("services.healthPaths.greenhouse-ai", "/health")
+
+	viper.SetDefault("proxy.traceLevel", "debug")
+	viper.SetDefault("proxy.userAgent", "api-gateway ({service})")
+	viper.SetDefault("proxy.preserveUserAgent", true)
+	viper.SetDefault("proxy.retry.maxRetries", 2)
+	viper.SetDefault("proxy.retry.baseDelay", "100ms")
+	viper.SetDefault("proxy.retry.maxDelay", "2s")
+	viper.SetDefault("proxy.retry.maxBodyBytes", 1<<20)
+
+	viper.SetDefault("metrics.summaryQuantiles", []float64{0.5, 0.9, 0.99})
+
+	viper.SetDefault("streaming.maxConnections", 100)
+	viper.SetDefault("streaming.routes", []string{"/debug/stream"})
+
+	viper.SetDefault("concurrency.maxInFlight", 0)
+	viper.SetDefault("concurrency.reservedPriority", 10)
+	viper.SetDefault("concurrency.queueTimeout", "2s")
+	viper.SetDefault("concurrency.priorityPaths", []string{
+		"/health",
+		"/api/v1/health",
+		"/api/v1/status",
+		"/api/v1/core-operations/control/",
+		"/api/v1/core-operation/control/",
+	})
+
+	viper.SetDefault("overload.rateLimitStatus", http.StatusTooManyRequests)
+	viper.SetDefault("overload.capacityStatus", http.StatusServiceUnavailable)
+	viper.SetDefault("overload.retryAfter", "1s")
+
+	viper.SetDefault("rateLimit.rps", 0)
+	viper.SetDefault("rateLimit.burst", 20)
+	viper.SetDefault("rateLimit.userRPS", 0)
+	viper.SetDefault("rateLimit.userBurst", 40)
+	viper.SetDefault("rateLimit.maxClients", 100000)
+	viper.SetDefault("rateLimit.reads.rps", 0)
+	viper.SetDefault("rateLimit.reads.burst", 40)
+	viper.SetDefault("rateLimit.writes.rps", 0)
+	viper.SetDefault("rateLimit.writes.burst", 10)
+
+	viper.SetDefault("cache.maxEntries", 1000)
+	viper.SetDefault("cache.maxBodyBytes", 256*1024)
+
+	viper.SetDefault("healthCheck.interval", "10s")
+	viper.SetDefault("healthCheck.timeout", "3s")
+	viper.SetDefault("healthCheck.failureThreshold", 2)
+	viper.SetDefault("healthCheck.failFast", true)
+
+	viper.SetDefault("request.validateContentLength", false)
+	viper.SetDefault("request.contentLengthBufferLimit", 1<<20)
+	viper.SetDefault("request.maxBodyBytes", 10<<20)
+
 	// Bind environment variables
 	viper.AutomaticEnv()
 	viper.SetEnvPrefix("GATEWAY")
 
 	// Map environment variables to config fields
 	viper.BindEnv("server.port", "GATEWAY_PORT")
+	viper.BindEnv("server.trustedProxyHops", "GATEWAY_TRUSTED_PROXY_HOPS")
 	viper.BindEnv("services.userAuthServiceURL", "USER_AUTH_SERVICE_URL")
 	viper.BindEnv("services.coreOperationServiceURL", "CORE_OPERATION_SERVICE_URL")
 	viper.BindEnv("services.aiServiceURL", "AI_SERVICE_URL")
 	viper.BindEnv("jwt.secretKey", "JWT_SECRET_KEY")
+	viper.BindEnv("cors.allowedOrigins", "GATEWAY_CORS_ALLOWED_ORIGINS")
+	viper.BindEnv("jwt.publicKeyPath", "JWT_PUBLIC_KEY_PATH")
+	viper.BindEnv("jwt.jwks.url", "JWT_JWKS_URL")
+	viper.BindEnv("jwt.identityProviderIssuer", "JWT_IDP_ISSUER")
+	viper.BindEnv("jwt.audience", "JWT_AUDIENCE")
+	viper.BindEnv("rateLimit.rps", "GATEWAY_RATE_LIMIT_RPS")
+	viper.BindEnv("rateLimit.burst", "GATEWAY_RATE_LIMIT_BURST")
+	viper.BindEnv("rateLimit.userRPS", "GATEWAY_RATE_LIMIT_USER_RPS")
+	viper.BindEnv("rateLimit.userBurst", "GATEWAY_RATE_LIMIT_USER_BURST")
 
 	// Try to read the config file
 	if err := viper.ReadInConfig(); err != nil {
@@ -122,23 +736,82 @@ func LoadConfig() *Config {
 		log.Fatalf("Invalid shutdown timeout: %s", err)
 	}
 
+	streamIdleTimeout, err := time.ParseDuration(viper.GetString("server.streamIdleTimeout"))
+	if err != nil {
+		log.Fatalf("Invalid stream idle timeout: %s", err)
+	}
+
 	config.Server = ServerConfig{
-		Port:            viper.GetString("server.port"),
-		ReadTimeout:     readTimeout,
-		WriteTimeout:    writeTimeout,
-		ShutdownTimeout: shutdownTimeout,
+		Port:                   viper.GetString("server.port"),
+		ReadTimeout:            readTimeout,
+		WriteTimeout:           writeTimeout,
+		ShutdownTimeout:        shutdownTimeout,
+		StreamIdleTimeout:      streamIdleTimeout,
+		MethodNotAllowedStatus: viper.GetInt("server.methodNotAllowedStatus"),
+		TrustedProxyHops:       viper.GetInt("server.trustedProxyHops"),
+	}
+	if config.Server.TrustedProxyHops < 0 {
+		log.Fatalf("Invalid server.trustedProxyHops %d: must not be negative", config.Server.TrustedProxyHops)
+	}
+
+	if err := viper.UnmarshalKey("ipFilter.rules", &config.IPFilter.Rules); err != nil {
+		log.Fatalf("Invalid IP filter rules: %s", err)
+	}
+	for _, rule := range config.IPFilter.Rules {
+		if !strings.HasPrefix(rule.PathPrefix, "/") {
+			log.Fatalf("Invalid IP filter pathPrefix %q: must start with /", rule.PathPrefix)
+		}
+		for _, entry := range append(append([]string(nil), rule.Allow...), rule.Deny...) {
+			if _, err := netip.ParsePrefix(entry); err != nil {
+				if _, err := netip.ParseAddr(entry); err != nil {
+					log.Fatalf("Invalid IP filter entry %q for %s: must be a CIDR range or IP", entry, rule.PathPrefix)
+				}
+			}
+		}
 	}
 
 	config.Services = ServicesConfig{
 		UserAuthServiceURL:      viper.GetString("services.userAuthServiceURL"),
 		CoreOperationServiceURL: viper.GetString("services.coreOperationServiceURL"),
 		AIServiceURL:            viper.GetString("services.aiServiceURL"),
+		HealthPaths:             make(map[string]string),
+	}
+	config.Services.List = loadServiceList(config.Services)
+	for _, service := range config.Services.List {
+		if healthPath := viper.GetString("services.healthPaths." + service.ID); healthPath != "" {
+			config.Services.HealthPaths[service.ID] = "/" + strings.TrimLeft(healthPath, "/")
+		}
 	}
 
 	config.JWT = JWTConfig{
-		SecretKey:              viper.GetString("jwt.secretKey"),
+		SecretKey:     viper.GetString("jwt.secretKey"),
+		PublicKeyPath: viper.GetString("jwt.publicKeyPath"),
+		JWKS: JWKSConfig{
+			URL:                viper.GetString("jwt.jwks.url"),
+			RefreshInterval:    viper.GetDuration("jwt.jwks.refreshInterval"),
+			MinRefreshInterval: viper.GetDuration("jwt.jwks.minRefreshInterval"),
+			Timeout:            viper.GetDuration("jwt.jwks.timeout"),
+			WarmupTimeout:      viper.GetDuration("jwt.jwks.warmupTimeout"),
+		},
 		ExpirationMinutes:      viper.GetInt("jwt.expirationMinutes"),
 		RefreshExpirationHours: viper.GetInt("jwt.refreshExpirationHours"),
+		UserIDClaims:           viper.GetStringSlice("jwt.userIDClaims"),
+		Leeway:                 viper.GetDuration("jwt.leeway"),
+		Issuer:                 viper.GetString("jwt.issuer"),
+		IdentityProviderIssuer: viper.GetString("jwt.identityProviderIssuer"),
+		Audience:               viper.GetString("jwt.audience"),
+	}
+
+	config.CORS = CORSConfig{
+		AllowedOrigins: stringList("cors.allowedOrigins"),
+	}
+
+	config.Security = SecurityHeadersConfig{
+		ContentTypeOptions:      viper.GetBool("security.contentTypeOptions"),
+		FrameOptions:            viper.GetString("security.frameOptions"),
+		ReferrerPolicy:          viper.GetString("security.referrerPolicy"),
+		ContentSecurityPolicy:   viper.GetString("security.contentSecurityPolicy"),
+		StrictTransportSecurity: viper.GetString("security.strictTransportSecurity"),
 	}
 
 	config.Logging = LoggingConfig{
@@ -146,22 +819,320 @@ func LoadConfig() *Config {
 		Format: viper.GetString("logging.format"),
 	}
 
+	config.Proxy = ProxyConfig{
+		TraceLevel:        viper.GetString("proxy.traceLevel"),
+		UserAgent:         viper.GetString("proxy.userAgent"),
+		PreserveUserAgent: viper.GetBool("proxy.preserveUserAgent"),
+		Retry: RetryConfig{
+			MaxRetries:   viper.GetInt("proxy.retry.maxRetries"),
+			BaseDelay:    viper.GetDuration("proxy.retry.baseDelay"),
+			MaxDelay:     viper.GetDuration("proxy.retry.maxDelay"),
+			MaxBodyBytes: viper.GetInt64("proxy.retry.maxBodyBytes"),
+		},
+	}
+	if retry := config.Proxy.Retry; retry.MaxRetries < 0 || retry.BaseDelay < 0 || retry.MaxDelay < retry.BaseDelay || retry.MaxBodyBytes < 0 {
+		log.Fatalf("Invalid proxy retry configuration: %+v", retry)
+	}
+	if err := viper.UnmarshalKey("proxy.services", &config.Proxy.Services); err != nil {
+		log.Fatalf("Invalid per-service proxy configuration: %s", err)
+	}
+	if err := viper.UnmarshalKey("proxy.safeRoutes", &config.Proxy.SafeRoutes); err != nil {
+		log.Fatalf("Invalid safe route configuration: %s", err)
+	}
+	for _, route := range config.Proxy.SafeRoutes {
+		if route.PathPrefix == "" || len(route.Methods) == 0 {
+			log.Fatalf("Safe routes need a pathPrefix and at least one method: %+v", route)
+		}
+	}
+	if config.Proxy.Services == nil {
+		config.Proxy.Services = make(map[string]ServiceProxyConfig)
+	}
+	// Streaming to the AI service is capped unless configured otherwise,
+	// since every inference stream holds a model worker
+	if !viper.IsSet("proxy.services.greenhouse-ai.maxStreams") {
+		aiProxy := config.Proxy.Services["greenhouse-ai"]
+		aiProxy.MaxStreams = defaultAIMaxStreams
+		config.Proxy.Services["greenhouse-ai"] = aiProxy
+	}
+	// The AI service loads models lazily, so its first response after idling can be slow
+	if !viper.IsSet("proxy.services.greenhouse-ai.coldStart.enabled") {
+		aiProxy := config.Proxy.Services["greenhouse-ai"]
+		aiProxy.ColdStart.Enabled = true
+		if aiProxy.ColdStart.IdleAfter == 0 {
+			aiProxy.ColdStart.IdleAfter = defaultAIColdStartIdle
+		}
+		config.Proxy.Services["greenhouse-ai"] = aiProxy
+	}
+	// Every routed service gets proxy settings, which is also what makes its ID valid
+	for _, service := range config.Services.List {
+		serviceProxy := config.Proxy.Services[service.ID]
+		if !viper.IsSet("proxy.services." + service.ID + ".rewrite") {
+			serviceProxy.Rewrite = defaultServiceRules(service)
+		}
+		if serviceProxy.ResponseHeaderTimeout == 0 {
+			serviceProxy.ResponseHeaderTimeout = service.Timeout
+		}
+		config.Proxy.Services[service.ID] = serviceProxy
+	}
+	for service, serviceProxy := range config.Proxy.Services {
+		if serviceProxy.MaxStreams < 0 {
+			log.Fatalf("Invalid maxStreams for service %s: %d", service, serviceProxy.MaxStreams)
+		}
+		if serviceProxy.MaxResponseBytes < 0 {
+			log.Fatalf("Invalid maxResponseBytes for service %s: %d", service, serviceProxy.MaxResponseBytes)
+		}
+		if serviceProxy.ResponseHeaderTimeout < 0 {
+			log.Fatalf("Invalid responseHeaderTimeout for service %s: %s", service, serviceProxy.ResponseHeaderTimeout)
+		}
+		if serviceProxy.ColdStart.Enabled && serviceProxy.ColdStart.IdleAfter <= 0 {
+			log.Fatalf("Invalid coldStart.idleAfter for service %s: must be positive", service)
+		}
+	}
+
+	config.Request = RequestConfig{
+		ValidateContentLength:    viper.GetBool("request.validateContentLength"),
+		ContentLengthBufferLimit: viper.GetInt64("request.contentLengthBufferLimit"),
+		MaxBodyBytes:             viper.GetInt64("request.maxBodyBytes"),
+	}
+	if config.Request.MaxBodyBytes < 0 {
+		log.Fatalf("Invalid request.maxBodyBytes %d: must not be negative", config.Request.MaxBodyBytes)
+	}
+	if err := viper.UnmarshalKey("request.bodyLimits", &config.Request.BodyLimits); err != nil {
+		log.Fatalf("Invalid request body limits: %s", err)
+	}
+	for _, limit := range config.Request.BodyLimits {
+		if !strings.HasPrefix(limit.PathPrefix, "/") {
+			log.Fatalf("Invalid request body limit pathPrefix %q: must start with /", limit.PathPrefix)
+		}
+		if limit.MaxBytes < 0 {
+			log.Fatalf("Invalid request body limit maxBytes %d for %s: must not be negative", limit.MaxBytes, limit.PathPrefix)
+		}
+	}
+
+	if err := viper.UnmarshalKey("auth.publicPaths", &config.Auth.PublicPathOverrides); err != nil {
+		log.Fatalf("Invalid public path overrides: %s", err)
+	}
+	config.Auth.PublicPaths = make(map[string][]string, len(defaultPublicPaths))
+	for service, paths := range defaultPublicPaths {
+		config.Auth.PublicPaths[service] = paths
+	}
+	for service, override := range config.Auth.PublicPathOverrides {
+		if override.Paths != nil {
+			config.Auth.PublicPaths[service] = override.Paths
+		}
+	}
+	config.Auth.Revocation.LogoutPaths = viper.GetStringSlice("auth.revocation.logoutPaths")
+	if err := viper.UnmarshalKey("auth.apiKeys", &config.Auth.APIKeys); err != nil {
+		log.Fatalf("Invalid API keys: %s", err)
+	}
+	if err := viper.UnmarshalKey("auth.roleRequirements", &config.Auth.RoleRequirements); err != nil {
+		log.Fatalf("Invalid role requirements: %s", err)
+	}
+	for _, requirement := range config.Auth.RoleRequirements {
+		if !strings.HasPrefix(requirement.PathPrefix, "/") || len(requirement.Roles) == 0 {
+			log.Fatalf("Invalid role requirement for %q: pathPrefix must start with / and roles must not be empty", requirement.PathPrefix)
+		}
+	}
+	apiKeyHashes := map[string]bool{}
+	for i, key := range config.Auth.APIKeys {
+		key.Hash = strings.ToLower(strings.TrimSpace(key.Hash))
+		if decoded, err := hex.DecodeString(key.Hash); err != nil || len(decoded) != sha256.Size {
+			log.Fatalf("Invalid hash for API key %q: must be a hex-encoded SHA-256 hash", key.Name)
+		}
+		if key.UserID == "" {
+			log.Fatalf("API key %q has no userID", key.Name)
+		}
+		if apiKeyHashes[key.Hash] {
+			log.Fatalf("Duplicate API key %q", key.Name)
+		}
+		apiKeyHashes[key.Hash] = true
+		config.Auth.APIKeys[i] = key
+	}
+
+	if err := viper.UnmarshalKey("metrics.summaryQuantiles", &config.Metrics.SummaryQuantiles); err != nil {
+		log.Fatalf("Invalid summary quantiles: %s", err)
+	}
+	config.Metrics.SummaryServices = viper.GetStringSlice("metrics.summaryServices")
+
+	config.Streaming = StreamingConfig{
+		MaxConnections: viper.GetInt("streaming.maxConnections"),
+		Routes:         viper.GetStringSlice("streaming.routes"),
+	}
+
+	queueTimeout, err := time.ParseDuration(viper.GetString("concurrency.queueTimeout"))
+	if err != nil {
+		log.Fatalf("Invalid concurrency queue timeout: %s", err)
+	}
+
+	config.Concurrency = ConcurrencyConfig{
+		MaxInFlight:      viper.GetInt("concurrency.maxInFlight"),
+		ReservedPriority: viper.GetInt("concurrency.reservedPriority"),
+		QueueTimeout:     queueTimeout,
+		PriorityPaths:    viper.GetStringSlice("concurrency.priorityPaths"),
+	}
+
+	overloadRetryAfter, err := time.ParseDuration(viper.GetString("overload.retryAfter"))
+	if err != nil {
+		log.Fatalf("Invalid overload retry after: %s", err)
+	}
+
+	config.Overload = OverloadConfig{
+		RateLimitStatus: viper.GetInt("overload.rateLimitStatus"),
+		CapacityStatus:  viper.GetInt("overload.capacityStatus"),
+		RetryAfter:      overloadRetryAfter,
+	}
+
+	config.RateLimit = RateLimitConfig{
+		RPS:        viper.GetFloat64("rateLimit.rps"),
+		Burst:      viper.GetInt("rateLimit.burst"),
+		UserRPS:    viper.GetFloat64("rateLimit.userRPS"),
+		UserBurst:  viper.GetInt("rateLimit.userBurst"),
+		MaxClients: viper.GetInt("rateLimit.maxClients"),
+		Reads: MethodRateLimit{
+			RPS:   viper.GetFloat64("rateLimit.reads.rps"),
+			Burst: viper.GetInt("rateLimit.reads.burst"),
+		},
+		Writes: MethodRateLimit{
+			RPS:   viper.GetFloat64("rateLimit.writes.rps"),
+			Burst: viper.GetInt("rateLimit.writes.burst"),
+		},
+	}
+	if config.RateLimit.UserRPS == 0 {
+		config.RateLimit.UserRPS, config.RateLimit.UserBurst = config.RateLimit.RPS, config.RateLimit.Burst
+	}
+	if err := viper.UnmarshalKey("rateLimit.services", &config.RateLimit.Services); err != nil {
+		log.Fatalf("Invalid per-service rate limits: %s", err)
+	}
+	for service, limits := range config.RateLimit.Services {
+		limits.Reads = limits.Reads.withDefault(config.RateLimit.Reads)
+		limits.Writes = limits.Writes.withDefault(config.RateLimit.Writes)
+		config.RateLimit.Services[service] = limits
+	}
+
+	config.HealthCheck = HealthCheckConfig{
+		Interval:         viper.GetDuration("healthCheck.interval"),
+		Timeout:          viper.GetDuration("healthCheck.timeout"),
+		FailureThreshold: viper.GetInt("healthCheck.failureThreshold"),
+		FailFast:         viper.GetBool("healthCheck.failFast"),
+		Paths:            make(map[string]string),
+	}
+	for _, service := range config.Services.List {
+		if healthPath := viper.GetString("healthCheck.paths." + service.ID); healthPath != "" {
+			config.HealthCheck.Paths[service.ID] = "/" + strings.TrimLeft(healthPath, "/")
+		}
+	}
+
+	config.Cache = CacheConfig{
+		MaxEntries:   viper.GetInt("cache.maxEntries"),
+		MaxBodyBytes: viper.GetInt64("cache.maxBodyBytes"),
+	}
+	if err := viper.UnmarshalKey("cache.routes", &config.Cache.Routes); err != nil {
+		log.Fatalf("Invalid cache route configuration: %s", err)
+	}
+	for _, route := range config.Cache.Routes {
+		if route.PathPrefix == "" || route.TTL <= 0 {
+			log.Fatalf("Cache routes need a pathPrefix and a positive ttl: %+v", route)
+		}
+	}
+
 	// Validate required configuration
 	if config.JWT.SecretKey == "" {
 		log.Fatal("JWT secret key is required")
 	}
 
-	if config.Services.UserAuthServiceURL == "" {
-		log.Fatal("Auth service URL is required")
+	for _, service := range config.Services.List {
+		if service.URL == "" {
+			log.Fatalf("Service URL is required for %s", service.ID)
+		}
 	}
 
-	if config.Services.CoreOperationServiceURL == "" {
-		log.Fatal("Sensor service URL is required")
+	if config.Concurrency.MaxInFlight > 0 &&
+		(config.Concurrency.ReservedPriority < 0 || config.Concurrency.ReservedPriority >= config.Concurrency.MaxInFlight) {
+		log.Fatalf("Invalid concurrency.reservedPriority %d: must be between 0 and maxInFlight-1", config.Concurrency.ReservedPriority)
 	}
 
-	if config.Services.AIServiceURL == "" {
-		log.Fatal("AI service URL is required")
+	if config.RateLimit.RPS < 0 || (config.RateLimit.RPS > 0 && config.RateLimit.Burst < 1) {
+		log.Fatalf("Invalid rate limit: rps %v, burst %d", config.RateLimit.RPS, config.RateLimit.Burst)
+	}
+	if config.RateLimit.UserRPS < 0 || (config.RateLimit.UserRPS > 0 && config.RateLimit.UserBurst < 1) {
+		log.Fatalf("Invalid user rate limit: rps %v, burst %d", config.RateLimit.UserRPS, config.RateLimit.UserBurst)
+	}
+	methodLimits := map[string]MethodRateLimit{"reads": config.RateLimit.Reads, "writes": config.RateLimit.Writes}
+	for service, limits := range config.RateLimit.Services {
+		methodLimits[service+".reads"], methodLimits[service+".writes"] = limits.Reads, limits.Writes
+	}
+	for name, limit := range methodLimits {
+		if limit.RPS < 0 || (limit.RPS > 0 && limit.Burst < 1) {
+			log.Fatalf("Invalid %s rate limit: rps %v, burst %d", name, limit.RPS, limit.Burst)
+		}
+	}
+
+	if config.JWT.Leeway < 0 {
+		log.Fatalf("Invalid JWT leeway %s: must not be negative", config.JWT.Leeway)
+	}
+	if jwks := config.JWT.JWKS; jwks.URL != "" && (jwks.RefreshInterval <= 0 || jwks.MinRefreshInterval < 0 || jwks.Timeout <= 0 || jwks.WarmupTimeout <= 0) {
+		log.Fatalf("Invalid JWKS configuration: refreshInterval %s, minRefreshInterval %s, timeout %s, warmupTimeout %s",
+			jwks.RefreshInterval, jwks.MinRefreshInterval, jwks.Timeout, jwks.WarmupTimeout)
+	}
+
+	if config.HealthCheck.Interval <= 0 || config.HealthCheck.Timeout <= 0 || config.HealthCheck.FailureThreshold < 1 {
+		log.Fatalf("Invalid health check configuration: interval %s, timeout %s, failureThreshold %d",
+			config.HealthCheck.Interval, config.HealthCheck.Timeout, config.HealthCheck.FailureThreshold)
+	}
+
+	if status := config.Server.MethodNotAllowedStatus; status != http.StatusMethodNotAllowed && status != http.StatusNotFound {
+		log.Fatalf("Invalid server.methodNotAllowedStatus %d: must be 404 or 405", status)
+	}
+
+	for _, status := range []int{config.Overload.RateLimitStatus, config.Overload.CapacityStatus} {
+		if status < 400 || status > 599 {
+			log.Fatalf("Invalid overload status code: %d", status)
+		}
+	}
+
+	knownServices := map[string]bool{"gateway": true}
+	for _, service := range config.Services.List {
+		knownServices[service.ID] = true
+	}
+	for service := range config.Auth.PublicPathOverrides {
+		if !knownServices[service] {
+			log.Fatalf("Unknown service in public path overrides: %s", service)
+		}
+	}
+	for service := range config.RateLimit.Services {
+		if !knownServices[service] || service == "gateway" {
+			log.Fatalf("Unknown service in rate limits: %s", service)
+		}
+	}
+
+	for _, quantile := range config.Metrics.SummaryQuantiles {
+		if quantile <= 0 || quantile >= 1 {
+			log.Fatalf("Invalid summary quantile: %v", quantile)
+		}
+	}
+
+	switch config.Proxy.TraceLevel {
+	case "off", "debug", "info":
+	default:
+		log.Fatalf("Invalid proxy trace level: %s", config.Proxy.TraceLevel)
 	}
 
 	return &config
 }
+
+// stringList reads a list setting given either as a YAML list or, from an
+// environment variable, as a comma-separated string
+func stringList(key string) []string {
+	raw, ok := viper.Get(key).(string)
+	if !ok {
+		return viper.GetStringSlice(key)
+	}
+
+	var values []string
+	for _, value := range strings.Split(raw, ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
@@ -0,0 +1,88 @@
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+func TestLoadServiceListDefaults(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+
+	list := loadServiceList(ServicesConfig{
+		UserAuthServiceURL:      "http://user-auth:8001",
+		CoreOperationServiceURL: "http://core:8002",
+		AIServiceURL:            "http://ai:8003",
+	})
+
+	want := map[string]string{"user-auth": "http://user-auth:8001", "core-operations": "http://core:8002", "greenhouse-ai": "http://ai:8003"}
+	if len(list) != len(want) {
+		t.Fatalf("list = %+v, want the three built-in services", list)
+	}
+	for _, service := range list {
+		if want[service.ID] != service.URL || !service.AuthRequired {
+			t.Errorf("service %+v", service)
+		}
+	}
+}
+
+func TestLoadServiceListFromConfig(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	viper.Set("services.list", []map[string]interface{}{
+		{"id": "weather", "url": "http://weather:8004", "prefixes": []string{"/weather/", "forecast"}, "authRequired": false, "timeout": "5s"},
+		{"id": "irrigation", "url": "http://irrigation:8005"},
+	})
+
+	list := loadServiceList(ServicesConfig{})
+	if len(list) != 2 {
+		t.Fatalf("list = %+v, want two services", list)
+	}
+
+	weather := list[0]
+	if weather.ID != "weather" || weather.URL != "http://weather:8004" || weather.AuthRequired || weather.Timeout != 5*time.Second {
+		t.Errorf("weather = %+v", weather)
+	}
+	if len(weather.Prefixes) != 2 || weather.Prefixes[0] != "/weather" || weather.Prefixes[1] != "/forecast" {
+		t.Errorf("weather prefixes = %v, want [/weather /forecast]", weather.Prefixes)
+	}
+
+	// Defaults: the ID as prefix, and auth required
+	irrigation := list[1]
+	if len(irrigation.Prefixes) != 1 || irrigation.Prefixes[0] != "/irrigation" || !irrigation.AuthRequired {
+		t.Errorf("irrigation = %+v", irrigation)
+	}
+}
+
+func TestDefaultServiceRules(t *testing.T) {
+	rules := defaultServiceRules(ServiceDefinition{ID: "core-operations", Prefixes: []string{"/core-operations", "/core-operation"}})
+	if len(rules) != 3 || rules[0].StripPrefix != "/core-operations" || rules[1].StripPrefix != "/core-operation" || rules[2].AddPrefix != "/api" {
+		t.Errorf("core-operations rules = %+v", rules)
+	}
+
+	// A custom service only has its prefixes stripped
+	rules = defaultServiceRules(ServiceDefinition{ID: "weather", Prefixes: []string{"/weather"}})
+	if len(rules) != 1 || rules[0].StripPrefix != "/weather" {
+		t.Errorf("weather rules = %+v", rules)
+	}
+}
+
+func TestMethodRateLimitWithDefault(t *testing.T) {
+	def := MethodRateLimit{RPS: 10, Burst: 40}
+
+	tests := []struct {
+		limit MethodRateLimit
+		want  MethodRateLimit
+	}{
+		{MethodRateLimit{}, def},
+		{MethodRateLimit{RPS: 2}, MethodRateLimit{RPS: 2, Burst: 40}},
+		{MethodRateLimit{RPS: 2, Burst: 5}, MethodRateLimit{RPS: 2, Burst: 5}},
+	}
+	for _, tt := range tests {
+		if got := tt.limit.withDefault(def); got != tt.want {
+			t.Errorf("%+v.withDefault(%+v) = %+v, want %+v", tt.limit, def, got, tt.want)
+		}
+	}
+}
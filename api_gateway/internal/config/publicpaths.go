@@ -0,0 +1,73 @@
+package config
+
+// defaultPublicPaths is the built-in public (unauthenticated) path set, keyed
+// by service ID, or "gateway" for the gateway's own endpoints. Paths are full
+// gateway paths; a trailing "/*" matches the path and everything below it,
+// whole segments only, and any other path matches exactly.
+// Backend health checks are not listed here; they come from the configured
+// health paths.
+var defaultPublicPaths = map[string][]string{
+	// Gateway's own common endpoints
+	"gateway": {
+		"/",                // Gateway root endpoint
+		"/health/*",        // Gateway health check
+		"/metrics/*",       // Prometheus metrics endpoint
+		"/api/v1/health/*", // Common API versioned health check
+		"/api/v1/status",   // Aggregated gateway and backend status
+		"/status",          // Build version and uptime
+	},
+
+	// === User & Auth Service (Node.js) endpoints ===
+	"user-auth": {
+		"/api/v1/user-auth/auth/login/*",         // User login endpoint
+		"/api/v1/user-auth/auth/admin/login/*",   // Admin login endpoint
+		"/api/v1/user-auth/auth/register/*",      // User registration endpoint
+		"/api/v1/user-auth/auth/refresh-token/*", // Refresh access token
+		"/api/v1/user-auth/auth/docs/*",          // Swagger UI for Auth Service
+		"/api/v1/user-auth/auth",                 // Root of Auth service
+		// user profile and operations
+		"/api/v1/user-auth/users/*",
+	},
+
+	// === Core Operations Service (Python/FastAPI) endpoints ===
+	// Hỗ trợ cả hai dạng tiền tố "/api/v1/core-operations" và "/api/v1/core-operation"
+	"core-operations": {
+		"/api/v1/core-operations", "/api/v1/core-operation", // Root endpoint
+		"/api/v1/core-operations/", "/api/v1/core-operation/", // Root endpoint with trailing slash
+		"/api/v1/core-operations/version/*", "/api/v1/core-operation/version/*", // Version info
+		"/api/v1/core-operations/docs/*", "/api/v1/core-operation/docs/*", // Swagger UI
+
+		// System Config endpoints
+		"/api/v1/core-operations/system/config/*", "/api/v1/core-operation/system/config/*", // GET system config
+
+		// Sensor Data endpoints (NẾU MUỐN CÔNG KHAI - xóa nếu cần authentication)
+		"/api/v1/core-operations/sensors", "/api/v1/core-operation/sensors", // List available sensors
+		"/api/v1/core-operations/sensors/", "/api/v1/core-operation/sensors/",
+		"/api/v1/core-operations/sensors/collect/*", "/api/v1/core-operation/sensors/collect/*", // Collect sensor data
+		"/api/v1/core-operations/sensors/snapshot/*", "/api/v1/core-operation/sensors/snapshot/*", // Environmental snapshot
+		"/api/v1/core-operations/sensors/light/*", "/api/v1/core-operation/sensors/light/*", // Light sensor data
+		"/api/v1/core-operations/sensors/temperature/*", "/api/v1/core-operation/sensors/temperature/*", // Temperature data
+		"/api/v1/core-operations/sensors/humidity/*", "/api/v1/core-operation/sensors/humidity/*", // Humidity data
+		"/api/v1/core-operations/sensors/soil_moisture/*", "/api/v1/core-operation/sensors/soil_moisture/*", // Soil moisture
+		"/api/v1/core-operations/sensors/analyze/soil_moisture/*", "/api/v1/core-operation/sensors/analyze/soil_moisture/*", // Analysis
+
+		// Status endpoints
+		"/api/v1/core-operations/control/status/*", "/api/v1/core-operation/control/status/*", // Irrigation system status
+		"/api/v1/core-operations/control/pump/status/*", "/api/v1/core-operation/control/pump/status/*", // Pump status
+		"/api/v1/core-operations/control/schedules/*", "/api/v1/core-operation/control/schedules/*", // List irrigation schedules
+		"/api/v1/core-operations/control/auto/*", "/api/v1/core-operation/control/auto/*", // Auto-irrigation config
+	},
+
+	// === Greenhouse AI Service (Python/FastAPI) endpoints ===
+	"greenhouse-ai": {
+		"/api/v1/greenhouse-ai",        // Root endpoint
+		"/api/v1/greenhouse-ai/docs/*", // Swagger UI
+
+		// Sensors & data endpoints
+		"/api/v1/greenhouse-ai/api/sensors/current/*", // Current sensor data
+		"/api/v1/greenhouse-ai/api/sensors/history/*", // Sensor history
+
+		// Analytics endpoints cho data công khai
+		"/api/v1/greenhouse-ai/api/analytics/model-performance/*", // Model performance
+	},
+}
@@ -0,0 +1,56 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/canxphung/DA_CNPM_242/api_gateway/internal/health"
+	"go.uber.org/zap"
+)
+
+// BackendHealthReport is the document returned by /health/backends
+type BackendHealthReport struct {
+	Status    string                   `json:"status"`
+	Timestamp string                   `json:"timestamp"`
+	Services  map[string]health.Status `json:"services"`
+}
+
+// BackendHealthHandler reports the state of each backend from the health checker
+type BackendHealthHandler struct {
+	checker *health.Checker
+	logger  *zap.Logger
+}
+
+// NewBackendHealthHandler creates a new backend health handler
+func NewBackendHealthHandler(checker *health.Checker, logger *zap.Logger) *BackendHealthHandler {
+	return &BackendHealthHandler{
+		checker: checker,
+		logger:  logger,
+	}
+}
+
+// ServeHTTP answers 200 when no backend is down and 503 otherwise,
+// so load balancers and monitors can use the status code alone
+func (h *BackendHealthHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	report := BackendHealthReport{
+		Status:    health.StateUp,
+		Timestamp: time.Now().Format(time.RFC3339),
+		Services:  h.checker.Statuses(),
+	}
+
+	statusCode := http.StatusOK
+	for _, backend := range report.Services {
+		if backend.State == health.StateDown {
+			report.Status = health.StateDown
+			statusCode = http.StatusServiceUnavailable
+			break
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		h.logger.Error("Failed to encode backend health report", zap.Error(err))
+	}
+}
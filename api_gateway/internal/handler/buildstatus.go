@@ -0,0 +1,85 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/canxphung/DA_CNPM_242/api_gateway/internal/buildinfo"
+	"github.com/canxphung/DA_CNPM_242/api_gateway/internal/config"
+	"go.uber.org/zap"
+)
+
+// BuildStatus is the document returned by /status: the running build, its
+// uptime and the backends it routes to
+type BuildStatus struct {
+	buildinfo.Info
+	StartedAt     string            `json:"started_at"`
+	UptimeSeconds int64             `json:"uptime_seconds"`
+	Backends      map[string]string `json:"backends"`
+}
+
+// BuildStatusHandler reports which build is running, for verifying deployments
+type BuildStatusHandler struct {
+	backends map[string]string
+	logger   *zap.Logger
+}
+
+// NewBuildStatusHandler creates a new build status handler for the configured services
+func NewBuildStatusHandler(services []config.ServiceDefinition, logger *zap.Logger) *BuildStatusHandler {
+	backends := make(map[string]string, len(services))
+	for _, service := range services {
+		backends[service.ID] = redactURL(service.URL)
+	}
+
+	return &BuildStatusHandler{
+		backends: backends,
+		logger:   logger,
+	}
+}
+
+// ServeHTTP writes the build status
+func (h *BuildStatusHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	status := BuildStatus{
+		Info:          buildinfo.Get(),
+		StartedAt:     buildinfo.StartTime().UTC().Format(time.RFC3339),
+		UptimeSeconds: int64(buildinfo.Uptime().Seconds()),
+		Backends:      h.backends,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(status); err != nil {
+		h.logger.Error("Failed to encode build status", zap.Error(err))
+	}
+}
+
+// redactURL reduces a service URL to its scheme, host and path, dropping
+// credentials, query and fragment, any of which may carry secrets. Weighted
+// URLs list several targets separated by ";", each redacted on its own and
+// keeping its ":<weight>" suffix.
+func redactURL(serviceURL string) string {
+	targets := strings.Split(serviceURL, ";")
+	for i, target := range targets {
+		target = strings.TrimSpace(target)
+		weight := ""
+		if len(targets) > 1 {
+			if separator := strings.LastIndex(target, ":"); separator >= 0 {
+				if _, err := strconv.Atoi(target[separator+1:]); err == nil {
+					target, weight = target[:separator], target[separator:]
+				}
+			}
+		}
+		parsed, err := url.Parse(target)
+		if err != nil || parsed.Host == "" {
+			// Not a URL we can take apart safely
+			targets[i] = "[redacted]"
+			continue
+		}
+		targets[i] = (&url.URL{Scheme: parsed.Scheme, Host: parsed.Host, Path: parsed.Path}).String() + weight
+	}
+	return strings.Join(targets, ";")
+}
@@ -0,0 +1,76 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/canxphung/DA_CNPM_242/api_gateway/internal/auth"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// maxLogLevelBodyBytes bounds the body of a log level change
+const maxLogLevelBodyBytes = 1 << 10
+
+// logLevelBody is the request and response body of /admin/loglevel
+type logLevelBody struct {
+	Level string `json:"level"`
+}
+
+// LogLevelHandler reads and changes the gateway's log level at runtime
+type LogLevelHandler struct {
+	level  zap.AtomicLevel
+	logger *zap.Logger
+}
+
+// NewLogLevelHandler creates a new log level handler for the logger's level
+func NewLogLevelHandler(level zap.AtomicLevel, logger *zap.Logger) *LogLevelHandler {
+	return &LogLevelHandler{
+		level:  level,
+		logger: logger,
+	}
+}
+
+// ServeHTTP answers GET with the current level and sets it on POST, e.g.
+// {"level":"debug"}. An unknown level gets 400.
+func (h *LogLevelHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		var body logLevelBody
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxLogLevelBodyBytes)).Decode(&body); err != nil {
+			h.writeError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+		// ParseLevel reads "" as info, so a missing level is rejected first
+		level, err := zapcore.ParseLevel(body.Level)
+		if body.Level == "" || err != nil {
+			h.writeError(w, http.StatusBadRequest, "Invalid log level: "+body.Level)
+			return
+		}
+
+		previous := h.level.Level()
+		h.level.SetLevel(level)
+
+		fields := []zap.Field{zap.Stringer("from", previous), zap.Stringer("to", level)}
+		if user := auth.GetUserFromContext(r.Context()); user != nil {
+			fields = append(fields, zap.String("user_id", user.ID))
+		}
+		// Logged at warn, so it is recorded unless the new level is error or above
+		h.logger.Warn("Log level changed", fields...)
+	}
+
+	h.writeJSON(w, http.StatusOK, logLevelBody{Level: h.level.Level().String()})
+}
+
+// writeError writes a JSON error response
+func (h *LogLevelHandler) writeError(w http.ResponseWriter, status int, message string) {
+	h.writeJSON(w, status, map[string]string{"error": message})
+}
+
+// writeJSON writes body as a JSON response
+func (h *LogLevelHandler) writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		h.logger.Error("Failed to encode log level response", zap.Error(err))
+	}
+}
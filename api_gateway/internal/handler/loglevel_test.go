@@ -0,0 +1,112 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/canxphung/DA_CNPM_242/api_gateway/internal/auth"
+	"github.com/canxphung/DA_CNPM_242/api_gateway/internal/config"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+const testSecret = "test-secret"
+
+// newAdminLogLevelHandler wires the handler as main does, behind
+// Authenticate and RequireRole("admin")
+func newAdminLogLevelHandler(t *testing.T, level zap.AtomicLevel) http.Handler {
+	t.Helper()
+	manager, err := auth.NewJWTManager(&config.JWTConfig{SecretKey: testSecret, ExpirationMinutes: 60, UserIDClaims: []string{"sub"}}, zap.NewNop())
+	if err != nil {
+		t.Fatalf("NewJWTManager: %v", err)
+	}
+	t.Cleanup(manager.Stop)
+	authMiddleware := auth.NewAuthMiddleware(manager, &config.AuthConfig{}, &config.ServicesConfig{},
+		auth.NewMemoryRevocationStore(prometheus.NewRegistry()), zap.NewNop())
+	return authMiddleware.Authenticate(authMiddleware.RequireRole("admin")(NewLogLevelHandler(level, zap.NewNop())))
+}
+
+func tokenWithRole(t *testing.T, role string) string {
+	t.Helper()
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"sub": "user-1", "role": role, "type": "access", "exp": time.Now().Add(time.Hour).Unix(),
+	}).SignedString([]byte(testSecret))
+	if err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+	return token
+}
+
+// serveLogLevel sends a request to /admin/loglevel and decodes the response
+func serveLogLevel(t *testing.T, h http.Handler, method, body, token string) (int, map[string]string) {
+	t.Helper()
+	req := httptest.NewRequest(method, "/admin/loglevel", strings.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	var decoded map[string]string
+	_ = json.Unmarshal(rec.Body.Bytes(), &decoded)
+	return rec.Code, decoded
+}
+
+func TestLogLevelGetAndSet(t *testing.T) {
+	level := zap.NewAtomicLevelAt(zapcore.InfoLevel)
+	h := newAdminLogLevelHandler(t, level)
+	admin := tokenWithRole(t, "admin")
+
+	if status, body := serveLogLevel(t, h, http.MethodGet, "", admin); status != http.StatusOK || body["level"] != "info" {
+		t.Fatalf("GET: status %d body %v, want info", status, body)
+	}
+
+	if status, body := serveLogLevel(t, h, http.MethodPost, `{"level":"debug"}`, admin); status != http.StatusOK || body["level"] != "debug" {
+		t.Fatalf("POST debug: status %d body %v", status, body)
+	}
+	if level.Level() != zapcore.DebugLevel {
+		t.Errorf("level = %s, want debug", level.Level())
+	}
+	if status, body := serveLogLevel(t, h, http.MethodGet, "", admin); status != http.StatusOK || body["level"] != "debug" {
+		t.Errorf("GET after POST: status %d body %v, want debug", status, body)
+	}
+}
+
+func TestLogLevelRejectsInvalidInput(t *testing.T) {
+	level := zap.NewAtomicLevelAt(zapcore.InfoLevel)
+	h := newAdminLogLevelHandler(t, level)
+	admin := tokenWithRole(t, "admin")
+
+	for _, body := range []string{`{"level":"verbose"}`, `{"level":""}`, `not json`, `{"level":"` + strings.Repeat("d", 2048) + `"}`} {
+		if status, _ := serveLogLevel(t, h, http.MethodPost, body, admin); status != http.StatusBadRequest {
+			t.Errorf("POST %.40q: status %d, want 400", body, status)
+		}
+	}
+	if level.Level() != zapcore.InfoLevel {
+		t.Errorf("level = %s after invalid requests, want it unchanged", level.Level())
+	}
+}
+
+func TestLogLevelRequiresAdmin(t *testing.T) {
+	level := zap.NewAtomicLevelAt(zapcore.InfoLevel)
+	h := newAdminLogLevelHandler(t, level)
+
+	if status, _ := serveLogLevel(t, h, http.MethodPost, `{"level":"debug"}`, tokenWithRole(t, "user")); status != http.StatusForbidden {
+		t.Errorf("non-admin POST: status %d, want 403", status)
+	}
+	if status, _ := serveLogLevel(t, h, http.MethodGet, "", tokenWithRole(t, "user")); status != http.StatusForbidden {
+		t.Errorf("non-admin GET: status %d, want 403", status)
+	}
+	if status, _ := serveLogLevel(t, h, http.MethodPost, `{"level":"debug"}`, ""); status != http.StatusUnauthorized {
+		t.Errorf("anonymous POST: status %d, want 401", status)
+	}
+	if level.Level() != zapcore.InfoLevel {
+		t.Errorf("level = %s, want it unchanged", level.Level())
+	}
+}
@@ -0,0 +1,87 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+// candidateMethods are tried when working out which methods a path supports.
+// OPTIONS is left out because the global preflight route matches every path.
+var candidateMethods = []string{
+	http.MethodGet,
+	http.MethodHead,
+	http.MethodPost,
+	http.MethodPut,
+	http.MethodPatch,
+	http.MethodDelete,
+}
+
+// MethodNotAllowedHandler answers requests to a registered path with a method
+// the route does not support, listing the supported ones in the Allow header
+type MethodNotAllowedHandler struct {
+	router *mux.Router
+	status int
+	logger *zap.Logger
+}
+
+// NewMethodNotAllowedHandler creates a handler for method mismatches on router's routes.
+// status is 405, or 404 to hide which methods exist.
+func NewMethodNotAllowedHandler(router *mux.Router, status int, logger *zap.Logger) *MethodNotAllowedHandler {
+	return &MethodNotAllowedHandler{
+		router: router,
+		status: status,
+		logger: logger,
+	}
+}
+
+// ServeHTTP writes the method mismatch response
+func (h *MethodNotAllowedHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	allowed := h.AllowedMethods(r)
+	if allowed != nil {
+		h.logger.Info("Method not allowed for route",
+			zap.String("method", r.Method),
+			zap.String("path", r.URL.Path),
+			zap.Strings("allowed", allowed))
+	}
+
+	// The global OPTIONS route makes mux report a method mismatch for every
+	// unknown path; those are plain 404s
+	status, message := h.status, "Method not allowed"
+	if allowed == nil || status == http.StatusNotFound {
+		status, message = http.StatusNotFound, "Not found"
+	} else {
+		w.Header().Set("Allow", strings.Join(allowed, ", "))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(map[string]string{"error": message}); err != nil {
+		h.logger.Error("Failed to encode method not allowed response", zap.Error(err))
+	}
+}
+
+// AllowedMethods returns the methods a registered route accepts for the
+// request's path, or nil if no route matches the path with any method
+func (h *MethodNotAllowedHandler) AllowedMethods(r *http.Request) []string {
+	var allowed []string
+	for _, method := range candidateMethods {
+		if method == r.Method {
+			continue
+		}
+		probe := r.Clone(r.Context())
+		probe.Method = method
+
+		var match mux.RouteMatch
+		if h.router.Match(probe, &match) && match.MatchErr == nil {
+			allowed = append(allowed, method)
+		}
+	}
+	if len(allowed) == 0 {
+		return nil
+	}
+	return append(allowed, http.MethodOptions)
+}
@@ -0,0 +1,45 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestMethodNotAllowed(t *testing.T) {
+	tests := []struct {
+		name         string
+		methodStatus int
+		method       string
+		path         string
+		want         int
+		wantAllow    string
+	}{
+		{"gateway route", http.StatusMethodNotAllowed, http.MethodPost, "/health", http.StatusMethodNotAllowed, "GET, OPTIONS"},
+		{"route with several methods", http.StatusMethodNotAllowed, http.MethodDelete, "/admin/loglevel", http.StatusMethodNotAllowed, "GET, PUT, OPTIONS"},
+		// /api/v1/status is matched by the API subrouter's prefix before its own route
+		{"gateway route below the API prefix", http.StatusMethodNotAllowed, http.MethodPost, "/api/v1/status", http.StatusMethodNotAllowed, "GET, OPTIONS"},
+		{"configured to hide methods", http.StatusNotFound, http.MethodPost, "/health", http.StatusNotFound, ""},
+		{"unknown path stays a 404", http.StatusMethodNotAllowed, http.MethodPost, "/nothing-here", http.StatusNotFound, ""},
+		{"unknown API path stays a 404", http.StatusMethodNotAllowed, http.MethodPost, "/api/v1/irrigation/valves", http.StatusNotFound, ""},
+		{"allowed method", http.StatusMethodNotAllowed, http.MethodGet, "/health", http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			newRoutingRouter(tt.methodStatus, prometheus.NewRegistry()).ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, nil))
+
+			if rec.Code != tt.want {
+				t.Errorf("status %d, want %d", rec.Code, tt.want)
+			}
+			if got := rec.Header().Get("Allow"); got != tt.wantAllow {
+				t.Errorf("Allow = %q, want %q", got, tt.wantAllow)
+			}
+			if tt.want != http.StatusOK && rec.Header().Get("Content-Type") != "application/json" {
+				t.Errorf("Content-Type = %q, want a JSON error", rec.Header().Get("Content-Type"))
+			}
+		})
+	}
+}
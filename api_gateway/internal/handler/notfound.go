@@ -0,0 +1,105 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/canxphung/DA_CNPM_242/api_gateway/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// maxUnmatchedSegments caps the number of distinct segment labels so that
+// clients probing random paths cannot blow up the metric cardinality
+const maxUnmatchedSegments = 50
+
+// UnmatchedRouteHandler answers API requests that match no service prefix
+type UnmatchedRouteHandler struct {
+	apiPrefix string
+	services  []string
+	methods   *MethodNotAllowedHandler
+	unmatched *prometheus.CounterVec
+	logger    *zap.Logger
+
+	mu       sync.Mutex
+	segments map[string]struct{}
+}
+
+// NewUnmatchedRouteHandler creates a handler for unmatched paths under apiPrefix.
+// services lists the valid service prefixes returned to the client. Paths that
+// are registered for other methods are handed to methods instead.
+func NewUnmatchedRouteHandler(apiPrefix string, services []string, methods *MethodNotAllowedHandler, reg prometheus.Registerer, logger *zap.Logger) *UnmatchedRouteHandler {
+	return &UnmatchedRouteHandler{
+		apiPrefix: strings.TrimSuffix(apiPrefix, "/"),
+		services:  services,
+		methods:   methods,
+		unmatched: metrics.RegisterOrReuse(reg, prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "api_gateway",
+				Name:      "unmatched_route_total",
+				Help:      "Total number of API requests that matched no backend service",
+			},
+			[]string{"segment"},
+		)),
+		logger:   logger,
+		segments: make(map[string]struct{}),
+	}
+}
+
+// ServeHTTP writes a structured 404 listing the valid services
+func (h *UnmatchedRouteHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// The API subrouter catches every path below its prefix, so a gateway
+	// route like /api/v1/status hit with the wrong method ends up here
+	if h.methods != nil && h.methods.AllowedMethods(r) != nil {
+		h.methods.ServeHTTP(w, r)
+		return
+	}
+
+	segment := h.firstSegment(r.URL.Path)
+	h.unmatched.WithLabelValues(h.segmentLabel(segment)).Inc()
+
+	h.logger.Info("No backend route for API request",
+		zap.String("method", r.Method),
+		zap.String("path", r.URL.Path),
+		zap.String("segment", segment))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusNotFound)
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"error":    "No service matches the requested path",
+		"path":     r.URL.Path,
+		"services": h.services,
+	}); err != nil {
+		h.logger.Error("Failed to encode unmatched route response", zap.Error(err))
+	}
+}
+
+// firstSegment returns the first path segment below the API prefix
+func (h *UnmatchedRouteHandler) firstSegment(path string) string {
+	rest := strings.TrimPrefix(strings.TrimPrefix(path, h.apiPrefix), "/")
+	if i := strings.Index(rest, "/"); i >= 0 {
+		rest = rest[:i]
+	}
+	return rest
+}
+
+// segmentLabel returns the metric label for a segment, folding new segments
+// into "other" once maxUnmatchedSegments distinct values have been seen
+func (h *UnmatchedRouteHandler) segmentLabel(segment string) string {
+	if segment == "" {
+		return "(root)"
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.segments[segment]; ok {
+		return segment
+	}
+	if len(h.segments) >= maxUnmatchedSegments {
+		return "other"
+	}
+	h.segments[segment] = struct{}{}
+	return segment
+}
@@ -0,0 +1,123 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+var okHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+})
+
+// newRoutingRouter mirrors main's routing: a global OPTIONS route, gateway
+// routes on the router and service prefixes on the /api/v1 subrouter, with the
+// method mismatch and unmatched route handlers installed
+func newRoutingRouter(methodStatus int, reg prometheus.Registerer) *mux.Router {
+	router := mux.NewRouter()
+	router.Methods("OPTIONS").Handler(okHandler)
+	router.Handle("/health", okHandler).Methods("GET")
+	router.Handle("/api/v1/status", okHandler).Methods("GET")
+	router.Handle("/admin/loglevel", okHandler).Methods("GET", "PUT")
+	apiV1 := router.PathPrefix("/api/v1").Subrouter()
+	apiV1.PathPrefix("/user-auth/").Handler(okHandler)
+
+	var methods *MethodNotAllowedHandler
+	if methodStatus != 0 {
+		methods = NewMethodNotAllowedHandler(router, methodStatus, zap.NewNop())
+		router.MethodNotAllowedHandler = methods
+	}
+	apiV1.NotFoundHandler = NewUnmatchedRouteHandler("/api/v1", []string{"/api/v1/user-auth/"}, methods, reg, zap.NewNop())
+	return router
+}
+
+// unmatchedCount returns the unmatched route counter for segment
+func unmatchedCount(t *testing.T, reg *prometheus.Registry, segment string) float64 {
+	t.Helper()
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("Gather: %v", err)
+	}
+	for _, family := range families {
+		if family.GetName() != "api_gateway_unmatched_route_total" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			if metric.GetLabel()[0].GetValue() == segment {
+				return metric.GetCounter().GetValue()
+			}
+		}
+	}
+	return 0
+}
+
+func TestUnmatchedRoute(t *testing.T) {
+	tests := []struct {
+		name    string
+		path    string
+		want    int
+		segment string
+	}{
+		{"unknown service", "/api/v1/irrigation/valves", http.StatusNotFound, "irrigation"},
+		{"API root", "/api/v1/", http.StatusNotFound, "(root)"},
+		{"known service", "/api/v1/user-auth/login", http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reg := prometheus.NewRegistry()
+			rec := httptest.NewRecorder()
+			newRoutingRouter(0, reg).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+			if rec.Code != tt.want {
+				t.Fatalf("status %d, want %d", rec.Code, tt.want)
+			}
+			if tt.want != http.StatusNotFound {
+				return
+			}
+			var body struct {
+				Error    string   `json:"error"`
+				Path     string   `json:"path"`
+				Services []string `json:"services"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatalf("decode %q: %v", rec.Body.String(), err)
+			}
+			if body.Path != tt.path || len(body.Services) != 1 || body.Services[0] != "/api/v1/user-auth/" {
+				t.Errorf("body %+v, want the path and the valid services", body)
+			}
+			if got := unmatchedCount(t, reg, tt.segment); got != 1 {
+				t.Errorf("unmatched_route_total{segment=%q} = %v, want 1", tt.segment, got)
+			}
+		})
+	}
+}
+
+func TestUnmatchedRouteSegmentCardinality(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	router := newRoutingRouter(0, reg)
+	for i := 0; i < maxUnmatchedSegments+10; i++ {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, fmt.Sprintf("/api/v1/probe-%d/x", i), nil))
+	}
+	// A segment already seen keeps its own label
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/v1/probe-0/y", nil))
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if series := len(families[0].GetMetric()); series != maxUnmatchedSegments+1 {
+		t.Errorf("%d series, want %d segments and other", series, maxUnmatchedSegments+1)
+	}
+	if got := unmatchedCount(t, reg, "other"); got != 10 {
+		t.Errorf("other = %v, want 10", got)
+	}
+	if got := unmatchedCount(t, reg, "probe-0"); got != 2 {
+		t.Errorf("probe-0 = %v, want 2", got)
+	}
+}
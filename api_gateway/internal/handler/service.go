@@ -0,0 +1,50 @@
+package handler
+
+import (
+	"github.com/canxphung/DA_CNPM_242/api_gateway/internal/config"
+	"github.com/canxphung/DA_CNPM_242/api_gateway/internal/health"
+	"github.com/canxphung/DA_CNPM_242/api_gateway/internal/middleware"
+	"github.com/canxphung/DA_CNPM_242/api_gateway/internal/proxy"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+// ServiceHandler handles requests to one configured backend service
+type ServiceHandler struct {
+	service      config.ServiceDefinition
+	serviceProxy *proxy.ServiceProxy
+	logger       *zap.Logger
+}
+
+// NewServiceHandler creates a new handler proxying to the service's backend
+func NewServiceHandler(service config.ServiceDefinition, proxyConfig *config.ProxyConfig, proxyMetrics *proxy.Metrics, overload *middleware.OverloadResponder, cors *middleware.CORSMiddleware, checker *health.Checker, logger *zap.Logger) (*ServiceHandler, error) {
+	serviceProxy, err := proxy.NewServiceProxy(service.URL, service.ID, proxyConfig, proxyMetrics, overload, cors, checker, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ServiceHandler{
+		service:      service,
+		serviceProxy: serviceProxy,
+		logger:       logger,
+	}, nil
+}
+
+// RegisterRoutes registers the service's prefixes
+// This method is called on the apiV1 subrouter which already has /api/v1 prefix
+func (h *ServiceHandler) RegisterRoutes(router *mux.Router) {
+	// Authentication is handled by middleware; services that do not require it have public paths
+	effectivePrefixes := make([]string, 0, len(h.service.Prefixes))
+	for _, prefix := range h.service.Prefixes {
+		router.Path(prefix).Handler(h.serviceProxy)
+		router.PathPrefix(prefix + "/").Handler(h.serviceProxy)
+		effectivePrefixes = append(effectivePrefixes, "/api/v1"+prefix+"/")
+	}
+
+	h.logger.Info("Service routes registered on apiV1 subrouter",
+		zap.String("service_id", h.service.ID),
+		zap.String("service_url", h.service.URL),
+		zap.Strings("effective_prefixes", effectivePrefixes),
+		zap.Bool("auth_required", h.service.AuthRequired),
+	)
+}
@@ -0,0 +1,98 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/canxphung/DA_CNPM_242/api_gateway/internal/config"
+	"github.com/canxphung/DA_CNPM_242/api_gateway/internal/middleware"
+	"github.com/canxphung/DA_CNPM_242/api_gateway/internal/proxy"
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// newEchoBackend answers with the path it received and the service header
+func newEchoBackend(t *testing.T) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Received-Service", r.Header.Get("X-Gateway-Service"))
+		_, _ = w.Write([]byte(r.URL.Path))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// newServiceRouter registers the services on an /api/v1 subrouter as main does
+func newServiceRouter(t *testing.T, services []config.ServiceDefinition, proxyConfig *config.ProxyConfig) *mux.Router {
+	t.Helper()
+	metrics := proxy.NewMetrics(prometheus.NewRegistry())
+	overload := middleware.NewOverloadResponder(&config.OverloadConfig{RateLimitStatus: http.StatusTooManyRequests, CapacityStatus: http.StatusServiceUnavailable})
+	cors := middleware.NewCORSMiddleware(&config.CORSConfig{}, zap.NewNop())
+
+	router := mux.NewRouter()
+	apiV1 := router.PathPrefix("/api/v1").Subrouter()
+	for _, service := range services {
+		h, err := NewServiceHandler(service, proxyConfig, metrics, overload, cors, nil, zap.NewNop())
+		if err != nil {
+			t.Fatalf("NewServiceHandler(%s): %v", service.ID, err)
+		}
+		h.RegisterRoutes(apiV1)
+	}
+	return router
+}
+
+func TestServiceHandlersRouteConfiguredServices(t *testing.T) {
+	weather, irrigation := newEchoBackend(t), newEchoBackend(t)
+	services := []config.ServiceDefinition{
+		{ID: "weather", URL: weather.URL, Prefixes: []string{"/weather"}},
+		{ID: "irrigation", URL: irrigation.URL, Prefixes: []string{"/irrigation", "/water"}},
+	}
+	proxyConfig := &config.ProxyConfig{Services: map[string]config.ServiceProxyConfig{
+		"weather": {},
+		"irrigation": {Rewrite: []config.RewriteRule{
+			{Name: "strip-service-prefix", StripPrefix: "/irrigation"},
+			{Name: "strip-service-alias", StripPrefix: "/water"},
+		}},
+	}}
+	router := newServiceRouter(t, services, proxyConfig)
+
+	tests := []struct {
+		path        string
+		wantService string
+		wantPath    string
+	}{
+		{"/api/v1/weather/forecast", "weather", "/forecast"},
+		{"/api/v1/weather", "weather", "/"},
+		{"/api/v1/irrigation/valves/3", "irrigation", "/valves/3"},
+		{"/api/v1/water/valves/3", "irrigation", "/valves/3"},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if rec.Code != http.StatusOK {
+			t.Errorf("%s: status %d", tt.path, rec.Code)
+			continue
+		}
+		if got := rec.Header().Get("X-Received-Service"); got != tt.wantService || rec.Body.String() != tt.wantPath {
+			t.Errorf("%s: reached %q at %q, want %q at %q", tt.path, got, rec.Body.String(), tt.wantService, tt.wantPath)
+		}
+	}
+
+	// Paths that only share a prefix's text are not routed
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/weatherstation", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("/api/v1/weatherstation: status %d, want 404", rec.Code)
+	}
+}
+
+func TestServiceHandlerRejectsUnconfiguredService(t *testing.T) {
+	service := config.ServiceDefinition{ID: "weather", URL: "http://localhost:8004", Prefixes: []string{"/weather"}}
+	_, err := NewServiceHandler(service, &config.ProxyConfig{Services: map[string]config.ServiceProxyConfig{}},
+		proxy.NewMetrics(prometheus.NewRegistry()), nil, nil, nil, zap.NewNop())
+	if err == nil {
+		t.Error("service without proxy settings was accepted")
+	}
+}
@@ -0,0 +1,98 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/canxphung/DA_CNPM_242/api_gateway/internal/health"
+	"go.uber.org/zap"
+)
+
+// ComponentStatus is the health of a single component in the status report
+type ComponentStatus struct {
+	Status     string `json:"status"`
+	StatusCode int    `json:"status_code,omitempty"`
+	LatencyMs  int64  `json:"latency_ms"`
+	Error      string `json:"error,omitempty"`
+}
+
+// StatusReport is the aggregated health document returned by /api/v1/status
+type StatusReport struct {
+	Status     string                     `json:"status"`
+	Timestamp  string                     `json:"timestamp"`
+	Components map[string]ComponentStatus `json:"components"`
+}
+
+// StatusHandler aggregates the health of the gateway and all backends
+type StatusHandler struct {
+	checker *health.Checker
+	logger  *zap.Logger
+}
+
+// NewStatusHandler creates a new status handler reporting the checker's results
+func NewStatusHandler(checker *health.Checker, logger *zap.Logger) *StatusHandler {
+	return &StatusHandler{
+		checker: checker,
+		logger:  logger,
+	}
+}
+
+// ServeHTTP writes the aggregated report from the latest background checks.
+// It always answers 200; the overall status is derived from the components.
+func (h *StatusHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	report := StatusReport{
+		Timestamp: time.Now().Format(time.RFC3339),
+		Components: map[string]ComponentStatus{
+			"gateway": {Status: "healthy"},
+		},
+	}
+
+	for service, backend := range h.checker.Statuses() {
+		status := "unknown"
+		switch backend.State {
+		case health.StateUp:
+			status = "healthy"
+		case health.StateDown:
+			status = "unhealthy"
+		}
+		report.Components[service] = ComponentStatus{
+			Status:     status,
+			StatusCode: backend.StatusCode,
+			LatencyMs:  backend.LatencyMs,
+			Error:      backend.Error,
+		}
+	}
+
+	report.Status = overallStatus(report.Components)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		h.logger.Error("Failed to encode status report", zap.Error(err))
+	}
+}
+
+// overallStatus is "healthy" when every component is healthy, "unhealthy" when
+// no backend is healthy and "degraded" otherwise
+func overallStatus(components map[string]ComponentStatus) string {
+	backends, healthy := 0, 0
+	for name, component := range components {
+		if name == "gateway" {
+			continue
+		}
+		backends++
+		if component.Status == "healthy" {
+			healthy++
+		}
+	}
+
+	switch {
+	case healthy == backends:
+		return "healthy"
+	case healthy == 0:
+		return "unhealthy"
+	default:
+		return "degraded"
+	}
+}
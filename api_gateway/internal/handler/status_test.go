@@ -0,0 +1,129 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/canxphung/DA_CNPM_242/api_gateway/internal/config"
+	"github.com/canxphung/DA_CNPM_242/api_gateway/internal/health"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// newHealthBackend answers its /health endpoint with status
+func newHealthBackend(t *testing.T, status int) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// newCheckedChecker starts a checker for the backends, keyed by service, and
+// waits until each has been checked once
+func newCheckedChecker(t *testing.T, backends map[string]*httptest.Server) *health.Checker {
+	t.Helper()
+	var targets []health.Target
+	for service, backend := range backends {
+		targets = append(targets, health.Target{Service: service, URL: backend.URL + "/health"})
+	}
+	checker := health.NewChecker(&config.HealthCheckConfig{
+		Interval:         time.Hour,
+		Timeout:          time.Second,
+		FailureThreshold: 1,
+		FailFast:         true,
+	}, targets, prometheus.NewRegistry(), zap.NewNop())
+	checker.Start()
+	t.Cleanup(checker.Stop)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		checked := true
+		for _, status := range checker.Statuses() {
+			checked = checked && status.State != health.StateUnknown
+		}
+		if checked {
+			return checker
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("backends not checked: %v", checker.Statuses())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestStatusHandlerAggregatesBackends(t *testing.T) {
+	tests := []struct {
+		name     string
+		backends map[string]int
+		want     string
+	}{
+		{"all healthy", map[string]int{"user-auth": http.StatusOK, "core-operations": http.StatusOK}, "healthy"},
+		{"some unhealthy", map[string]int{"user-auth": http.StatusOK, "core-operations": http.StatusServiceUnavailable}, "degraded"},
+		{"none healthy", map[string]int{"user-auth": http.StatusBadGateway, "core-operations": http.StatusServiceUnavailable}, "unhealthy"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backends := make(map[string]*httptest.Server)
+			for service, status := range tt.backends {
+				backends[service] = newHealthBackend(t, status)
+			}
+			handler := NewStatusHandler(newCheckedChecker(t, backends), zap.NewNop())
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/status", nil))
+			// The report is always served; its status says how healthy the system is
+			if rec.Code != http.StatusOK {
+				t.Errorf("status code %d, want 200", rec.Code)
+			}
+			var report StatusReport
+			if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil {
+				t.Fatalf("decode %q: %v", rec.Body.String(), err)
+			}
+			if report.Status != tt.want {
+				t.Errorf("status %q, want %q", report.Status, tt.want)
+			}
+			if report.Components["gateway"].Status != "healthy" {
+				t.Errorf("gateway component %+v, want healthy", report.Components["gateway"])
+			}
+			for service, status := range tt.backends {
+				component := report.Components[service]
+				want := "healthy"
+				if status != http.StatusOK {
+					want = "unhealthy"
+				}
+				if component.Status != want || component.StatusCode != status {
+					t.Errorf("%s: %+v, want %s with status code %d", service, component, want, status)
+				}
+			}
+		})
+	}
+}
+
+func TestOverallStatus(t *testing.T) {
+	tests := []struct {
+		name       string
+		components map[string]ComponentStatus
+		want       string
+	}{
+		{"gateway only", map[string]ComponentStatus{"gateway": {Status: "healthy"}}, "healthy"},
+		{"unknown backend", map[string]ComponentStatus{
+			"gateway":         {Status: "healthy"},
+			"core-operations": {Status: "healthy"},
+			"greenhouse-ai":   {Status: "unknown"},
+		}, "degraded"},
+		{"only unknown backends", map[string]ComponentStatus{
+			"gateway":       {Status: "healthy"},
+			"greenhouse-ai": {Status: "unknown"},
+		}, "unhealthy"},
+	}
+	for _, tt := range tests {
+		if got := overallStatus(tt.components); got != tt.want {
+			t.Errorf("%s: overallStatus = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
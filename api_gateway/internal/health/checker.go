@@ -0,0 +1,232 @@
+// Package health runs background health checks against the backend services
+// so the gateway can report their state and stop proxying to a backend that is down.
+package health
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/canxphung/DA_CNPM_242/api_gateway/internal/config"
+	"github.com/canxphung/DA_CNPM_242/api_gateway/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// Backend state values reported in Status
+const (
+	StateUnknown = "unknown"
+	StateUp      = "up"
+	StateDown    = "down"
+)
+
+// Target is a backend to check
+type Target struct {
+	Service string
+	// URL is the backend's health endpoint
+	URL string
+}
+
+// Status is the latest health of one backend
+type Status struct {
+	State      string `json:"status"`
+	StatusCode int    `json:"status_code,omitempty"`
+	LatencyMs  int64  `json:"latency_ms"`
+	Error      string `json:"error,omitempty"`
+	CheckedAt  string `json:"checked_at,omitempty"`
+	// Failures is the number of consecutive failed checks
+	Failures int `json:"consecutive_failures"`
+}
+
+// Checker periodically GETs each backend's health endpoint and tracks
+// whether it is up. It is safe for concurrent use.
+type Checker struct {
+	targets   []Target
+	interval  time.Duration
+	threshold int
+	failFast  bool
+	client    *http.Client
+	up        *prometheus.GaugeVec
+	logger    *zap.Logger
+
+	mu       sync.RWMutex
+	statuses map[string]Status
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewChecker creates a checker for the targets. Call Start to begin checking.
+func NewChecker(cfg *config.HealthCheckConfig, targets []Target, reg prometheus.Registerer, logger *zap.Logger) *Checker {
+	up := metrics.RegisterOrReuse(reg, prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "api_gateway",
+			Name:      "backend_up",
+			Help:      "Whether each backend passed its latest health checks (1 = up, 0 = down)",
+		},
+		[]string{"service"},
+	))
+
+	statuses := make(map[string]Status, len(targets))
+	for _, target := range targets {
+		statuses[target.Service] = Status{State: StateUnknown}
+	}
+
+	return &Checker{
+		targets:   targets,
+		interval:  cfg.Interval,
+		threshold: cfg.FailureThreshold,
+		failFast:  cfg.FailFast,
+		client:    &http.Client{Timeout: cfg.Timeout},
+		up:        up,
+		logger:    logger,
+		statuses:  statuses,
+	}
+}
+
+// Start checks every backend immediately and then once per interval until Stop is called
+func (c *Checker) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	c.cancel = cancel
+	c.done = make(chan struct{})
+
+	go func() {
+		defer close(c.done)
+
+		ticker := time.NewTicker(c.interval)
+		defer ticker.Stop()
+
+		for {
+			c.checkAll(ctx)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Stop ends the checks and waits for any in progress to finish
+func (c *Checker) Stop() {
+	if c.cancel == nil {
+		return
+	}
+	c.cancel()
+	<-c.done
+}
+
+// IsUp reports whether requests to the service should be proxied. Services
+// not yet checked, or not checked at all, count as up; so does every service
+// when fail-fast is disabled.
+func (c *Checker) IsUp(service string) bool {
+	if !c.failFast {
+		return true
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.statuses[service].State != StateDown
+}
+
+// Interval returns how often each backend is checked
+func (c *Checker) Interval() time.Duration {
+	return c.interval
+}
+
+// Statuses returns a copy of the latest status of every backend, keyed by service
+func (c *Checker) Statuses() map[string]Status {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	statuses := make(map[string]Status, len(c.statuses))
+	for service, status := range c.statuses {
+		statuses[service] = status
+	}
+	return statuses
+}
+
+// checkAll checks every backend concurrently
+func (c *Checker) checkAll(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, target := range c.targets {
+		wg.Add(1)
+		go func(target Target) {
+			defer wg.Done()
+			c.record(ctx, target.Service, c.check(ctx, target))
+		}(target)
+	}
+	wg.Wait()
+}
+
+// check performs a single health check; a response below 400 is healthy
+func (c *Checker) check(ctx context.Context, target Target) Status {
+	start := time.Now()
+	status := Status{CheckedAt: start.Format(time.RFC3339)}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target.URL, nil)
+	if err != nil {
+		status.Error = err.Error()
+		return status
+	}
+
+	resp, err := c.client.Do(req)
+	status.LatencyMs = time.Since(start).Milliseconds()
+	if err != nil {
+		status.Error = err.Error()
+		return status
+	}
+	resp.Body.Close()
+
+	status.StatusCode = resp.StatusCode
+	if resp.StatusCode < http.StatusBadRequest {
+		status.State = StateUp
+	}
+	return status
+}
+
+// record stores a check result, marking the backend down after threshold
+// consecutive failures and up again after one success
+func (c *Checker) record(ctx context.Context, service string, result Status) {
+	// A check cut short by Stop says nothing about the backend
+	if result.State == "" && ctx.Err() != nil {
+		return
+	}
+
+	c.mu.Lock()
+	previous := c.statuses[service]
+	if result.State == StateUp {
+		result.Failures = 0
+	} else {
+		result.Failures = previous.Failures + 1
+		result.State = previous.State
+		if result.Failures >= c.threshold || previous.State == StateDown {
+			result.State = StateDown
+		}
+	}
+	c.statuses[service] = result
+	c.mu.Unlock()
+
+	if result.State == StateUp {
+		c.up.WithLabelValues(service).Set(1)
+	} else if result.State == StateDown {
+		c.up.WithLabelValues(service).Set(0)
+	}
+
+	switch {
+	case result.State == StateDown && previous.State != StateDown:
+		c.logger.Warn("Backend marked down",
+			zap.String("service", service),
+			zap.Int("status_code", result.StatusCode),
+			zap.String("error", result.Error),
+			zap.Int("consecutive_failures", result.Failures))
+	case result.State == StateUp && previous.State == StateDown:
+		c.logger.Info("Backend is back up", zap.String("service", service))
+	case result.State != StateUp:
+		c.logger.Debug("Backend health check failed",
+			zap.String("service", service),
+			zap.Int("status_code", result.StatusCode),
+			zap.String("error", result.Error),
+			zap.Int("consecutive_failures", result.Failures))
+	}
+}
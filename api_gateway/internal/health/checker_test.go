@@ -0,0 +1,127 @@
+package health
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/canxphung/DA_CNPM_242/api_gateway/internal/config"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// flippingBackend answers its health endpoint with 200 or 503 as healthy says
+type flippingBackend struct {
+	healthy atomic.Bool
+	server  *httptest.Server
+}
+
+func newFlippingBackend(t *testing.T) *flippingBackend {
+	t.Helper()
+	backend := &flippingBackend{}
+	backend.healthy.Store(true)
+	backend.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/health" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if backend.healthy.Load() {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(backend.server.Close)
+	return backend
+}
+
+func newTestChecker(failFast bool, targets ...Target) *Checker {
+	return NewChecker(&config.HealthCheckConfig{
+		Interval:         time.Hour,
+		Timeout:          time.Second,
+		FailureThreshold: 2,
+		FailFast:         failFast,
+	}, targets, prometheus.NewRegistry(), zap.NewNop())
+}
+
+func TestCheckerFollowsBackendHealth(t *testing.T) {
+	backend := newFlippingBackend(t)
+	checker := newTestChecker(true, Target{Service: "core-operations", URL: backend.server.URL + "/health"})
+	ctx := context.Background()
+
+	if state := checker.Statuses()["core-operations"].State; state != StateUnknown || !checker.IsUp("core-operations") {
+		t.Fatalf("before any check: state %s, want unknown and routable", state)
+	}
+
+	checker.checkAll(ctx)
+	if status := checker.Statuses()["core-operations"]; status.State != StateUp || status.StatusCode != http.StatusOK {
+		t.Fatalf("healthy backend: %+v", status)
+	}
+
+	backend.healthy.Store(false)
+	checker.checkAll(ctx)
+	if !checker.IsUp("core-operations") {
+		t.Error("marked down after one failure, below the threshold of 2")
+	}
+	checker.checkAll(ctx)
+	if status := checker.Statuses()["core-operations"]; status.State != StateDown || status.Failures != 2 {
+		t.Fatalf("after two failures: %+v, want down", status)
+	}
+	if checker.IsUp("core-operations") {
+		t.Error("IsUp = true for a down backend")
+	}
+
+	backend.healthy.Store(true)
+	checker.checkAll(ctx)
+	if status := checker.Statuses()["core-operations"]; status.State != StateUp || status.Failures != 0 {
+		t.Errorf("after recovery: %+v, want up", status)
+	}
+}
+
+func TestCheckerUnreachableBackend(t *testing.T) {
+	backend := newFlippingBackend(t)
+	url := backend.server.URL + "/health"
+	backend.server.Close()
+
+	checker := newTestChecker(true, Target{Service: "greenhouse-ai", URL: url})
+	checker.checkAll(context.Background())
+	checker.checkAll(context.Background())
+
+	status := checker.Statuses()["greenhouse-ai"]
+	if status.State != StateDown || status.Error == "" {
+		t.Errorf("unreachable backend: %+v, want down with an error", status)
+	}
+}
+
+func TestCheckerWithoutFailFast(t *testing.T) {
+	backend := newFlippingBackend(t)
+	backend.healthy.Store(false)
+	checker := newTestChecker(false, Target{Service: "user-auth", URL: backend.server.URL + "/health"})
+
+	checker.checkAll(context.Background())
+	checker.checkAll(context.Background())
+	if checker.Statuses()["user-auth"].State != StateDown {
+		t.Fatal("backend not marked down")
+	}
+	if !checker.IsUp("user-auth") {
+		t.Error("IsUp = false with fail-fast disabled")
+	}
+}
+
+func TestCheckerStartStop(t *testing.T) {
+	backend := newFlippingBackend(t)
+	checker := newTestChecker(true, Target{Service: "core-operations", URL: backend.server.URL + "/health"})
+
+	checker.Start()
+	deadline := time.Now().Add(2 * time.Second)
+	for checker.Statuses()["core-operations"].State != StateUp {
+		if time.Now().After(deadline) {
+			t.Fatal("first check did not run on Start")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	checker.Stop()
+}
@@ -0,0 +1,28 @@
+package metrics
+
+import (
+	"errors"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// RegisterOrReuse registers the collector with the registry and returns it.
+// If an identical collector is already registered (e.g. the middleware is
+// constructed again on a config reload) the existing one is returned instead
+// of panicking, so both instances keep feeding the same series.
+// A nil registry leaves the collector unregistered.
+func RegisterOrReuse[T prometheus.Collector](reg prometheus.Registerer, collector T) T {
+	if reg == nil {
+		return collector
+	}
+	if err := reg.Register(collector); err != nil {
+		var alreadyRegistered prometheus.AlreadyRegisteredError
+		if errors.As(err, &alreadyRegistered) {
+			if existing, ok := alreadyRegistered.ExistingCollector.(T); ok {
+				return existing
+			}
+		}
+		panic(err)
+	}
+	return collector
+}
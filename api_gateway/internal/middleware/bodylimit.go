@@ -0,0 +1,73 @@
+package middleware
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/canxphung/DA_CNPM_242/api_gateway/internal/config"
+	"go.uber.org/zap"
+)
+
+// BodyLimitMiddleware rejects request bodies larger than the configured limit
+type BodyLimitMiddleware struct {
+	maxBytes int64
+	routes   []config.BodyLimit
+	logger   *zap.Logger
+}
+
+// NewBodyLimitMiddleware creates a new request body size limit middleware
+func NewBodyLimitMiddleware(cfg *config.RequestConfig, logger *zap.Logger) *BodyLimitMiddleware {
+	routes := make([]config.BodyLimit, 0, len(cfg.BodyLimits))
+	for _, route := range cfg.BodyLimits {
+		route.PathPrefix = strings.TrimRight(route.PathPrefix, "/")
+		routes = append(routes, route)
+	}
+	// Longest prefix first, so the most specific route wins
+	sort.SliceStable(routes, func(i, j int) bool {
+		return len(routes[i].PathPrefix) > len(routes[j].PathPrefix)
+	})
+
+	return &BodyLimitMiddleware{
+		maxBytes: cfg.MaxBodyBytes,
+		routes:   routes,
+		logger:   logger,
+	}
+}
+
+// LimitBodySize answers 413 when the declared Content-Length exceeds the
+// route's limit, and otherwise caps the body so that reading past the limit
+// fails; the proxy turns that failure into a 413 as well. This covers chunked
+// bodies, which declare no length.
+func (m *BodyLimitMiddleware) LimitBodySize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limit := m.limitFor(r.URL.Path)
+		if limit <= 0 || r.Body == nil || r.Body == http.NoBody {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if r.ContentLength > limit {
+			m.logger.Warn("Request body too large",
+				zap.String("method", r.Method),
+				zap.String("path", r.URL.Path),
+				zap.Int64("content_length", r.ContentLength),
+				zap.Int64("limit", limit))
+			writeJSONError(w, http.StatusRequestEntityTooLarge, "Request body too large")
+			return
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// limitFor returns the body size limit for path (0 = no limit)
+func (m *BodyLimitMiddleware) limitFor(path string) int64 {
+	for _, route := range m.routes {
+		if hasSegmentPrefix(path, route.PathPrefix) {
+			return route.MaxBytes
+		}
+	}
+	return m.maxBytes
+}
@@ -0,0 +1,128 @@
+package middleware
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/canxphung/DA_CNPM_242/api_gateway/internal/config"
+	"go.uber.org/zap"
+)
+
+// readingHandler reads the whole body, answering 413 when the cap stops it
+// as the proxy does
+var readingHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	if _, err := io.ReadAll(r.Body); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			return
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+})
+
+// postBody POSTs size bytes to path, with a Content-Length or chunked
+func postBody(handler http.Handler, path string, size int, chunked bool) int {
+	var body io.Reader = strings.NewReader(strings.Repeat("x", size))
+	if chunked {
+		// Hide the length, as a chunked upload does
+		body = io.MultiReader(body)
+	}
+	req := httptest.NewRequest(http.MethodPost, path, body)
+	if chunked {
+		req.ContentLength = -1
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec.Code
+}
+
+func newTestBodyLimit(maxBytes int64, routes ...config.BodyLimit) http.Handler {
+	cfg := &config.RequestConfig{MaxBodyBytes: maxBytes, BodyLimits: routes}
+	return NewBodyLimitMiddleware(cfg, zap.NewNop()).LimitBodySize(readingHandler)
+}
+
+func TestLimitBodySizeAroundTheLimit(t *testing.T) {
+	handler := newTestBodyLimit(1024)
+
+	for _, chunked := range []bool{false, true} {
+		if code := postBody(handler, "/api/v1/core-operations/plants", 1023, chunked); code != http.StatusOK {
+			t.Errorf("chunked=%v, just under: status %d, want 200", chunked, code)
+		}
+		if code := postBody(handler, "/api/v1/core-operations/plants", 1024, chunked); code != http.StatusOK {
+			t.Errorf("chunked=%v, at the limit: status %d, want 200", chunked, code)
+		}
+		if code := postBody(handler, "/api/v1/core-operations/plants", 1025, chunked); code != http.StatusRequestEntityTooLarge {
+			t.Errorf("chunked=%v, just over: status %d, want 413", chunked, code)
+		}
+	}
+}
+
+func TestLimitBodySizeRejectsDeclaredLength(t *testing.T) {
+	called := false
+	handler := NewBodyLimitMiddleware(&config.RequestConfig{MaxBodyBytes: 10}, zap.NewNop()).
+		LimitBodySize(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { called = true }))
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/core-operations/plants", strings.NewReader(strings.Repeat("x", 11)))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusRequestEntityTooLarge || called {
+		t.Errorf("status %d, next called %v: want 413 before the body is read", rec.Code, called)
+	}
+	if rec.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("Content-Type = %q, want a JSON error", rec.Header().Get("Content-Type"))
+	}
+}
+
+func TestLimitBodySizePerRoute(t *testing.T) {
+	handler := newTestBodyLimit(100,
+		config.BodyLimit{PathPrefix: "/api/v1/core-operations/sensors/", MaxBytes: 1000},
+		config.BodyLimit{PathPrefix: "/api/v1/core-operations/sensors/batch", MaxBytes: 5000},
+		config.BodyLimit{PathPrefix: "/api/v1/greenhouse-ai/upload", MaxBytes: 0},
+	)
+
+	tests := []struct {
+		name string
+		path string
+		// limit is the expected cap, or 0 for none
+		limit int
+	}{
+		{"default", "/api/v1/core-operations/plants", 100},
+		{"route limit", "/api/v1/core-operations/sensors/collect", 1000},
+		{"route limit at the prefix itself", "/api/v1/core-operations/sensors", 1000},
+		{"longest prefix wins", "/api/v1/core-operations/sensors/batch/ingest", 5000},
+		{"whole segments only", "/api/v1/core-operations/sensors/batches", 1000},
+		{"similar path keeps the default", "/api/v1/core-operations/sensorsx", 100},
+		{"zero disables the cap", "/api/v1/greenhouse-ai/upload/image", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.limit == 0 {
+				if code := postBody(handler, tt.path, 100000, true); code != http.StatusOK {
+					t.Errorf("uncapped: status %d, want 200", code)
+				}
+				return
+			}
+			if code := postBody(handler, tt.path, tt.limit, true); code != http.StatusOK {
+				t.Errorf("at %d bytes: status %d, want 200", tt.limit, code)
+			}
+			if code := postBody(handler, tt.path, tt.limit+1, true); code != http.StatusRequestEntityTooLarge {
+				t.Errorf("at %d bytes: status %d, want 413", tt.limit+1, code)
+			}
+		})
+	}
+}
+
+func TestLimitBodySizeDisabled(t *testing.T) {
+	handler := newTestBodyLimit(0)
+	if code := postBody(handler, "/api/v1/core-operations/plants", 100000, false); code != http.StatusOK {
+		t.Errorf("status %d, want 200 without a limit", code)
+	}
+}
@@ -0,0 +1,270 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/canxphung/DA_CNPM_242/api_gateway/internal/auth"
+	"github.com/canxphung/DA_CNPM_242/api_gateway/internal/config"
+	"github.com/canxphung/DA_CNPM_242/api_gateway/internal/metrics"
+	"github.com/canxphung/DA_CNPM_242/api_gateway/internal/store"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// CacheMiddleware serves repeated safe requests to configured routes from memory
+type CacheMiddleware struct {
+	routes       []config.CacheRoute
+	maxBodyBytes int64
+	entries      *store.Store[*cachedResponse]
+	requests     *prometheus.CounterVec
+	logger       *zap.Logger
+}
+
+// cachedResponse is a stored 200 response
+type cachedResponse struct {
+	header   http.Header
+	body     []byte
+	storedAt time.Time
+}
+
+// NewCacheMiddleware creates a new response cache middleware
+func NewCacheMiddleware(cfg *config.CacheConfig, reg prometheus.Registerer, logger *zap.Logger) *CacheMiddleware {
+	requests := metrics.RegisterOrReuse(reg, prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "api_gateway",
+			Name:      "cache_requests_total",
+			Help:      "Total number of requests to cacheable routes by result (hit or miss); hit rate = hit / total",
+		},
+		[]string{"result"},
+	))
+
+	return &CacheMiddleware{
+		routes:       cfg.Routes,
+		maxBodyBytes: cfg.MaxBodyBytes,
+		entries:      store.New[*cachedResponse]("response_cache", cfg.MaxEntries, 0, reg),
+		requests:     requests,
+		logger:       logger,
+	}
+}
+
+// CacheResponses answers safe requests (see SafeMethodPolicy) to cacheable
+// routes from the cache when a fresh entry exists, and otherwise stores
+// successful responses for the route's TTL. It must run after authentication
+// so per-user entries stay apart, and after SafeMethodPolicy.MarkSafeRequests.
+func (m *CacheMiddleware) CacheResponses(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route, ok := m.routeFor(r)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		// Requests with a body, such as query-over-POST, are keyed by it too
+		body, ok := m.bodyKey(r)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		key := cacheKey(r, route) + body
+		cacheControl := strings.ToLower(r.Header.Get("Cache-Control"))
+		noStore := strings.Contains(cacheControl, "no-store")
+		// no-cache asks for a fresh response, which may still be stored for others
+		noCache := noStore || strings.Contains(cacheControl, "no-cache") ||
+			strings.Contains(strings.ToLower(r.Header.Get("Pragma")), "no-cache")
+
+		if !noCache {
+			if cached, found := m.entries.Get(key); found {
+				m.requests.WithLabelValues("hit").Inc()
+				m.serveCached(w, cached)
+				return
+			}
+		}
+		m.requests.WithLabelValues("miss").Inc()
+
+		w.Header().Set("X-Cache", "MISS")
+		if noStore {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cw := &cachingResponseWriter{ResponseWriter: w, limit: m.maxBodyBytes}
+		next.ServeHTTP(cw, r)
+
+		if cw.cacheable() {
+			m.entries.SetWithTTL(key, &cachedResponse{
+				header:   cacheableHeader(cw.Header()),
+				body:     cw.body.Bytes(),
+				storedAt: time.Now(),
+			}, route.TTL)
+			m.logger.Debug("Response cached",
+				zap.String("path", r.URL.Path),
+				zap.Int("bytes", cw.body.Len()),
+				zap.Duration("ttl", route.TTL))
+		}
+	})
+}
+
+// routeFor returns the cache route for a safe request, if its path has one
+func (m *CacheMiddleware) routeFor(r *http.Request) (config.CacheRoute, bool) {
+	if !IsSafeRequest(r.Context()) {
+		return config.CacheRoute{}, false
+	}
+	for _, route := range m.routes {
+		if strings.HasPrefix(r.URL.Path, route.PathPrefix) {
+			return route, true
+		}
+	}
+	return config.CacheRoute{}, false
+}
+
+// bodyKey reads the request body for the cache key and puts it back for the
+// backend. Bodies over the size cap are not keyed; their requests bypass the cache.
+func (m *CacheMiddleware) bodyKey(r *http.Request) (string, bool) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return "", true
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, m.maxBodyBytes+1))
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+	if err != nil || int64(len(body)) > m.maxBodyBytes {
+		return "", false
+	}
+	sum := sha256.Sum256(body)
+	return "\nbody: " + hex.EncodeToString(sum[:]), true
+}
+
+// serveCached writes a cached response with its age
+func (m *CacheMiddleware) serveCached(w http.ResponseWriter, cached *cachedResponse) {
+	for name, values := range cached.header {
+		w.Header()[name] = append([]string(nil), values...)
+	}
+	w.Header().Set("X-Cache", "HIT")
+	w.Header().Set("Age", strconv.Itoa(int(time.Since(cached.storedAt).Seconds())))
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(cached.body); err != nil {
+		m.logger.Debug("Failed to write cached response", zap.Error(err))
+	}
+}
+
+// cacheableHeader copies the response headers that belong to the response
+// itself, leaving out those set per request by the gateway (request ID, CORS)
+func cacheableHeader(header http.Header) http.Header {
+	stored := header.Clone()
+	for name := range stored {
+		if name == "X-Request-Id" || name == "X-Cache" || strings.HasPrefix(name, "Access-Control-") {
+			delete(stored, name)
+		}
+	}
+	return stored
+}
+
+// cacheKey identifies the response for a request: its path, the relevant
+// query parameters and varying headers, and the user unless the route is shared
+func cacheKey(r *http.Request, route config.CacheRoute) string {
+	var key strings.Builder
+	key.WriteString(r.Method)
+	key.WriteString(" ")
+	key.WriteString(r.URL.Path)
+
+	query := r.URL.Query()
+	if len(route.QueryParams) > 0 {
+		relevant := url.Values{}
+		for _, name := range route.QueryParams {
+			if values, ok := query[name]; ok {
+				relevant[name] = values
+			}
+		}
+		query = relevant
+	}
+	// Encode sorts by name, so parameter order does not matter
+	key.WriteString("?")
+	key.WriteString(query.Encode())
+
+	vary := append([]string(nil), route.Vary...)
+	sort.Strings(vary)
+	for _, name := range vary {
+		key.WriteString("\n")
+		key.WriteString(http.CanonicalHeaderKey(name))
+		key.WriteString(": ")
+		key.WriteString(r.Header.Get(name))
+	}
+
+	if !route.Shared {
+		key.WriteString("\nuser: ")
+		if user := auth.GetUserFromContext(r.Context()); user != nil {
+			key.WriteString(user.ID)
+		}
+	}
+	return key.String()
+}
+
+// cachingResponseWriter passes the response through while keeping a copy of
+// its body, as long as it stays within the size cap
+type cachingResponseWriter struct {
+	http.ResponseWriter
+	status   int
+	body     bytes.Buffer
+	limit    int64
+	tooLarge bool
+}
+
+func (cw *cachingResponseWriter) WriteHeader(code int) {
+	if cw.status == 0 && !isInformational(code) {
+		cw.status = code
+	}
+	cw.ResponseWriter.WriteHeader(code)
+}
+
+func (cw *cachingResponseWriter) Write(data []byte) (int, error) {
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+	if !cw.tooLarge {
+		if int64(cw.body.Len()+len(data)) > cw.limit {
+			cw.tooLarge = true
+			cw.body.Reset()
+		} else {
+			cw.body.Write(data)
+		}
+	}
+	return cw.ResponseWriter.Write(data)
+}
+
+// Flush implements the http.Flusher interface
+func (cw *cachingResponseWriter) Flush() {
+	if flusher, ok := cw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap returns the underlying ResponseWriter for http.ResponseController
+func (cw *cachingResponseWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// cacheable reports whether the finished response may be stored: a 200
+// within the size cap, not an event stream, that the backend did not mark
+// private or uncacheable
+func (cw *cachingResponseWriter) cacheable() bool {
+	if cw.status != http.StatusOK || cw.tooLarge {
+		return false
+	}
+	if cw.Header().Get("Set-Cookie") != "" || strings.HasPrefix(cw.Header().Get("Content-Type"), "text/event-stream") {
+		return false
+	}
+	cacheControl := strings.ToLower(cw.Header().Get("Cache-Control"))
+	return !strings.Contains(cacheControl, "no-store") &&
+		!strings.Contains(cacheControl, "private") &&
+		!strings.Contains(cacheControl, "no-cache")
+}
@@ -0,0 +1,254 @@
+package middleware
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/canxphung/DA_CNPM_242/api_gateway/internal/auth"
+	"github.com/canxphung/DA_CNPM_242/api_gateway/internal/config"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// countingBackend answers with a body that changes on every call
+type countingBackend struct {
+	calls  atomic.Int32
+	status int
+	header http.Header
+}
+
+func (b *countingBackend) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	n := b.calls.Add(1)
+	for name, values := range b.header {
+		w.Header()[name] = values
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if b.status != 0 {
+		w.WriteHeader(b.status)
+	}
+	_, _ = w.Write([]byte(`{"call":` + strconv.Itoa(int(n)) + `}`))
+}
+
+func newTestCache(t *testing.T, routes ...config.CacheRoute) (*CacheMiddleware, *prometheus.Registry) {
+	t.Helper()
+	reg := prometheus.NewRegistry()
+	return NewCacheMiddleware(&config.CacheConfig{MaxEntries: 100, MaxBodyBytes: 1024, Routes: routes}, reg, zap.NewNop()), reg
+}
+
+// withCache puts the cache in front of next, marking safe requests as main does
+func withCache(cache *CacheMiddleware, next http.Handler, safeRoutes ...config.SafeRoute) http.Handler {
+	return NewSafeMethodPolicy(safeRoutes).MarkSafeRequests(cache.CacheResponses(next))
+}
+
+// getThrough sends a GET through handler with optional headers
+func getThrough(handler http.Handler, path string, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestCacheHitWithinTTL(t *testing.T) {
+	cache, reg := newTestCache(t, config.CacheRoute{PathPrefix: "/api/v1/core-operations/plants", TTL: time.Minute, Shared: true})
+	backend := &countingBackend{}
+	handler := withCache(cache, backend)
+
+	first := getThrough(handler, "/api/v1/core-operations/plants", nil)
+	second := getThrough(handler, "/api/v1/core-operations/plants", nil)
+
+	if first.Header().Get("X-Cache") != "MISS" || second.Header().Get("X-Cache") != "HIT" {
+		t.Errorf("X-Cache = %q then %q, want MISS then HIT", first.Header().Get("X-Cache"), second.Header().Get("X-Cache"))
+	}
+	if second.Body.String() != first.Body.String() || second.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("cached response = %q (%s), want %q", second.Body.String(), second.Header().Get("Content-Type"), first.Body.String())
+	}
+	if backend.calls.Load() != 1 {
+		t.Errorf("backend calls = %d, want 1", backend.calls.Load())
+	}
+	if hits := metricLabels(t, reg, "api_gateway_cache_requests_total"); len(hits) != 2 {
+		t.Errorf("cache_requests_total series = %v, want hit and miss", hits)
+	}
+}
+
+func TestCacheRefetchesAfterExpiry(t *testing.T) {
+	cache, _ := newTestCache(t, config.CacheRoute{PathPrefix: "/api/v1/core-operations/plants", TTL: 50 * time.Millisecond, Shared: true})
+	backend := &countingBackend{}
+	handler := withCache(cache, backend)
+
+	getThrough(handler, "/api/v1/core-operations/plants", nil)
+	time.Sleep(100 * time.Millisecond)
+	rec := getThrough(handler, "/api/v1/core-operations/plants", nil)
+
+	if rec.Header().Get("X-Cache") != "MISS" || backend.calls.Load() != 2 {
+		t.Errorf("after expiry: X-Cache %q, backend calls %d, want a MISS and a second call", rec.Header().Get("X-Cache"), backend.calls.Load())
+	}
+}
+
+func TestCacheBypass(t *testing.T) {
+	route := config.CacheRoute{PathPrefix: "/api/v1/core-operations/plants", TTL: time.Minute, Shared: true}
+
+	tests := []struct {
+		name    string
+		backend *countingBackend
+		headers map[string]string
+	}{
+		{"client no-cache", &countingBackend{}, map[string]string{"Cache-Control": "no-cache"}},
+		{"client Pragma no-cache", &countingBackend{}, map[string]string{"Pragma": "no-cache"}},
+		{"non-200", &countingBackend{status: http.StatusAccepted}, nil},
+		{"backend private", &countingBackend{header: http.Header{"Cache-Control": {"private"}}}, nil},
+		{"backend Set-Cookie", &countingBackend{header: http.Header{"Set-Cookie": {"session=1"}}}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cache, _ := newTestCache(t, route)
+			handler := withCache(cache, tt.backend)
+
+			getThrough(handler, "/api/v1/core-operations/plants", tt.headers)
+			if rec := getThrough(handler, "/api/v1/core-operations/plants", tt.headers); rec.Header().Get("X-Cache") == "HIT" {
+				t.Error("second request served from the cache")
+			}
+			if tt.backend.calls.Load() != 2 {
+				t.Errorf("backend calls = %d, want 2", tt.backend.calls.Load())
+			}
+		})
+	}
+}
+
+func TestCacheSizeCap(t *testing.T) {
+	cache, _ := newTestCache(t, config.CacheRoute{PathPrefix: "/api/v1/greenhouse-ai/", TTL: time.Minute, Shared: true})
+	cache.maxBodyBytes = 5
+	backend := &countingBackend{}
+	handler := withCache(cache, backend)
+
+	getThrough(handler, "/api/v1/greenhouse-ai/models", nil)
+	getThrough(handler, "/api/v1/greenhouse-ai/models", nil)
+	if backend.calls.Load() != 2 {
+		t.Errorf("a body over the size cap was cached")
+	}
+}
+
+func TestCacheKeyQueryAndRoute(t *testing.T) {
+	cache, _ := newTestCache(t, config.CacheRoute{PathPrefix: "/api/v1/core-operations/sensors", TTL: time.Minute, QueryParams: []string{"zone"}, Shared: true})
+	backend := &countingBackend{}
+	handler := withCache(cache, backend)
+
+	getThrough(handler, "/api/v1/core-operations/sensors?zone=a&ts=1", nil)
+	if rec := getThrough(handler, "/api/v1/core-operations/sensors?ts=2&zone=a", nil); rec.Header().Get("X-Cache") != "HIT" {
+		t.Error("irrelevant query parameter split the cache")
+	}
+	if rec := getThrough(handler, "/api/v1/core-operations/sensors?zone=b", nil); rec.Header().Get("X-Cache") != "MISS" {
+		t.Error("relevant query parameter did not split the cache")
+	}
+	// Paths outside the configured routes are never cached
+	getThrough(handler, "/api/v1/core-operations/plants", nil)
+	if rec := getThrough(handler, "/api/v1/core-operations/plants", nil); rec.Header().Get("X-Cache") != "" {
+		t.Errorf("uncached route got X-Cache %q", rec.Header().Get("X-Cache"))
+	}
+}
+
+func TestCachePerUser(t *testing.T) {
+	manager, err := auth.NewJWTManager(&config.JWTConfig{SecretKey: "secret", ExpirationMinutes: 60, UserIDClaims: []string{"sub"}}, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	authMiddleware := auth.NewAuthMiddleware(manager, &config.AuthConfig{}, &config.ServicesConfig{},
+		auth.NewMemoryRevocationStore(prometheus.NewRegistry()), zap.NewNop())
+	cache, _ := newTestCache(t, config.CacheRoute{PathPrefix: "/api/v1/user-auth/profile", TTL: time.Minute})
+	backend := &countingBackend{}
+	handler := authMiddleware.Authenticate(withCache(cache, backend))
+
+	bearer := func(sub string) map[string]string {
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+			"sub": sub, "role": "user", "exp": time.Now().Add(time.Hour).Unix(),
+		}).SignedString([]byte("secret"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return map[string]string{"Authorization": "Bearer " + token}
+	}
+
+	alice := getThrough(handler, "/api/v1/user-auth/profile", bearer("alice"))
+	bob := getThrough(handler, "/api/v1/user-auth/profile", bearer("bob"))
+	if bob.Header().Get("X-Cache") != "MISS" || bob.Body.String() == alice.Body.String() {
+		t.Errorf("bob got alice's cached profile: %q", bob.Body.String())
+	}
+	if again := getThrough(handler, "/api/v1/user-auth/profile", bearer("alice")); again.Body.String() != alice.Body.String() {
+		t.Errorf("alice's second request = %q, want her cached %q", again.Body.String(), alice.Body.String())
+	}
+}
+
+// postThrough sends a POST with body through handler
+func postThrough(handler http.Handler, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+// echoCountingBackend answers with the call number and the request body it received
+type echoCountingBackend struct {
+	calls atomic.Int32
+}
+
+func (b *echoCountingBackend) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	n := b.calls.Add(1)
+	body, _ := io.ReadAll(r.Body)
+	_, _ = w.Write([]byte(strconv.Itoa(int(n)) + ":" + string(body)))
+}
+
+func TestCacheFollowsSafeMethodPolicy(t *testing.T) {
+	cache, _ := newTestCache(t, config.CacheRoute{PathPrefix: "/api/v1/greenhouse-ai/api", TTL: time.Minute, Shared: true})
+	backend := &echoCountingBackend{}
+	handler := withCache(cache, backend, config.SafeRoute{PathPrefix: "/api/v1/greenhouse-ai/api/query", Methods: []string{"POST"}})
+
+	// A POST declared safe is cached, keyed by its body
+	first := postThrough(handler, "/api/v1/greenhouse-ai/api/query", `{"sensor":"light"}`)
+	second := postThrough(handler, "/api/v1/greenhouse-ai/api/query", `{"sensor":"light"}`)
+	if first.Body.String() != `1:{"sensor":"light"}` {
+		t.Errorf("backend saw %q, want the full body", first.Body.String())
+	}
+	if second.Header().Get("X-Cache") != "HIT" || second.Body.String() != first.Body.String() {
+		t.Errorf("repeated safe POST: X-Cache %q body %q, want a HIT", second.Header().Get("X-Cache"), second.Body.String())
+	}
+	other := postThrough(handler, "/api/v1/greenhouse-ai/api/query", `{"sensor":"humidity"}`)
+	if other.Header().Get("X-Cache") != "MISS" || other.Body.String() != `2:{"sensor":"humidity"}` {
+		t.Errorf("safe POST with another body: X-Cache %q body %q, want a MISS", other.Header().Get("X-Cache"), other.Body.String())
+	}
+
+	// An undeclared POST on a cached route is never stored
+	for i := 0; i < 2; i++ {
+		if rec := postThrough(handler, "/api/v1/greenhouse-ai/api/predict", `{"plant":"basil"}`); rec.Header().Get("X-Cache") != "" {
+			t.Errorf("undeclared POST %d: X-Cache %q, want the cache bypassed", i, rec.Header().Get("X-Cache"))
+		}
+	}
+	if calls := backend.calls.Load(); calls != 4 {
+		t.Errorf("backend calls = %d, want 4", calls)
+	}
+}
+
+func TestCacheSkipsLargeRequestBodies(t *testing.T) {
+	cache, _ := newTestCache(t, config.CacheRoute{PathPrefix: "/api/v1/greenhouse-ai/api", TTL: time.Minute, Shared: true})
+	backend := &echoCountingBackend{}
+	handler := withCache(cache, backend, config.SafeRoute{PathPrefix: "/api/v1/greenhouse-ai/api/query", Methods: []string{"POST"}})
+
+	large := strings.Repeat("x", 2048)
+	for i := 0; i < 2; i++ {
+		rec := postThrough(handler, "/api/v1/greenhouse-ai/api/query", large)
+		if rec.Header().Get("X-Cache") != "" {
+			t.Errorf("request %d: X-Cache %q, want the cache bypassed", i, rec.Header().Get("X-Cache"))
+		}
+		if want := strconv.Itoa(i+1) + ":" + large; rec.Body.String() != want {
+			t.Errorf("request %d: backend did not receive the whole body (%d bytes)", i, rec.Body.Len())
+		}
+	}
+}
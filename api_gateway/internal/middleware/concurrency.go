@@ -0,0 +1,150 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/canxphung/DA_CNPM_242/api_gateway/internal/config"
+	"github.com/canxphung/DA_CNPM_242/api_gateway/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// ConcurrencyMiddleware caps in-flight requests and keeps a reserved share of
+// the capacity for priority traffic (health checks, irrigation control), so a
+// storm of bulk queries cannot starve critical operations.
+type ConcurrencyMiddleware struct {
+	// general slots are shared by all requests; reserved slots only by priority ones
+	general       chan struct{}
+	reserved      chan struct{}
+	queueTimeout  time.Duration
+	priorityPaths []string
+	inFlight      prometheus.Gauge
+	rejected      *prometheus.CounterVec
+	overload      *OverloadResponder
+	logger        *zap.Logger
+}
+
+// NewConcurrencyMiddleware creates a new concurrency limit middleware
+func NewConcurrencyMiddleware(cfg *config.ConcurrencyConfig, overload *OverloadResponder, reg prometheus.Registerer, logger *zap.Logger) *ConcurrencyMiddleware {
+	const namespace = "api_gateway"
+
+	inFlight := metrics.RegisterOrReuse(reg, prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "concurrency_in_flight_requests",
+			Help:      "Current number of requests holding a concurrency slot",
+		},
+	))
+
+	rejected := metrics.RegisterOrReuse(reg, prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "concurrency_rejected_total",
+			Help:      "Total number of requests rejected after waiting for a concurrency slot",
+		},
+		[]string{"priority"},
+	))
+
+	// Sizes are only meaningful when the limit is enabled (MaxInFlight > 0)
+	generalSlots, reservedSlots := 0, 0
+	if cfg.MaxInFlight > 0 {
+		generalSlots, reservedSlots = cfg.MaxInFlight-cfg.ReservedPriority, cfg.ReservedPriority
+	}
+
+	return &ConcurrencyMiddleware{
+		general:       make(chan struct{}, generalSlots),
+		reserved:      make(chan struct{}, reservedSlots),
+		queueTimeout:  cfg.QueueTimeout,
+		priorityPaths: cfg.PriorityPaths,
+		inFlight:      inFlight,
+		rejected:      rejected,
+		overload:      overload,
+		logger:        logger,
+	}
+}
+
+// LimitConcurrency queues requests for a free slot and rejects them with the
+// overload response once the queue timeout expires. Priority requests take a general slot when
+// one is free and fall back to the reserved slots otherwise. Streaming
+// requests are limited separately and never hold a slot.
+func (m *ConcurrencyMiddleware) LimitConcurrency(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if IsStreamingRequest(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		priority := m.isPriority(r.URL.Path)
+		slot := m.acquire(r, priority)
+		if slot == nil {
+			m.reject(w, r, priority)
+			return
+		}
+		m.inFlight.Inc()
+		defer func() {
+			<-slot
+			m.inFlight.Dec()
+		}()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (m *ConcurrencyMiddleware) isPriority(path string) bool {
+	for _, prefix := range m.priorityPaths {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// acquire waits for a slot and returns the pool it was taken from,
+// or nil if none became free before the queue timeout or the client left
+func (m *ConcurrencyMiddleware) acquire(r *http.Request, priority bool) chan struct{} {
+	select {
+	case m.general <- struct{}{}:
+		return m.general
+	default:
+	}
+
+	// Reserved slots are left nil (never ready) for normal requests
+	var reserved chan struct{}
+	if priority {
+		reserved = m.reserved
+	}
+
+	timer := time.NewTimer(m.queueTimeout)
+	defer timer.Stop()
+
+	select {
+	case m.general <- struct{}{}:
+		return m.general
+	case reserved <- struct{}{}:
+		return m.reserved
+	case <-timer.C:
+		return nil
+	case <-r.Context().Done():
+		return nil
+	}
+}
+
+func (m *ConcurrencyMiddleware) reject(w http.ResponseWriter, r *http.Request, priority bool) {
+	m.rejected.WithLabelValues(priorityLabel(priority)).Inc()
+	m.logger.Warn("Concurrency limit reached",
+		zap.String("method", r.Method),
+		zap.String("path", r.URL.Path),
+		zap.Bool("priority", priority),
+		zap.Duration("queue_timeout", m.queueTimeout))
+	// A slot is typically freed within one queue timeout
+	m.overload.Reject(w, OverloadConcurrency, "Gateway is overloaded, please retry", m.queueTimeout)
+}
+
+func priorityLabel(priority bool) string {
+	if priority {
+		return "high"
+	}
+	return "normal"
+}
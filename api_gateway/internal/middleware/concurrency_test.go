@@ -0,0 +1,123 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/canxphung/DA_CNPM_242/api_gateway/internal/config"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+func newTestConcurrency(maxInFlight, reserved int, queueTimeout time.Duration) (*ConcurrencyMiddleware, *prometheus.Registry) {
+	reg := prometheus.NewRegistry()
+	overload := NewOverloadResponder(&config.OverloadConfig{RateLimitStatus: http.StatusTooManyRequests, CapacityStatus: http.StatusServiceUnavailable})
+	return NewConcurrencyMiddleware(&config.ConcurrencyConfig{
+		MaxInFlight:      maxInFlight,
+		ReservedPriority: reserved,
+		QueueTimeout:     queueTimeout,
+		PriorityPaths:    []string{"/health", "/api/v1/core-operations/irrigation"},
+	}, overload, reg, zap.NewNop()), reg
+}
+
+// holdSlot sends a request for path through limiter and keeps it in flight
+// until the returned function is called
+func holdSlot(t *testing.T, limiter *ConcurrencyMiddleware, path string) func() {
+	t.Helper()
+	started, done, finished := make(chan struct{}), make(chan struct{}), make(chan struct{})
+	handler := limiter.LimitConcurrency(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-done
+	}))
+	go func() {
+		defer close(finished)
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}()
+	select {
+	case <-started:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("%s did not get a slot", path)
+	}
+	return func() {
+		close(done)
+		<-finished
+	}
+}
+
+// serveLimited sends a request for path through limiter and returns the response
+func serveLimited(limiter *ConcurrencyMiddleware, path string, header map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	for key, value := range header {
+		req.Header.Set(key, value)
+	}
+	rec := httptest.NewRecorder()
+	limiter.LimitConcurrency(okHandler).ServeHTTP(rec, req)
+	return rec
+}
+
+func TestLimitConcurrencyReservesPrioritySlots(t *testing.T) {
+	limiter, reg := newTestConcurrency(2, 1, 20*time.Millisecond)
+	releaseGeneral := holdSlot(t, limiter, "/api/v1/core-operations/plants")
+	defer releaseGeneral()
+
+	tests := []struct {
+		name   string
+		path   string
+		header map[string]string
+		want   int
+	}{
+		{"normal request cannot use the reserved slot", "/api/v1/core-operations/plants", nil, http.StatusServiceUnavailable},
+		{"priority request uses the reserved slot", "/health", nil, http.StatusOK},
+		{"priority prefix", "/api/v1/core-operations/irrigation/valves/1/open", nil, http.StatusOK},
+		{"streaming request holds no slot", "/api/v1/greenhouse-ai/api/chat", map[string]string{"Accept": "text/event-stream"}, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serveLimited(limiter, tt.path, tt.header)
+			if rec.Code != tt.want {
+				t.Errorf("status %d, want %d", rec.Code, tt.want)
+			}
+			if tt.want == http.StatusServiceUnavailable && rec.Header().Get("Retry-After") != "1" {
+				t.Errorf("Retry-After = %q, want the queue timeout rounded up to 1", rec.Header().Get("Retry-After"))
+			}
+		})
+	}
+
+	// With the reserved slot taken as well, priority requests wait and are rejected too
+	releaseReserved := holdSlot(t, limiter, "/health")
+	defer releaseReserved()
+	if rec := serveLimited(limiter, "/health", nil); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("priority request at capacity: status %d, want 503", rec.Code)
+	}
+
+	rejected := map[string]bool{}
+	for _, labels := range metricLabels(t, reg, "api_gateway_concurrency_rejected_total") {
+		rejected[labels["priority"]] = true
+	}
+	if !rejected["normal"] || !rejected["high"] {
+		t.Errorf("rejections recorded for %v, want normal and high", rejected)
+	}
+}
+
+func TestLimitConcurrencyQueuesUntilASlotFrees(t *testing.T) {
+	limiter, _ := newTestConcurrency(1, 0, time.Second)
+	release := holdSlot(t, limiter, "/api/v1/core-operations/plants")
+
+	result := make(chan int)
+	go func() {
+		result <- serveLimited(limiter, "/api/v1/core-operations/plants", nil).Code
+	}()
+	time.Sleep(20 * time.Millisecond)
+	release()
+
+	select {
+	case code := <-result:
+		if code != http.StatusOK {
+			t.Errorf("queued request: status %d, want 200 once the slot freed", code)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("queued request never served")
+	}
+}
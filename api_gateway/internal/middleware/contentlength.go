@@ -0,0 +1,60 @@
+package middleware
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+
+	"github.com/canxphung/DA_CNPM_242/api_gateway/internal/config"
+	"go.uber.org/zap"
+)
+
+// ContentLengthMiddleware rejects requests whose body does not match the declared Content-Length
+type ContentLengthMiddleware struct {
+	bufferLimit int64
+	logger      *zap.Logger
+}
+
+// NewContentLengthMiddleware creates a new Content-Length validation middleware
+func NewContentLengthMiddleware(cfg *config.RequestConfig, logger *zap.Logger) *ContentLengthMiddleware {
+	return &ContentLengthMiddleware{
+		bufferLimit: cfg.ContentLengthBufferLimit,
+		logger:      logger,
+	}
+}
+
+// ValidateContentLength buffers small fixed-length bodies before they are proxied
+// so that a client declaring more bytes than it sends gets a 400 instead of
+// leaving the backend waiting for the missing bytes.
+func (m *ContentLengthMiddleware) ValidateContentLength(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Chunked (streaming) and large bodies are passed through untouched, as are
+		// bodies the client only sends after the backend answers 100 Continue;
+		// reading them here would make the gateway send 100 Continue itself
+		if r.Body == nil || r.Body == http.NoBody || r.ContentLength <= 0 || r.ContentLength > m.bufferLimit ||
+			r.Header.Get("Expect") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		body, err := io.ReadAll(io.LimitReader(r.Body, r.ContentLength))
+		r.Body.Close()
+		if err != nil || int64(len(body)) != r.ContentLength {
+			m.logger.Warn("Request body does not match Content-Length",
+				zap.String("method", r.Method),
+				zap.String("path", r.URL.Path),
+				zap.Int64("declared", r.ContentLength),
+				zap.Int("received", len(body)),
+				zap.Error(err))
+			writeJSONError(w, http.StatusBadRequest, "Request body does not match Content-Length")
+			return
+		}
+
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		r.GetBody = func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(body)), nil
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
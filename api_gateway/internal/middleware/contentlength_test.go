@@ -0,0 +1,90 @@
+package middleware
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/canxphung/DA_CNPM_242/api_gateway/internal/config"
+	"go.uber.org/zap"
+)
+
+func TestValidateContentLength(t *testing.T) {
+	m := NewContentLengthMiddleware(&config.RequestConfig{ContentLengthBufferLimit: 64}, zap.NewNop())
+
+	tests := []struct {
+		name string
+		body string
+		// declared is the Content-Length sent, or -1 for a chunked body
+		declared int64
+		expect   string
+		want     int
+		// wantRead is whether next must receive the body unchanged
+		wantRead bool
+	}{
+		{"matching length", `{"plant":"basil"}`, 17, "", http.StatusOK, true},
+		{"body shorter than declared", `{"plant"`, 17, "", http.StatusBadRequest, false},
+		{"chunked body is not buffered", `{"plant":"basil"}`, -1, "", http.StatusOK, true},
+		{"body over the buffer limit is not buffered", strings.Repeat("x", 65), 65, "", http.StatusOK, true},
+		{"short body over the buffer limit is passed on", strings.Repeat("x", 10), 100, "", http.StatusOK, false},
+		{"body sent after 100 Continue is not read", `{"plant"`, 17, "100-continue", http.StatusOK, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var received string
+			called := false
+			handler := m.ValidateContentLength(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				called = true
+				body, _ := io.ReadAll(r.Body)
+				received = string(body)
+			}))
+
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/core-operations/plants", io.MultiReader(strings.NewReader(tt.body)))
+			req.ContentLength = tt.declared
+			if tt.expect != "" {
+				req.Header.Set("Expect", tt.expect)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.want {
+				t.Errorf("status %d, want %d", rec.Code, tt.want)
+			}
+			if tt.want == http.StatusBadRequest {
+				if called {
+					t.Error("next called for a short body")
+				}
+				if rec.Header().Get("Content-Type") != "application/json" {
+					t.Errorf("Content-Type = %q, want a JSON error", rec.Header().Get("Content-Type"))
+				}
+			}
+			if tt.wantRead && received != tt.body {
+				t.Errorf("next read %q, want %q", received, tt.body)
+			}
+		})
+	}
+}
+
+func TestValidateContentLengthBodyCanBeReplayed(t *testing.T) {
+	m := NewContentLengthMiddleware(&config.RequestConfig{ContentLengthBufferLimit: 64}, zap.NewNop())
+	handler := m.ValidateContentLength(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.ReadAll(r.Body)
+		if r.GetBody == nil {
+			t.Fatal("GetBody not set on a buffered body")
+		}
+		replay, err := r.GetBody()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if body, _ := io.ReadAll(replay); string(body) != `{"plant":"basil"}` {
+			t.Errorf("replayed %q, want the whole body", body)
+		}
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/core-operations/plants", io.MultiReader(strings.NewReader(`{"plant":"basil"}`)))
+	req.ContentLength = 17
+	req.GetBody = nil
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+}
@@ -4,6 +4,7 @@ import (
 	"net/http"
 	"strings"
 
+	"github.com/canxphung/DA_CNPM_242/api_gateway/internal/config"
 	"go.uber.org/zap"
 )
 
@@ -15,13 +16,32 @@ type CORSMiddleware struct {
 }
 
 // NewCORSMiddleware creates a new CORS middleware
-func NewCORSMiddleware(allowedOrigins []string, logger *zap.Logger) *CORSMiddleware {
+func NewCORSMiddleware(cfg *config.CORSConfig, logger *zap.Logger) *CORSMiddleware {
 	return &CORSMiddleware{
-		AllowedOrigins: allowedOrigins,
+		AllowedOrigins: cfg.AllowedOrigins,
 		logger:         logger,
 	}
 }
 
+// AllowsOrigin reports whether a non-empty origin is allowed. A "*" inside an
+// allowed origin matches any text in its place, e.g. "http://*.localhost:5173".
+func (m *CORSMiddleware) AllowsOrigin(origin string) bool {
+	if origin == "" {
+		return false
+	}
+	for _, allowedOrigin := range m.AllowedOrigins {
+		if allowedOrigin == "*" || allowedOrigin == origin {
+			return true
+		}
+		if before, after, found := strings.Cut(allowedOrigin, "*"); found &&
+			len(origin) > len(before)+len(after) &&
+			strings.HasPrefix(origin, before) && strings.HasSuffix(origin, after) {
+			return true
+		}
+	}
+	return false
+}
+
 // EnableCORS adds CORS headers to responses
 func (m *CORSMiddleware) EnableCORS(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -32,37 +52,17 @@ func (m *CORSMiddleware) EnableCORS(next http.Handler) http.Handler {
 			zap.String("path", r.URL.Path),
 			zap.String("origin", origin))
 
-		// Check if origin is allowed
-		allowed := false
-		for _, allowedOrigin := range m.AllowedOrigins {
-			if allowedOrigin == "*" {
-				allowed = true
-				break
-			}
-			if allowedOrigin == origin {
-				allowed = true
-				break
-			}
-			// Support wildcard subdomains like *.localhost
-			if strings.Contains(allowedOrigin, "*") {
-				pattern := strings.ReplaceAll(allowedOrigin, "*", "")
-				if strings.Contains(origin, pattern) {
-					allowed = true
-					break
-				}
-			}
-		}
-
-		// Set CORS headers for allowed origins
-		if allowed && origin != "" {
-			// Only set the header once
+		// Set CORS headers for allowed origins only; a disallowed origin gets
+		// none, so the browser blocks the response
+		if m.AllowsOrigin(origin) {
 			w.Header().Set("Access-Control-Allow-Origin", origin)
 			w.Header().Set("Vary", "Origin")
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS, PATCH, HEAD")
+			w.Header().Set("Access-Control-Allow-Headers", "Accept, Authorization, Content-Type, X-CSRF-Token, X-Requested-With, Origin, X-Request-ID")
+			w.Header().Set("Access-Control-Expose-Headers", "X-Request-ID, X-Proxied-By")
+			w.Header().Set("Access-Control-Max-Age", "86400") // Cache preflight for 24 hours
 			m.logger.Debug("CORS: Origin allowed", zap.String("origin", origin))
-		} else if len(m.AllowedOrigins) > 0 && m.AllowedOrigins[0] == "*" {
-			// If first origin is *, allow all
-			w.Header().Set("Access-Control-Allow-Origin", "*")
-			m.logger.Debug("CORS: Wildcard origin allowed")
 		} else if origin == "" {
 			// Same-origin request, no CORS headers needed
 			m.logger.Debug("CORS: Same-origin request, no headers needed")
@@ -72,15 +72,6 @@ func (m *CORSMiddleware) EnableCORS(next http.Handler) http.Handler {
 				zap.Strings("allowed_origins", m.AllowedOrigins))
 		}
 
-		// Always set these CORS headers for proper handling when origin is present
-		if origin != "" {
-			w.Header().Set("Access-Control-Allow-Credentials", "true")
-			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS, PATCH, HEAD")
-			w.Header().Set("Access-Control-Allow-Headers", "Accept, Authorization, Content-Type, X-CSRF-Token, X-Requested-With, Origin, X-Request-ID")
-			w.Header().Set("Access-Control-Expose-Headers", "X-Request-ID, X-Proxied-By")
-			w.Header().Set("Access-Control-Max-Age", "86400") // Cache preflight for 24 hours
-		}
-
 		// Handle preflight requests (OPTIONS method)
 		if r.Method == "OPTIONS" {
 			m.logger.Debug("CORS: Handling OPTIONS preflight request",
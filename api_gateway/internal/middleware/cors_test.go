@@ -0,0 +1,76 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/canxphung/DA_CNPM_242/api_gateway/internal/config"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+// newCORSRouter mirrors main: a catch-all OPTIONS route behind EnableCORS
+func newCORSRouter(allowedOrigins ...string) *mux.Router {
+	cors := NewCORSMiddleware(&config.CORSConfig{AllowedOrigins: allowedOrigins}, zap.NewNop())
+	router := mux.NewRouter()
+	router.Methods("OPTIONS").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	router.Use(cors.EnableCORS)
+	router.Handle("/api/v1/core-operation/plants", okHandler).Methods("GET")
+	return router
+}
+
+// corsHeaders returns the Access-Control-* headers of a response
+func corsHeaders(header http.Header) http.Header {
+	found := http.Header{}
+	for name, values := range header {
+		if strings.HasPrefix(name, "Access-Control-") {
+			found[name] = values
+		}
+	}
+	return found
+}
+
+func TestCORSAllowedOrigin(t *testing.T) {
+	router := newCORSRouter("http://localhost:5173", "http://*.greenhouse.local")
+
+	for _, origin := range []string{"http://localhost:5173", "http://admin.greenhouse.local"} {
+		for _, method := range []string{http.MethodOptions, http.MethodGet} {
+			req := httptest.NewRequest(method, "/api/v1/core-operation/plants", nil)
+			req.Header.Set("Origin", origin)
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusOK {
+				t.Errorf("%s from %s: status %d", method, origin, rec.Code)
+			}
+			if got := rec.Header().Get("Access-Control-Allow-Origin"); got != origin {
+				t.Errorf("%s from %s: Access-Control-Allow-Origin = %q", method, origin, got)
+			}
+			if got := rec.Header().Get("Access-Control-Allow-Credentials"); got != "true" {
+				t.Errorf("%s from %s: Access-Control-Allow-Credentials = %q", method, origin, got)
+			}
+		}
+	}
+}
+
+func TestCORSDisallowedOriginGetsNoHeaders(t *testing.T) {
+	router := newCORSRouter("http://localhost:5173", "http://*.greenhouse.local")
+
+	for _, origin := range []string{"https://evil.example", "http://greenhouse.local.evil.example", "http://localhost:5174"} {
+		for _, method := range []string{http.MethodOptions, http.MethodGet} {
+			req := httptest.NewRequest(method, "/api/v1/core-operation/plants", nil)
+			req.Header.Set("Origin", origin)
+			req.Header.Set("Access-Control-Request-Method", "DELETE")
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			if headers := corsHeaders(rec.Header()); len(headers) > 0 {
+				t.Errorf("%s from %s: got CORS headers %v", method, origin, headers)
+			}
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package middleware
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/canxphung/DA_CNPM_242/api_gateway/internal/config"
+	"go.uber.org/zap"
+)
+
+// IPFilterMiddleware refuses requests from client IPs outside the configured ranges
+type IPFilterMiddleware struct {
+	rules            []ipFilterRule
+	trustedProxyHops int
+	logger           *zap.Logger
+}
+
+// ipFilterRule is a parsed config.IPFilterRule
+type ipFilterRule struct {
+	pathPrefix string
+	allow      []netip.Prefix
+	deny       []netip.Prefix
+}
+
+// NewIPFilterMiddleware creates a new IP filter middleware. The rules'
+// entries have been validated by config.LoadConfig.
+func NewIPFilterMiddleware(cfg *config.IPFilterConfig, trustedProxyHops int, logger *zap.Logger) *IPFilterMiddleware {
+	rules := make([]ipFilterRule, 0, len(cfg.Rules))
+	for _, rule := range cfg.Rules {
+		rules = append(rules, ipFilterRule{
+			pathPrefix: strings.TrimRight(rule.PathPrefix, "/"),
+			allow:      parsePrefixes(rule.Allow),
+			deny:       parsePrefixes(rule.Deny),
+		})
+	}
+
+	return &IPFilterMiddleware{
+		rules:            rules,
+		trustedProxyHops: trustedProxyHops,
+		logger:           logger,
+	}
+}
+
+// FilterIPs answers 403 when a rule covering the request path refuses the
+// client IP. It must run before authentication.
+func (m *IPFilterMiddleware) FilterIPs(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var ip netip.Addr
+		for _, rule := range m.rules {
+			if !hasSegmentPrefix(r.URL.Path, rule.pathPrefix) {
+				continue
+			}
+			if !ip.IsValid() {
+				// An unparsable address only passes rules without an allow list or deny entries
+				ip, _ = netip.ParseAddr(clientIP(r, m.trustedProxyHops))
+				ip = ip.Unmap()
+			}
+			if rule.allows(ip) {
+				continue
+			}
+
+			m.logger.Warn("Client IP not allowed",
+				zap.String("client_ip", ip.String()),
+				zap.String("remote_addr", r.RemoteAddr),
+				zap.String("method", r.Method),
+				zap.String("path", r.URL.Path),
+				zap.String("rule", rule.pathPrefix+"/"))
+			writeJSONError(w, http.StatusForbidden, "Access denied")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// allows reports whether the rule lets ip through: not denied and, when the
+// rule has an allow list, in it
+func (rule ipFilterRule) allows(ip netip.Addr) bool {
+	if !ip.IsValid() {
+		return len(rule.allow) == 0 && len(rule.deny) == 0
+	}
+	for _, prefix := range rule.deny {
+		if prefix.Contains(ip) {
+			return false
+		}
+	}
+	if len(rule.allow) == 0 {
+		return true
+	}
+	for _, prefix := range rule.allow {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// parsePrefixes parses CIDR ranges, treating a single IP as a range of one
+func parsePrefixes(entries []string) []netip.Prefix {
+	prefixes := make([]netip.Prefix, 0, len(entries))
+	for _, entry := range entries {
+		if prefix, err := netip.ParsePrefix(entry); err == nil {
+			// An IPv4 range written in IPv6 form matches plain IPv4 clients
+			if prefix.Addr().Is4In6() && prefix.Bits() >= 96 {
+				prefix = netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()-96)
+			}
+			prefixes = append(prefixes, prefix.Masked())
+		} else if addr, err := netip.ParseAddr(entry); err == nil {
+			addr = addr.Unmap()
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+		}
+	}
+	return prefixes
+}
+
+// hasSegmentPrefix reports whether prefix matches whole leading segments of path
+func hasSegmentPrefix(path, prefix string) bool {
+	return prefix == "" || path == prefix || strings.HasPrefix(path, prefix+"/")
+}
+
+// clientIP returns the IP of the client that sent the request. Each of the
+// trustedHops proxies in front of the gateway appends the address it received
+// the request from to X-Forwarded-For, so the client is the entry that many
+// hops back from the connection's own address; entries further left were
+// written by the client and may be forged. With no trusted hops the header is
+// ignored.
+func clientIP(r *http.Request, trustedHops int) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if trustedHops <= 0 {
+		return host
+	}
+
+	var hops []string
+	for _, forwarded := range r.Header.Values("X-Forwarded-For") {
+		for _, entry := range strings.Split(forwarded, ",") {
+			if entry = strings.TrimSpace(entry); entry != "" {
+				hops = append(hops, entry)
+			}
+		}
+	}
+	hops = append(hops, host)
+
+	index := len(hops) - 1 - trustedHops
+	if index < 0 {
+		// Fewer hops than proxies: the leftmost entry is the closest to the client
+		index = 0
+	}
+	return hops[index]
+}
@@ -0,0 +1,141 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/canxphung/DA_CNPM_242/api_gateway/internal/auth"
+	"github.com/canxphung/DA_CNPM_242/api_gateway/internal/config"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+func newTestIPFilter(trustedProxyHops int, rules ...config.IPFilterRule) *IPFilterMiddleware {
+	return NewIPFilterMiddleware(&config.IPFilterConfig{Rules: rules}, trustedProxyHops, zap.NewNop())
+}
+
+// serveForwarded sends a GET from remoteAddr with the given X-Forwarded-For
+func serveForwarded(handler http.Handler, remoteAddr, path, forwardedFor string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	req.RemoteAddr = remoteAddr
+	if forwardedFor != "" {
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestFilterIPsRanges(t *testing.T) {
+	filter := newTestIPFilter(0,
+		config.IPFilterRule{
+			PathPrefix: "/metrics",
+			Allow:      []string{"10.0.0.0/8", "192.0.2.7", "fd00::/8", "::ffff:198.51.100.0/120"},
+			Deny:       []string{"10.9.0.0/16", "fd00:bad::/32"},
+		},
+		config.IPFilterRule{PathPrefix: "/", Deny: []string{"203.0.113.0/24", "2001:db8:dead::/48"}},
+	)
+	handler := filter.FilterIPs(okHandler)
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		path       string
+		want       int
+	}{
+		{"IPv4 in allowed range", "10.1.2.3:1000", "/metrics", http.StatusOK},
+		{"allowed single IP", "192.0.2.7:1000", "/metrics", http.StatusOK},
+		{"IPv4 outside the allow list", "192.0.2.8:1000", "/metrics", http.StatusForbidden},
+		{"deny inside the allow list", "10.9.1.1:1000", "/metrics", http.StatusForbidden},
+		{"IPv6 in allowed range", "[fd00:1::1]:1000", "/metrics", http.StatusOK},
+		{"IPv6 outside the allow list", "[2001:db8::1]:1000", "/metrics", http.StatusForbidden},
+		{"IPv6 deny inside the allow list", "[fd00:bad::1]:1000", "/metrics", http.StatusForbidden},
+		{"IPv4-mapped range matches IPv4", "198.51.100.9:1000", "/metrics", http.StatusOK},
+		{"IPv4-mapped client matches IPv4 range", "[::ffff:10.1.2.3]:1000", "/metrics", http.StatusOK},
+		{"below the prefix", "192.0.2.8:1000", "/metrics/extra", http.StatusForbidden},
+		{"similar path is not covered", "192.0.2.8:1000", "/metricsx", http.StatusOK},
+		{"IPv4 denied everywhere", "203.0.113.5:1000", "/api/v1/status", http.StatusForbidden},
+		{"IPv6 denied everywhere", "[2001:db8:dead::1]:1000", "/api/v1/status", http.StatusForbidden},
+		{"other clients pass", "192.0.2.8:1000", "/api/v1/status", http.StatusOK},
+		{"unparsable address fails an allow list", "unknown", "/metrics", http.StatusForbidden},
+		{"unparsable address fails a deny list", "unknown", "/api/v1/status", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rec := serveForwarded(handler, tt.remoteAddr, tt.path, ""); rec.Code != tt.want {
+				t.Errorf("status %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestFilterIPsBeforeAuth(t *testing.T) {
+	manager, err := auth.NewJWTManager(&config.JWTConfig{SecretKey: "secret", ExpirationMinutes: 60}, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	authMiddleware := auth.NewAuthMiddleware(manager, &config.AuthConfig{}, &config.ServicesConfig{},
+		auth.NewMemoryRevocationStore(prometheus.NewRegistry()), zap.NewNop())
+	filter := newTestIPFilter(0, config.IPFilterRule{PathPrefix: "/api/v1/core-operation", Allow: []string{"10.0.0.0/8"}})
+
+	// The filter sits in front of auth, as registered in main
+	handler := filter.FilterIPs(authMiddleware.Authenticate(okHandler))
+
+	rec := serveForwarded(handler, "192.0.2.1:1000", "/api/v1/core-operation/plants", "")
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("refused client: status %d, want 403", rec.Code)
+	}
+	var body map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Errorf("403 body is not JSON: %q", rec.Body.String())
+	}
+	if rec := serveForwarded(handler, "10.0.0.1:1000", "/api/v1/core-operation/plants", ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("allowed client without a token: status %d, want 401 from auth", rec.Code)
+	}
+}
+
+func TestFilterIPsForwardedFor(t *testing.T) {
+	rule := config.IPFilterRule{PathPrefix: "/", Allow: []string{"203.0.113.0/24"}}
+
+	tests := []struct {
+		name         string
+		hops         int
+		remoteAddr   string
+		forwardedFor string
+		want         int
+	}{
+		{"no trusted hops ignores the header", 0, "198.51.100.1:1000", "203.0.113.5", http.StatusForbidden},
+		{"client behind one trusted proxy", 1, "10.0.0.1:1000", "203.0.113.5", http.StatusOK},
+		{"refused client behind one trusted proxy", 1, "10.0.0.1:1000", "198.51.100.1", http.StatusForbidden},
+		{"spoofed leftmost entry", 1, "10.0.0.1:1000", "203.0.113.5, 198.51.100.1", http.StatusForbidden},
+		{"spoofed allowed entry is ignored", 1, "10.0.0.1:1000", "198.51.100.1, 203.0.113.5", http.StatusOK},
+		{"two trusted proxies", 2, "10.0.0.2:1000", "198.51.100.1, 203.0.113.5, 10.0.0.1", http.StatusOK},
+		{"spoofed entry behind two proxies", 2, "10.0.0.2:1000", "203.0.113.5, 198.51.100.1, 10.0.0.1", http.StatusForbidden},
+		{"fewer entries than hops", 3, "10.0.0.2:1000", "203.0.113.5", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := newTestIPFilter(tt.hops, rule).FilterIPs(okHandler)
+			if rec := serveForwarded(handler, tt.remoteAddr, "/api/v1/status", tt.forwardedFor); rec.Code != tt.want {
+				t.Errorf("status %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestFilterIPsSplitForwardedForHeaders(t *testing.T) {
+	handler := newTestIPFilter(1, config.IPFilterRule{PathPrefix: "/", Deny: []string{"198.51.100.0/24"}}).FilterIPs(okHandler)
+
+	// Entries split across several header lines are read as one list
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/status", nil)
+	req.RemoteAddr = "10.0.0.1:1000"
+	req.Header.Add("X-Forwarded-For", "203.0.113.5")
+	req.Header.Add("X-Forwarded-For", "198.51.100.1")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("status %d, want 403 for the last forwarded entry", rec.Code)
+	}
+}
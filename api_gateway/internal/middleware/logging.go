@@ -2,6 +2,7 @@ package middleware
 
 import (
 	"bufio"
+	"context"
 	"fmt"
 	"net"
 	"net/http"
@@ -23,11 +24,50 @@ func NewLoggingMiddleware(logger *zap.Logger) *LoggingMiddleware {
 	}
 }
 
-// LogRequest logs information about incoming requests and their responses
+// RequestIDHeader carries the request ID between clients, the gateway and backends
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength bounds incoming request IDs accepted for reuse
+const maxRequestIDLength = 128
+
+type requestIDContextKey struct{}
+
+// RequestIDFromContext returns the request ID assigned by LogRequest, or ""
+func RequestIDFromContext(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDContextKey{}).(string)
+	return requestID
+}
+
+// validRequestID reports whether an incoming request ID is safe to reuse in
+// headers and logs: 1-128 letters, digits or "-_.:" characters
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// LogRequest logs information about incoming requests and their responses.
+// It reuses a valid X-Request-ID sent by the client or an upstream proxy and
+// generates one otherwise, then sets it on the request (so it is forwarded to
+// backends), its context and the response.
 func (m *LoggingMiddleware) LogRequest(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
-		requestID := uuid.New().String()
+		requestID := r.Header.Get(RequestIDHeader)
+		if !validRequestID(requestID) {
+			requestID = uuid.New().String()
+		}
+		r.Header.Set(RequestIDHeader, requestID)
+		r = r.WithContext(context.WithValue(r.Context(), requestIDContextKey{}, requestID))
 
 		// Create a custom response writer to capture status code
 		responseWriter := &responseWriter{
@@ -35,7 +75,7 @@ func (m *LoggingMiddleware) LogRequest(next http.Handler) http.Handler {
 			status:         http.StatusOK,
 			written:        false,
 		}
-		responseWriter.Header().Set("X-Request-ID", requestID)
+		responseWriter.Header().Set(RequestIDHeader, requestID)
 
 		m.logger.Info("Request received",
 			zap.String("request_id", requestID),
@@ -60,6 +100,12 @@ func (m *LoggingMiddleware) LogRequest(next http.Handler) http.Handler {
 	})
 }
 
+// isInformational reports whether code is a non-final 1xx status.
+// 101 Switching Protocols is final: the connection is handed over afterwards.
+func isInformational(code int) bool {
+	return code >= 100 && code < http.StatusOK && code != http.StatusSwitchingProtocols
+}
+
 // Custom response writer to capture status code and ensure proper flushing
 type responseWriter struct {
 	http.ResponseWriter
@@ -68,6 +114,11 @@ type responseWriter struct {
 }
 
 func (rw *responseWriter) WriteHeader(code int) {
+	// Informational responses (e.g. 100 Continue) precede the final status
+	if isInformational(code) {
+		rw.ResponseWriter.WriteHeader(code)
+		return
+	}
 	if !rw.written {
 		rw.status = code
 		rw.written = true
@@ -94,6 +145,11 @@ func (rw *responseWriter) Flush() {
 	}
 }
 
+// Unwrap returns the underlying ResponseWriter for http.ResponseController
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
 // CloseNotify implements the http.CloseNotifier interface if the underlying ResponseWriter supports it
 func (rw *responseWriter) CloseNotify() <-chan bool {
 	if notifier, ok := rw.ResponseWriter.(http.CloseNotifier); ok {
@@ -0,0 +1,86 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestLogRequestRecordsFinalStatus(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []int
+		want     int
+	}{
+		{"interim 100 Continue", []int{http.StatusContinue, http.StatusCreated}, http.StatusCreated},
+		{"interim 103 Early Hints", []int{http.StatusEarlyHints, http.StatusOK}, http.StatusOK},
+		{"101 Switching Protocols is final", []int{http.StatusSwitchingProtocols}, http.StatusSwitchingProtocols},
+		{"first final status wins", []int{http.StatusNotFound, http.StatusOK}, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			core, logs := observer.New(zap.InfoLevel)
+			handler := NewLoggingMiddleware(zap.New(core)).LogRequest(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				for _, status := range tt.statuses {
+					w.WriteHeader(status)
+				}
+			}))
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/api/v1/core-operations/uploads", nil))
+
+			completed := logs.FilterMessage("Request completed").All()
+			if len(completed) != 1 {
+				t.Fatalf("%d completion logs, want 1", len(completed))
+			}
+			if got := completed[0].ContextMap()["status"]; got != int64(tt.want) {
+				t.Errorf("logged status %v, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLogRequestRequestID(t *testing.T) {
+	tests := []struct {
+		name     string
+		incoming string
+		reused   bool
+	}{
+		{"valid incoming ID", "edge-7f3a.42:1", true},
+		{"UUID from an upstream proxy", "3f1c9a4e-8b2d-4c6e-9f0a-1b2c3d4e5f60", true},
+		{"no incoming ID", "", false},
+		{"header injection", "abc\r\nSet-Cookie: x=1", false},
+		{"too long", strings.Repeat("a", maxRequestIDLength+1), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var headerID, contextID string
+			handler := NewLoggingMiddleware(zap.NewNop()).LogRequest(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				headerID = r.Header.Get(RequestIDHeader)
+				contextID = RequestIDFromContext(r.Context())
+			}))
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/core-operations/plants", nil)
+			if tt.incoming != "" {
+				req.Header.Set(RequestIDHeader, tt.incoming)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			responseID := rec.Header().Get(RequestIDHeader)
+			if tt.reused && responseID != tt.incoming {
+				t.Errorf("response ID %q, want the incoming %q", responseID, tt.incoming)
+			}
+			if !tt.reused {
+				if _, err := uuid.Parse(responseID); err != nil {
+					t.Errorf("response ID %q, want a generated UUID", responseID)
+				}
+			}
+			if headerID != responseID || contextID != responseID {
+				t.Errorf("request header %q, context %q, response %q: want one ID", headerID, contextID, responseID)
+			}
+		})
+	}
+}
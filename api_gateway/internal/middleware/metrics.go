@@ -2,37 +2,63 @@ package middleware
 
 import (
 	"bufio"
+	"context"
 	"fmt"
+	"io"
 	"net"
 	"net/http"
 	"strings"
 	"time"
 
+	"github.com/canxphung/DA_CNPM_242/api_gateway/internal/config"
+	"github.com/canxphung/DA_CNPM_242/api_gateway/internal/metrics"
+	"github.com/gorilla/mux"
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promauto"
 )
 
 // MetricsMiddleware collects metrics about requests
 type MetricsMiddleware struct {
 	requestCounter   *prometheus.CounterVec
 	requestDuration  *prometheus.HistogramVec
+	durationSummary  *prometheus.SummaryVec
+	summaryServices  map[string]bool
 	requestsInFlight *prometheus.GaugeVec
+	requestSize      *prometheus.HistogramVec
+	responseSize     *prometheus.HistogramVec
+	// servicePrefixes maps each gateway path prefix to its service ID
+	servicePrefixes servicePrefixes
+}
+
+type routedServiceContextKey struct{}
+
+// routedService receives the ID of the service a request was proxied to
+type routedService struct {
+	id string
+}
+
+// SetRoutedService records the service the request is routed to, for the
+// service label of the request metrics. Without it the service is derived
+// from the path and the configured service prefixes.
+func SetRoutedService(ctx context.Context, serviceID string) {
+	if routed, ok := ctx.Value(routedServiceContextKey{}).(*routedService); ok {
+		routed.id = serviceID
+	}
 }
 
 // NewMetricsMiddleware creates a new metrics middleware
-func NewMetricsMiddleware(reg prometheus.Registerer) *MetricsMiddleware {
+func NewMetricsMiddleware(reg prometheus.Registerer, cfg *config.MetricsConfig, services []config.ServiceDefinition) *MetricsMiddleware {
 	const namespace = "api_gateway"
 
-	requestCounter := promauto.With(reg).NewCounterVec(
+	requestCounter := metrics.RegisterOrReuse(reg, prometheus.NewCounterVec(
 		prometheus.CounterOpts{
 			Namespace: namespace,
 			Name:      "requests_total",
 			Help:      "Total number of requests by method, path, and status",
 		},
 		[]string{"method", "path", "service", "status"},
-	)
+	))
 
-	requestDuration := promauto.With(reg).NewHistogramVec(
+	requestDuration := metrics.RegisterOrReuse(reg, prometheus.NewHistogramVec(
 		prometheus.HistogramOpts{
 			Namespace: namespace,
 			Name:      "request_duration_seconds",
@@ -40,38 +66,98 @@ func NewMetricsMiddleware(reg prometheus.Registerer) *MetricsMiddleware {
 			Buckets:   prometheus.DefBuckets,
 		},
 		[]string{"method", "path", "service"},
-	)
+	))
+
+	// Per-service latency percentiles for SLO tracking; the histogram above
+	// stays the aggregatable view
+	objectives := make(map[float64]float64, len(cfg.SummaryQuantiles))
+	for _, quantile := range cfg.SummaryQuantiles {
+		objectives[quantile] = (1 - quantile) / 10
+	}
+	durationSummary := metrics.RegisterOrReuse(reg, prometheus.NewSummaryVec(
+		prometheus.SummaryOpts{
+			Namespace:  namespace,
+			Name:       "request_duration_summary_seconds",
+			Help:       "Summary of request durations in seconds by service",
+			Objectives: objectives,
+		},
+		[]string{"service"},
+	))
+
+	var summaryServices map[string]bool
+	if len(cfg.SummaryServices) > 0 {
+		summaryServices = make(map[string]bool, len(cfg.SummaryServices))
+		for _, service := range cfg.SummaryServices {
+			summaryServices[service] = true
+		}
+	}
 
-	requestsInFlight := promauto.With(reg).NewGaugeVec(
+	requestsInFlight := metrics.RegisterOrReuse(reg, prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
 			Namespace: namespace,
 			Name:      "requests_in_flight",
 			Help:      "Current number of requests being processed",
 		},
 		[]string{"method", "path"},
-	)
+	))
+
+	// Payload sizes from 100 B to 100 MB
+	sizeBuckets := prometheus.ExponentialBuckets(100, 10, 7)
+
+	requestSize := metrics.RegisterOrReuse(reg, prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "request_size_bytes",
+			Help:      "Size of request bodies in bytes by service",
+			Buckets:   sizeBuckets,
+		},
+		[]string{"service"},
+	))
+
+	responseSize := metrics.RegisterOrReuse(reg, prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "response_size_bytes",
+			Help:      "Size of response bodies in bytes by service",
+			Buckets:   sizeBuckets,
+		},
+		[]string{"service"},
+	))
 
 	return &MetricsMiddleware{
 		requestCounter:   requestCounter,
 		requestDuration:  requestDuration,
+		durationSummary:  durationSummary,
+		summaryServices:  summaryServices,
 		requestsInFlight: requestsInFlight,
+		requestSize:      requestSize,
+		responseSize:     responseSize,
+		servicePrefixes:  newServicePrefixes(services),
 	}
 }
 
 // CollectMetrics collects metrics for requests
 func (m *MetricsMiddleware) CollectMetrics(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		path := r.URL.Path
+		path := pathLabel(r)
 		method := r.Method
 
-		// Determine service based on path with improved detection
-		service := m.detectService(path)
+		// The proxy fills in the service it routed to
+		routed := &routedService{}
+		r = r.WithContext(context.WithValue(r.Context(), routedServiceContextKey{}, routed))
 
 		// Track in-flight requests
 		m.requestsInFlight.WithLabelValues(method, path).Inc()
 		defer m.requestsInFlight.WithLabelValues(method, path).Dec()
 
-		// Create a custom response writer to capture status code
+		// Chunked bodies declare no length, so count what is read of them
+		var body *countingReader
+		if r.ContentLength < 0 && r.Body != nil && r.Body != http.NoBody {
+			body = &countingReader{ReadCloser: r.Body}
+			r.Body = body
+		}
+
+		// Create a custom response writer to capture status code and body size
 		respWriter := &metricsResponseWriter{
 			ResponseWriter: w,
 			status:         http.StatusOK,
@@ -83,38 +169,68 @@ func (m *MetricsMiddleware) CollectMetrics(next http.Handler) http.Handler {
 		next.ServeHTTP(respWriter, r)
 		duration := time.Since(start).Seconds()
 
+		// Fall back to the path for requests rejected before the proxy and
+		// for those the gateway answered itself
+		service := routed.id
+		if service == "" {
+			service = m.detectService(r.URL.Path)
+		}
+
 		// Record request count and duration
 		status := http.StatusText(respWriter.status)
 		m.requestCounter.WithLabelValues(method, path, service, status).Inc()
 		m.requestDuration.WithLabelValues(method, path, service).Observe(duration)
+		if m.summaryServices == nil || m.summaryServices[service] {
+			m.durationSummary.WithLabelValues(service).Observe(duration)
+		}
+
+		requestBytes := r.ContentLength
+		if body != nil {
+			requestBytes = body.n
+		}
+		m.requestSize.WithLabelValues(service).Observe(float64(requestBytes))
+		m.responseSize.WithLabelValues(service).Observe(float64(respWriter.bytes))
 	})
 }
 
-// detectService determines which service the request is for based on the path
-func (m *MetricsMiddleware) detectService(path string) string {
-	// Handle gateway endpoints
-	if path == "/" || path == "/health" || path == "/metrics" {
-		return "gateway"
-	}
+// countingReader counts the bytes read from a request body
+type countingReader struct {
+	io.ReadCloser
+	n int64
+}
 
-	// Handle API v1 endpoints
-	if strings.HasPrefix(path, "/api/v1/") {
-		pathSegments := strings.Split(strings.TrimPrefix(path, "/api/v1/"), "/")
-		if len(pathSegments) > 0 {
-			switch pathSegments[0] {
-			case "user-auth":
-				return "user-auth"
-			case "core-operation", "core-operations":
-				return "core-operation"
-			case "greenhouse-ai":
-				return "greenhouse-ai"
-			case "health":
-				return "gateway"
-			}
-		}
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.ReadCloser.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
+
+// pathLabel returns the path label for a request: the template of the mux
+// route it matched, so that the label takes one value per route. Paths below
+// a prefix route (the service proxies) get the prefix followed by {rest};
+// requests that matched no route are "unmatched".
+func pathLabel(r *http.Request) string {
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return "unmatched"
+	}
+	template, err := route.GetPathTemplate()
+	if err != nil {
+		return "unmatched"
+	}
+	if !strings.Contains(template, "{") && r.URL.Path != template && strings.HasPrefix(r.URL.Path, template) {
+		return strings.TrimSuffix(template, "/") + "/{rest}"
 	}
+	return template
+}
 
-	return "unknown"
+// detectService determines which service the request is for from the
+// configured service prefixes; paths outside them are the gateway's own
+func (m *MetricsMiddleware) detectService(path string) string {
+	if service := m.servicePrefixes.serviceFor(path); service != "" {
+		return service
+	}
+	return "gateway"
 }
 
 // Custom response writer for metrics
@@ -122,12 +238,19 @@ type metricsResponseWriter struct {
 	http.ResponseWriter
 	status  int
 	written bool
+	bytes   int64
 }
 
 // WriteHeader captures the status code for metrics
 func (mrw *metricsResponseWriter) WriteHeader(code int) {
+	// Informational responses are passed on without being recorded
+	if isInformational(code) {
+		mrw.ResponseWriter.WriteHeader(code)
+		return
+	}
 	if !mrw.written {
 		mrw.status = code
+		mrw.written = true
 		mrw.ResponseWriter.WriteHeader(code)
 	}
 }
@@ -142,7 +265,9 @@ func (mrw *metricsResponseWriter) Write(data []byte) (int, error) {
 		}
 		mrw.ResponseWriter.WriteHeader(mrw.status)
 	}
-	return mrw.ResponseWriter.Write(data)
+	n, err := mrw.ResponseWriter.Write(data)
+	mrw.bytes += int64(n)
+	return n, err
 }
 
 // Flush implements the http.Flusher interface if the underlying ResponseWriter supports it
@@ -152,6 +277,11 @@ func (mrw *metricsResponseWriter) Flush() {
 	}
 }
 
+// Unwrap returns the underlying ResponseWriter for http.ResponseController
+func (mrw *metricsResponseWriter) Unwrap() http.ResponseWriter {
+	return mrw.ResponseWriter
+}
+
 // CloseNotify implements the http.CloseNotifier interface if the underlying ResponseWriter supports it
 func (mrw *metricsResponseWriter) CloseNotify() <-chan bool {
 	if notifier, ok := mrw.ResponseWriter.(http.CloseNotifier); ok {
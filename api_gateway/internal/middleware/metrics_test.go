@@ -0,0 +1,299 @@
+package middleware
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/canxphung/DA_CNPM_242/api_gateway/internal/config"
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"go.uber.org/zap"
+)
+
+// metricLabels returns the label sets of a gathered metric family
+func metricLabels(t *testing.T, reg *prometheus.Registry, name string) []map[string]string {
+	t.Helper()
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("Gather: %v", err)
+	}
+	var labels []map[string]string
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			labels = append(labels, labelMap(metric))
+		}
+	}
+	return labels
+}
+
+func labelMap(metric *dto.Metric) map[string]string {
+	labels := map[string]string{}
+	for _, pair := range metric.GetLabel() {
+		labels[pair.GetName()] = pair.GetValue()
+	}
+	return labels
+}
+
+// newMetricsRouter mirrors main: gateway routes on the router and service
+// prefixes on the /api/v1 subrouter
+func newMetricsRouter(reg *prometheus.Registry) *mux.Router {
+	router := mux.NewRouter()
+	router.Use(NewMetricsMiddleware(reg, &config.MetricsConfig{}, nil).CollectMetrics)
+	router.Handle("/health", okHandler)
+	router.Handle("/sensors/{id}", okHandler)
+	apiV1 := router.PathPrefix("/api/v1").Subrouter()
+	apiV1.PathPrefix("/user-auth/").Handler(okHandler)
+	return router
+}
+
+func TestPathLabelUsesRouteTemplate(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	router := newMetricsRouter(reg)
+
+	for _, path := range []string{"/api/v1/user-auth/users/1", "/api/v1/user-auth/users/2", "/sensors/a", "/sensors/b", "/health"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	got := map[string]bool{}
+	for _, labels := range metricLabels(t, reg, "api_gateway_requests_total") {
+		got[labels["path"]] = true
+	}
+	want := map[string]bool{"/api/v1/user-auth/{rest}": true, "/sensors/{id}": true, "/health": true}
+	if len(got) != len(want) {
+		t.Fatalf("path labels = %v, want %v", got, want)
+	}
+	for path := range want {
+		if !got[path] {
+			t.Errorf("missing path label %q in %v", path, got)
+		}
+	}
+}
+
+func TestPathLabelCardinalityIsBounded(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	router := newMetricsRouter(reg)
+
+	for i := 0; i < 500; i++ {
+		slug := fmt.Sprintf("greenhouse-%d-zone-%c", i, 'a'+i%26)
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/v1/user-auth/devices/"+slug, nil))
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/sensors/"+slug, nil))
+	}
+
+	if series := len(metricLabels(t, reg, "api_gateway_requests_total")); series != 2 {
+		t.Errorf("requests_total has %d series for 1000 distinct paths, want 2", series)
+	}
+	if series := len(metricLabels(t, reg, "api_gateway_requests_in_flight")); series != 2 {
+		t.Errorf("requests_in_flight has %d series, want 2", series)
+	}
+}
+
+func TestPathLabelUnmatched(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	handler := NewMetricsMiddleware(reg, &config.MetricsConfig{}, nil).CollectMetrics(http.NotFoundHandler())
+
+	for i := 0; i < 10; i++ {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, fmt.Sprintf("/scan/%d", i), nil))
+	}
+
+	labels := metricLabels(t, reg, "api_gateway_requests_total")
+	if len(labels) != 1 || labels[0]["path"] != "unmatched" {
+		t.Errorf("labels = %v, want a single unmatched series", labels)
+	}
+}
+
+func TestServiceLabelFromConfiguredServices(t *testing.T) {
+	services := []config.ServiceDefinition{
+		{ID: "irrigation", Prefixes: []string{"/irrigation", "/water/v2"}},
+		{ID: "core-operations", Prefixes: []string{"/core-operations", "/core-operation"}},
+	}
+	// Rejected before reaching a proxy, as auth failures are
+	rejected := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	})
+	// A proxy reporting the service it routed to
+	proxied := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		SetRoutedService(r.Context(), "irrigation-canary")
+	})
+
+	tests := []struct {
+		name    string
+		handler http.Handler
+		path    string
+		want    string
+	}{
+		{"below prefix", rejected, "/api/v1/irrigation/valves/3", "irrigation"},
+		{"exact prefix", rejected, "/api/v1/irrigation", "irrigation"},
+		{"two-level prefix", rejected, "/api/v1/water/v2/schedules", "irrigation"},
+		{"alias prefix", rejected, "/api/v1/core-operation/plants", "core-operations"},
+		{"similar name", rejected, "/api/v1/irrigationx/valves", "gateway"},
+		{"gateway endpoint", rejected, "/health", "gateway"},
+		{"routed by proxy", proxied, "/api/v1/irrigation/valves/3", "irrigation-canary"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reg := prometheus.NewRegistry()
+			handler := NewMetricsMiddleware(reg, &config.MetricsConfig{}, services).CollectMetrics(tt.handler)
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+			labels := metricLabels(t, reg, "api_gateway_requests_total")
+			if len(labels) != 1 || labels[0]["service"] != tt.want {
+				t.Errorf("labels = %v, want service %q", labels, tt.want)
+			}
+		})
+	}
+}
+
+// summaryQuantiles returns the quantiles exported by the latency summary, by service
+func summaryQuantiles(t *testing.T, reg *prometheus.Registry) map[string][]float64 {
+	t.Helper()
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("Gather: %v", err)
+	}
+	quantiles := map[string][]float64{}
+	for _, family := range families {
+		if family.GetName() != "api_gateway_request_duration_summary_seconds" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			service := labelMap(metric)["service"]
+			quantiles[service] = []float64{}
+			for _, quantile := range metric.GetSummary().GetQuantile() {
+				quantiles[service] = append(quantiles[service], quantile.GetQuantile())
+			}
+		}
+	}
+	return quantiles
+}
+
+func TestDurationSummary(t *testing.T) {
+	services := []config.ServiceDefinition{
+		{ID: "core-operations", Prefixes: []string{"/core-operations"}},
+		{ID: "greenhouse-ai", Prefixes: []string{"/greenhouse-ai"}},
+	}
+	tests := []struct {
+		name string
+		cfg  config.MetricsConfig
+		want map[string][]float64
+	}{
+		{
+			name: "configured quantiles for every service",
+			cfg:  config.MetricsConfig{SummaryQuantiles: []float64{0.5, 0.99}},
+			want: map[string][]float64{"core-operations": {0.5, 0.99}, "greenhouse-ai": {0.5, 0.99}, "gateway": {0.5, 0.99}},
+		},
+		{
+			name: "limited to some services",
+			cfg:  config.MetricsConfig{SummaryQuantiles: []float64{0.9}, SummaryServices: []string{"greenhouse-ai"}},
+			want: map[string][]float64{"greenhouse-ai": {0.9}},
+		},
+		{
+			name: "no quantiles still counts requests",
+			cfg:  config.MetricsConfig{},
+			want: map[string][]float64{"core-operations": {}, "greenhouse-ai": {}, "gateway": {}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reg := prometheus.NewRegistry()
+			handler := NewMetricsMiddleware(reg, &tt.cfg, services).CollectMetrics(okHandler)
+			for _, path := range []string{"/api/v1/core-operations/plants", "/api/v1/greenhouse-ai/api/predict", "/health"} {
+				handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+			}
+
+			got := summaryQuantiles(t, reg)
+			if len(got) != len(tt.want) {
+				t.Fatalf("summary series = %v, want %v", got, tt.want)
+			}
+			for service, want := range tt.want {
+				if fmt.Sprint(got[service]) != fmt.Sprint(want) {
+					t.Errorf("%s quantiles = %v, want %v", service, got[service], want)
+				}
+			}
+		})
+	}
+}
+
+func TestMiddlewareConstructedTwiceSharesCollectors(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	// A config reload builds the middleware again against the same registry
+	for i := 0; i < 2; i++ {
+		newMetricsRouter(reg).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/health", nil))
+		overload := NewOverloadResponder(&config.OverloadConfig{})
+		NewConcurrencyMiddleware(&config.ConcurrencyConfig{MaxInFlight: 1}, overload, reg, zap.NewNop())
+		NewStreamLimitMiddleware(&config.StreamingConfig{MaxConnections: 1}, overload, reg, zap.NewNop())
+	}
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("Gather: %v", err)
+	}
+	for _, family := range families {
+		if family.GetName() != "api_gateway_requests_total" {
+			continue
+		}
+		if got := family.GetMetric()[0].GetCounter().GetValue(); got != 2 {
+			t.Errorf("requests_total = %v, want both instances counted in one series", got)
+		}
+		return
+	}
+	t.Error("requests_total not registered")
+}
+
+// histogramSum returns the count and sum of a single-series histogram
+func histogramSum(t *testing.T, reg *prometheus.Registry, name string) (uint64, float64) {
+	t.Helper()
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("Gather: %v", err)
+	}
+	for _, family := range families {
+		if family.GetName() == name {
+			histogram := family.GetMetric()[0].GetHistogram()
+			return histogram.GetSampleCount(), histogram.GetSampleSum()
+		}
+	}
+	return 0, 0
+}
+
+func TestBodySizeHistograms(t *testing.T) {
+	tests := []struct {
+		name        string
+		body        io.Reader
+		chunked     bool
+		wantRequest float64
+	}{
+		{"no body", nil, false, 0},
+		{"declared length", strings.NewReader(`{"moisture":41}`), false, 15},
+		{"chunked body", strings.NewReader(strings.Repeat("r", 300)), true, 300},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reg := prometheus.NewRegistry()
+			handler := NewMetricsMiddleware(reg, &config.MetricsConfig{}, nil).CollectMetrics(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_, _ = io.Copy(io.Discard, r.Body)
+				_, _ = w.Write([]byte("hello "))
+				_, _ = w.Write([]byte("greenhouse"))
+			}))
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/core-operations/readings", tt.body)
+			if tt.chunked {
+				req.ContentLength = -1
+			}
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+
+			if count, sum := histogramSum(t, reg, "api_gateway_request_size_bytes"); count != 1 || sum != tt.wantRequest {
+				t.Errorf("request_size_bytes: %d observations summing %v, want one of %v", count, sum, tt.wantRequest)
+			}
+			if count, sum := histogramSum(t, reg, "api_gateway_response_size_bytes"); count != 1 || sum != 16 {
+				t.Errorf("response_size_bytes: %d observations summing %v, want one of 16", count, sum)
+			}
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package middleware
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/canxphung/DA_CNPM_242/api_gateway/internal/config"
+)
+
+// OverloadReason identifies the load-protection mechanism that rejected a request
+type OverloadReason string
+
+const (
+	// OverloadRateLimited is used when a client exceeded its request rate
+	OverloadRateLimited OverloadReason = "rate_limited"
+	// OverloadConcurrency is used when no in-flight request slot became free
+	OverloadConcurrency OverloadReason = "concurrency_limit"
+	// OverloadStreams is used when a streaming connection cap was reached
+	OverloadStreams OverloadReason = "stream_limit"
+	// OverloadBackendDown is used when the health checker has marked the backend down
+	OverloadBackendDown OverloadReason = "backend_down"
+)
+
+// OverloadResponse is the JSON body of every load-protection rejection
+type OverloadResponse struct {
+	Error      string         `json:"error"`
+	Reason     OverloadReason `json:"reason"`
+	RetryAfter int            `json:"retry_after"`
+}
+
+// OverloadResponder writes the rejection shared by all load-protection
+// features, so clients get the same status, body and Retry-After semantics
+// whichever mechanism turned them away.
+type OverloadResponder struct {
+	rateLimitStatus   int
+	capacityStatus    int
+	defaultRetryAfter time.Duration
+}
+
+// NewOverloadResponder creates a new overload responder
+func NewOverloadResponder(cfg *config.OverloadConfig) *OverloadResponder {
+	return &OverloadResponder{
+		rateLimitStatus:   cfg.RateLimitStatus,
+		capacityStatus:    cfg.CapacityStatus,
+		defaultRetryAfter: cfg.RetryAfter,
+	}
+}
+
+// Reject writes the overload response. retryAfter is the backoff derived from
+// the triggering mechanism; zero falls back to the configured default.
+func (o *OverloadResponder) Reject(w http.ResponseWriter, reason OverloadReason, message string, retryAfter time.Duration) {
+	if retryAfter <= 0 {
+		retryAfter = o.defaultRetryAfter
+	}
+	// Retry-After has whole-second resolution; round up so clients never retry early
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+
+	status := o.capacityStatus
+	if reason == OverloadRateLimited {
+		status = o.rateLimitStatus
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(OverloadResponse{
+		Error:      message,
+		Reason:     reason,
+		RetryAfter: seconds,
+	})
+}
@@ -0,0 +1,53 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/canxphung/DA_CNPM_242/api_gateway/internal/config"
+)
+
+func TestOverloadReject(t *testing.T) {
+	tests := []struct {
+		name       string
+		cfg        config.OverloadConfig
+		reason     OverloadReason
+		retryAfter time.Duration
+		wantStatus int
+		wantRetry  int
+	}{
+		{"rate limited", config.OverloadConfig{RateLimitStatus: 429, CapacityStatus: 503, RetryAfter: 5 * time.Second}, OverloadRateLimited, 2 * time.Second, 429, 2},
+		{"concurrency at capacity", config.OverloadConfig{RateLimitStatus: 429, CapacityStatus: 503, RetryAfter: 5 * time.Second}, OverloadConcurrency, time.Second, 503, 1},
+		{"stream cap", config.OverloadConfig{RateLimitStatus: 429, CapacityStatus: 503, RetryAfter: 5 * time.Second}, OverloadStreams, 0, 503, 5},
+		{"configured capacity status", config.OverloadConfig{RateLimitStatus: 429, CapacityStatus: 429, RetryAfter: time.Second}, OverloadConcurrency, 0, 429, 1},
+		{"backoff rounded up", config.OverloadConfig{RateLimitStatus: 429, CapacityStatus: 503}, OverloadRateLimited, 1200 * time.Millisecond, 429, 2},
+		{"sub-second backoff", config.OverloadConfig{RateLimitStatus: 429, CapacityStatus: 503}, OverloadConcurrency, 20 * time.Millisecond, 503, 1},
+		{"no backoff and no default", config.OverloadConfig{RateLimitStatus: 429, CapacityStatus: 503}, OverloadStreams, 0, 503, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			NewOverloadResponder(&tt.cfg).Reject(rec, tt.reason, "Slow down", tt.retryAfter)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if got := rec.Header().Get("Retry-After"); got != strconv.Itoa(tt.wantRetry) {
+				t.Errorf("Retry-After = %q, want %d", got, tt.wantRetry)
+			}
+			if rec.Header().Get("Content-Type") != "application/json" {
+				t.Errorf("Content-Type = %q, want application/json", rec.Header().Get("Content-Type"))
+			}
+			var body OverloadResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatalf("decode %q: %v", rec.Body.String(), err)
+			}
+			if body != (OverloadResponse{Error: "Slow down", Reason: tt.reason, RetryAfter: tt.wantRetry}) {
+				t.Errorf("body %+v", body)
+			}
+		})
+	}
+}
@@ -0,0 +1,199 @@
+package middleware
+
+import (
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/canxphung/DA_CNPM_242/api_gateway/internal/auth"
+	"github.com/canxphung/DA_CNPM_242/api_gateway/internal/config"
+	"github.com/canxphung/DA_CNPM_242/api_gateway/internal/metrics"
+	"github.com/canxphung/DA_CNPM_242/api_gateway/internal/store"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// RateLimitMiddleware limits each client with a token bucket: every request
+// per client IP and, in addition, authenticated requests per user ID and
+// each service's reads and writes per client
+type RateLimitMiddleware struct {
+	ip   rateClass
+	user rateClass
+	// methods holds the read and write classes of each service with a method limit
+	methods         map[string]methodClasses
+	servicePrefixes servicePrefixes
+	buckets         *store.Store[*tokenBucket]
+	limited         *prometheus.CounterVec
+	overload        *OverloadResponder
+	// trustedProxyHops is the number of trusted X-Forwarded-For hops (see clientIP)
+	trustedProxyHops int
+	logger           *zap.Logger
+}
+
+// rateClass is the bucket size and refill rate for one kind of client
+type rateClass struct {
+	name  string
+	rps   float64
+	burst float64
+	// idleTTL is how long an untouched bucket is kept: after burst/rps it is
+	// full again, so dropping it then loses nothing
+	idleTTL time.Duration
+}
+
+// methodClasses are a service's limits for reads and for writes
+type methodClasses struct {
+	read  rateClass
+	write rateClass
+}
+
+func newRateClass(name string, rps float64, burst int) rateClass {
+	class := rateClass{name: name, rps: rps, burst: float64(burst)}
+	if rps > 0 {
+		class.idleTTL = time.Duration(float64(burst) / rps * float64(time.Second))
+	}
+	return class
+}
+
+// NewRateLimitMiddleware creates a new rate limit middleware
+func NewRateLimitMiddleware(cfg *config.RateLimitConfig, services []config.ServiceDefinition, trustedProxyHops int, overload *OverloadResponder, reg prometheus.Registerer, logger *zap.Logger) *RateLimitMiddleware {
+	limited := metrics.RegisterOrReuse(reg, prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "api_gateway",
+			Name:      "rate_limited_total",
+			Help:      "Total number of requests rejected by the per-client rate limit",
+		},
+		[]string{"client"},
+	))
+
+	methods := make(map[string]methodClasses)
+	for _, service := range services {
+		reads, writes := cfg.Reads, cfg.Writes
+		if limits, ok := cfg.Services[service.ID]; ok {
+			reads, writes = limits.Reads, limits.Writes
+		}
+		if reads.RPS > 0 || writes.RPS > 0 {
+			methods[service.ID] = methodClasses{
+				read:  newRateClass("read", reads.RPS, reads.Burst),
+				write: newRateClass("write", writes.RPS, writes.Burst),
+			}
+		}
+	}
+
+	return &RateLimitMiddleware{
+		ip:               newRateClass("ip", cfg.RPS, cfg.Burst),
+		user:             newRateClass("user", cfg.UserRPS, cfg.UserBurst),
+		methods:          methods,
+		servicePrefixes:  newServicePrefixes(services),
+		buckets:          store.New[*tokenBucket]("rate_limit", cfg.MaxClients, 0, reg),
+		limited:          limited,
+		overload:         overload,
+		trustedProxyHops: trustedProxyHops,
+		logger:           logger,
+	}
+}
+
+// LimitByIP rejects requests from client IPs that have used up their bucket.
+// It runs ahead of authentication, so floods of unauthenticated requests are
+// limited too, and covers every route.
+func (m *RateLimitMiddleware) LimitByIP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m.limit(w, r, next, m.ip, "ip:"+clientIP(r, m.trustedProxyHops))
+	})
+}
+
+// LimitByUser rejects requests from users that have used up their bucket.
+// It must run after authentication; requests without a user pass through.
+func (m *RateLimitMiddleware) LimitByUser(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := auth.GetUserFromContext(r.Context())
+		if user == nil || user.ID == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		m.limit(w, r, next, m.user, "user:"+user.ID)
+	})
+}
+
+// LimitByMethod rejects a client's requests to a service once its reads or,
+// separately, its writes have used up their bucket, so heavy writes do not
+// eat into the read budget. Clients are users when authenticated and IPs
+// otherwise, so it must run after authentication.
+func (m *RateLimitMiddleware) LimitByMethod(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		serviceID := m.servicePrefixes.serviceFor(r.URL.Path)
+		classes, ok := m.methods[serviceID]
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		class := classes.write
+		if isReadMethod(r.Method) {
+			class = classes.read
+		}
+		client := "ip:" + clientIP(r, m.trustedProxyHops)
+		if user := auth.GetUserFromContext(r.Context()); user != nil && user.ID != "" {
+			client = "user:" + user.ID
+		}
+		m.limit(w, r, next, class, class.name+":"+serviceID+":"+client)
+	})
+}
+
+// isReadMethod reports whether the method only reads
+func isReadMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// limit takes a token from key's bucket and passes the request on, or
+// answers the overload response advertising in Retry-After when the next
+// token becomes available
+func (m *RateLimitMiddleware) limit(w http.ResponseWriter, r *http.Request, next http.Handler, class rateClass, key string) {
+	// A class with no rate configured is unlimited
+	if class.rps <= 0 {
+		next.ServeHTTP(w, r)
+		return
+	}
+
+	bucket, _ := m.buckets.GetOrSet(key, &tokenBucket{tokens: class.burst, last: time.Now()})
+	allowed, retryAfter := bucket.take(class.rps, class.burst)
+	// Refresh the idle expiry
+	m.buckets.SetWithTTL(key, bucket, class.idleTTL)
+
+	if !allowed {
+		m.limited.WithLabelValues(class.name).Inc()
+		m.logger.Warn("Rate limit exceeded",
+			zap.String("client", key),
+			zap.String("method", r.Method),
+			zap.String("path", r.URL.Path),
+			zap.Duration("retry_after", retryAfter))
+		m.overload.Reject(w, OverloadRateLimited, "Too many requests", retryAfter)
+		return
+	}
+
+	next.ServeHTTP(w, r)
+}
+
+// tokenBucket refills at rps tokens per second up to burst
+type tokenBucket struct {
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// take consumes a token if one is available; otherwise it returns how long
+// until the next token is due
+func (b *tokenBucket) take(rps, burst float64) (bool, time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	b.tokens = math.Min(burst, b.tokens+now.Sub(b.last).Seconds()*rps)
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / rps * float64(time.Second))
+}
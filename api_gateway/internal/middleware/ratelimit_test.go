@@ -0,0 +1,320 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/canxphung/DA_CNPM_242/api_gateway/internal/auth"
+	"github.com/canxphung/DA_CNPM_242/api_gateway/internal/config"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+func newTestOverloadResponder() *OverloadResponder {
+	return NewOverloadResponder(&config.OverloadConfig{
+		RateLimitStatus: http.StatusTooManyRequests,
+		CapacityStatus:  http.StatusServiceUnavailable,
+		RetryAfter:      time.Second,
+	})
+}
+
+func newTestRateLimiter(cfg config.RateLimitConfig) *RateLimitMiddleware {
+	if cfg.MaxClients == 0 {
+		cfg.MaxClients = 100
+	}
+	return NewRateLimitMiddleware(&cfg, nil, 0, newTestOverloadResponder(), prometheus.NewRegistry(), zap.NewNop())
+}
+
+var okHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+})
+
+// serveFrom sends a GET from remoteAddr through handler
+func serveFrom(handler http.Handler, remoteAddr, path, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	req.RemoteAddr = remoteAddr
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestLimitByIPBurstThen429(t *testing.T) {
+	limiter := newTestRateLimiter(config.RateLimitConfig{RPS: 1, Burst: 3})
+	handler := limiter.LimitByIP(okHandler)
+
+	for i := 0; i < 3; i++ {
+		if rec := serveFrom(handler, "192.0.2.1:1000", "/status", ""); rec.Code != http.StatusOK {
+			t.Fatalf("request %d: status %d, want 200", i, rec.Code)
+		}
+	}
+
+	rec := serveFrom(handler, "192.0.2.1:1000", "/status", "")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("over the burst: status %d, want 429", rec.Code)
+	}
+	retryAfter, err := strconv.Atoi(rec.Header().Get("Retry-After"))
+	if err != nil || retryAfter < 1 {
+		t.Errorf("Retry-After = %q, want a positive number of seconds", rec.Header().Get("Retry-After"))
+	}
+	if rec.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("Content-Type = %q", rec.Header().Get("Content-Type"))
+	}
+}
+
+func TestLimitByIPIsolatesClients(t *testing.T) {
+	limiter := newTestRateLimiter(config.RateLimitConfig{RPS: 1, Burst: 1})
+	handler := limiter.LimitByIP(okHandler)
+
+	serveFrom(handler, "192.0.2.1:1000", "/health", "")
+	if rec := serveFrom(handler, "192.0.2.1:2000", "/health", ""); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("same IP, other port: status %d, want 429", rec.Code)
+	}
+	if rec := serveFrom(handler, "192.0.2.2:1000", "/health", ""); rec.Code != http.StatusOK {
+		t.Errorf("other IP: status %d, want 200", rec.Code)
+	}
+}
+
+func TestTokenBucketRefill(t *testing.T) {
+	bucket := &tokenBucket{tokens: 1, last: time.Now()}
+
+	if allowed, _ := bucket.take(10, 1); !allowed {
+		t.Fatal("first take refused")
+	}
+	allowed, retryAfter := bucket.take(10, 1)
+	if allowed {
+		t.Fatal("take allowed with an empty bucket")
+	}
+	if retryAfter <= 0 || retryAfter > 100*time.Millisecond {
+		t.Errorf("retryAfter = %v, want up to one token interval (100ms)", retryAfter)
+	}
+
+	// A second at 10 rps refills far beyond the burst, which caps it
+	bucket.last = bucket.last.Add(-time.Second)
+	if allowed, _ := bucket.take(10, 1); !allowed {
+		t.Fatal("take refused after refill")
+	}
+	if allowed, _ := bucket.take(10, 1); allowed {
+		t.Error("refill exceeded the burst")
+	}
+}
+
+func TestUnauthenticatedBurstIsLimited(t *testing.T) {
+	manager, err := auth.NewJWTManager(&config.JWTConfig{SecretKey: "secret", ExpirationMinutes: 60}, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	authMiddleware := auth.NewAuthMiddleware(manager, &config.AuthConfig{}, &config.ServicesConfig{},
+		auth.NewMemoryRevocationStore(prometheus.NewRegistry()), zap.NewNop())
+	limiter := newTestRateLimiter(config.RateLimitConfig{RPS: 1, Burst: 5})
+
+	// The per-IP limit sits in front of auth, as registered in main
+	handler := limiter.LimitByIP(authMiddleware.Authenticate(okHandler))
+
+	codes := map[int]int{}
+	for i := 0; i < 20; i++ {
+		codes[serveFrom(handler, "198.51.100.7:1000", "/api/v1/core-operation/plants", "").Code]++
+	}
+	if codes[http.StatusUnauthorized] != 5 || codes[http.StatusTooManyRequests] != 15 {
+		t.Errorf("status counts = %v, want 5 x 401 then 15 x 429", codes)
+	}
+}
+
+func TestLimitByUserKeysByUserID(t *testing.T) {
+	manager, err := auth.NewJWTManager(&config.JWTConfig{SecretKey: "secret", ExpirationMinutes: 60, UserIDClaims: []string{"sub"}}, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	authMiddleware := auth.NewAuthMiddleware(manager, &config.AuthConfig{}, &config.ServicesConfig{},
+		auth.NewMemoryRevocationStore(prometheus.NewRegistry()), zap.NewNop())
+	limiter := newTestRateLimiter(config.RateLimitConfig{RPS: 100, Burst: 100, UserRPS: 1, UserBurst: 2})
+	handler := limiter.LimitByIP(authMiddleware.Authenticate(limiter.LimitByUser(okHandler)))
+
+	token := func(sub string) string {
+		signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+			"sub": sub, "role": "user", "exp": time.Now().Add(time.Hour).Unix(),
+		}).SignedString([]byte("secret"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed
+	}
+	alice, bob := token("alice"), token("bob")
+
+	for i := 0; i < 2; i++ {
+		if rec := serveFrom(handler, "192.0.2.1:1000", "/api/v1/core-operation/plants", alice); rec.Code != http.StatusOK {
+			t.Fatalf("alice request %d: status %d", i, rec.Code)
+		}
+	}
+	if rec := serveFrom(handler, "192.0.2.1:1000", "/api/v1/core-operation/plants", alice); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("alice over her burst: status %d, want 429", rec.Code)
+	}
+	// Same IP, other user: a separate bucket
+	if rec := serveFrom(handler, "192.0.2.1:1000", "/api/v1/core-operation/plants", bob); rec.Code != http.StatusOK {
+		t.Errorf("bob: status %d, want 200", rec.Code)
+	}
+}
+
+func TestLimitByIPUsesForwardedClient(t *testing.T) {
+	cfg := config.RateLimitConfig{RPS: 1, Burst: 1, MaxClients: 100}
+	// One trusted proxy in front of the gateway
+	limiter := NewRateLimitMiddleware(&cfg, nil, 1, newTestOverloadResponder(), prometheus.NewRegistry(), zap.NewNop())
+	handler := limiter.LimitByIP(okHandler)
+
+	serve := func(forwardedFor string) int {
+		req := httptest.NewRequest(http.MethodGet, "/health", nil)
+		req.RemoteAddr = "10.0.0.1:1000"
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if code := serve("203.0.113.5"); code != http.StatusOK {
+		t.Fatalf("first client: status %d", code)
+	}
+	// Clients behind the same proxy have their own buckets
+	if code := serve("203.0.113.6"); code != http.StatusOK {
+		t.Errorf("second client behind the proxy: status %d, want 200", code)
+	}
+	// A spoofed leftmost entry does not escape the client's bucket
+	if code := serve("198.51.100.1, 203.0.113.5"); code != http.StatusTooManyRequests {
+		t.Errorf("spoofed X-Forwarded-For: status %d, want 429", code)
+	}
+}
+
+// methodLimitServices are the services the read and write limit tests route to
+var methodLimitServices = []config.ServiceDefinition{
+	{ID: "core-operations", Prefixes: []string{"/core-operations"}},
+	{ID: "greenhouse-ai", Prefixes: []string{"/greenhouse-ai"}},
+}
+
+func newTestMethodLimiter(cfg config.RateLimitConfig) http.Handler {
+	cfg.MaxClients = 100
+	limiter := NewRateLimitMiddleware(&cfg, methodLimitServices, 0, newTestOverloadResponder(), prometheus.NewRegistry(), zap.NewNop())
+	return limiter.LimitByMethod(okHandler)
+}
+
+// sendMethod sends a request with method from remoteAddr through handler
+func sendMethod(handler http.Handler, method, remoteAddr, path string) int {
+	req := httptest.NewRequest(method, path, nil)
+	req.RemoteAddr = remoteAddr
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec.Code
+}
+
+func TestLimitByMethodSeparatesReadsAndWrites(t *testing.T) {
+	handler := newTestMethodLimiter(config.RateLimitConfig{
+		Reads:  config.MethodRateLimit{RPS: 1, Burst: 5},
+		Writes: config.MethodRateLimit{RPS: 1, Burst: 2},
+	})
+	const client, path = "192.0.2.1:1000", "/api/v1/core-operations/sensors"
+
+	// Writes use up their own budget
+	for i, method := range []string{http.MethodPost, http.MethodPut} {
+		if code := sendMethod(handler, method, client, path); code != http.StatusOK {
+			t.Fatalf("write %d: status %d, want 200", i, code)
+		}
+	}
+	for _, method := range []string{http.MethodPost, http.MethodPatch, http.MethodDelete} {
+		if code := sendMethod(handler, method, client, path); code != http.StatusTooManyRequests {
+			t.Errorf("%s over the write burst: status %d, want 429", method, code)
+		}
+	}
+
+	// ...and not the read budget
+	for i := 0; i < 5; i++ {
+		method := []string{http.MethodGet, http.MethodHead}[i%2]
+		if code := sendMethod(handler, method, client, path); code != http.StatusOK {
+			t.Fatalf("read %d after writes ran out: status %d, want 200", i, code)
+		}
+	}
+	if code := sendMethod(handler, http.MethodGet, client, path); code != http.StatusTooManyRequests {
+		t.Errorf("over the read burst: status %d, want 429", code)
+	}
+
+	// Other clients have their own budgets
+	if code := sendMethod(handler, http.MethodPost, "192.0.2.2:1000", path); code != http.StatusOK {
+		t.Errorf("other client's write: status %d, want 200", code)
+	}
+}
+
+func TestLimitByMethodPerService(t *testing.T) {
+	handler := newTestMethodLimiter(config.RateLimitConfig{
+		Writes: config.MethodRateLimit{RPS: 1, Burst: 1},
+		Services: map[string]config.ServiceRateLimit{
+			"greenhouse-ai": {Writes: config.MethodRateLimit{RPS: 1, Burst: 3}},
+		},
+	})
+	const client = "192.0.2.1:1000"
+
+	if code := sendMethod(handler, http.MethodPost, client, "/api/v1/core-operations/sensors"); code != http.StatusOK {
+		t.Fatalf("first core write: status %d", code)
+	}
+	if code := sendMethod(handler, http.MethodPost, client, "/api/v1/core-operations/sensors"); code != http.StatusTooManyRequests {
+		t.Errorf("second core write: status %d, want 429", code)
+	}
+	// Each service has its own buckets, and the override raises the AI burst
+	for i := 0; i < 3; i++ {
+		if code := sendMethod(handler, http.MethodPost, client, "/api/v1/greenhouse-ai/predict"); code != http.StatusOK {
+			t.Errorf("AI write %d: status %d, want 200", i, code)
+		}
+	}
+	// Reads are unlimited when no read rate is set
+	for i := 0; i < 20; i++ {
+		if code := sendMethod(handler, http.MethodGet, client, "/api/v1/core-operations/sensors"); code != http.StatusOK {
+			t.Fatalf("read %d: status %d, want unlimited", i, code)
+		}
+	}
+	// Paths outside the services are not limited
+	for i := 0; i < 3; i++ {
+		if code := sendMethod(handler, http.MethodPost, client, "/api/v1/unknown/thing"); code != http.StatusOK {
+			t.Errorf("unmatched write %d: status %d, want 200", i, code)
+		}
+	}
+}
+
+func TestLimitByMethodKeysByUser(t *testing.T) {
+	manager, err := auth.NewJWTManager(&config.JWTConfig{SecretKey: "secret", ExpirationMinutes: 60, UserIDClaims: []string{"sub"}}, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	authMiddleware := auth.NewAuthMiddleware(manager, &config.AuthConfig{}, &config.ServicesConfig{},
+		auth.NewMemoryRevocationStore(prometheus.NewRegistry()), zap.NewNop())
+	cfg := config.RateLimitConfig{MaxClients: 100, Writes: config.MethodRateLimit{RPS: 1, Burst: 1}}
+	limiter := NewRateLimitMiddleware(&cfg, methodLimitServices, 0, newTestOverloadResponder(), prometheus.NewRegistry(), zap.NewNop())
+	handler := authMiddleware.Authenticate(limiter.LimitByMethod(okHandler))
+
+	post := func(sub string) int {
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+			"sub": sub, "role": "user", "exp": time.Now().Add(time.Hour).Unix(),
+		}).SignedString([]byte("secret"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/core-operations/sensors", nil)
+		req.RemoteAddr = "192.0.2.1:1000"
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if code := post("alice"); code != http.StatusOK {
+		t.Fatalf("alice: status %d", code)
+	}
+	if code := post("alice"); code != http.StatusTooManyRequests {
+		t.Errorf("alice over her write burst: status %d, want 429", code)
+	}
+	// Same IP, other user
+	if code := post("bob"); code != http.StatusOK {
+		t.Errorf("bob: status %d, want 200", code)
+	}
+}
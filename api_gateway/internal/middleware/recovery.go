@@ -0,0 +1,124 @@
+package middleware
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/canxphung/DA_CNPM_242/api_gateway/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// RecoveryMiddleware turns a panic in a handler into a 500 response
+type RecoveryMiddleware struct {
+	panics prometheus.Counter
+	logger *zap.Logger
+}
+
+// NewRecoveryMiddleware creates a new panic recovery middleware
+func NewRecoveryMiddleware(reg prometheus.Registerer, logger *zap.Logger) *RecoveryMiddleware {
+	panics := metrics.RegisterOrReuse(reg, prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "api_gateway",
+			Name:      "recovered_panics_total",
+			Help:      "Total number of panics recovered from request handlers",
+		},
+	))
+
+	return &RecoveryMiddleware{
+		panics: panics,
+		logger: logger,
+	}
+}
+
+// Recover recovers panics from the rest of the chain, logs them with their
+// stack and request ID, and answers 500 with the request ID for correlation.
+// It must be the outermost middleware so that it wraps everything.
+func (m *RecoveryMiddleware) Recover(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rw := &recoveryResponseWriter{ResponseWriter: w}
+
+		defer func() {
+			recovered := recover()
+			if recovered == nil {
+				return
+			}
+			// http.ErrAbortHandler deliberately aborts the response (the
+			// reverse proxy uses it when a backend breaks off mid-body);
+			// net/http handles it silently
+			if recovered == http.ErrAbortHandler {
+				panic(recovered)
+			}
+
+			m.panics.Inc()
+			// Set by LogRequest, which runs inside this middleware
+			requestID := w.Header().Get(RequestIDHeader)
+			m.logger.Error("Panic recovered",
+				zap.String("request_id", requestID),
+				zap.String("method", r.Method),
+				zap.String("path", r.URL.Path),
+				zap.Any("panic", recovered),
+				zap.ByteString("stack", debug.Stack()),
+			)
+
+			if rw.wroteHeader {
+				// Part of the response is already out; abort the connection
+				// so the client does not take it for a complete one
+				panic(http.ErrAbortHandler)
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			_ = json.NewEncoder(w).Encode(map[string]string{
+				"error":      "Internal server error",
+				"request_id": requestID,
+			})
+		}()
+
+		next.ServeHTTP(rw, r)
+	})
+}
+
+// recoveryResponseWriter records whether the response has been started
+type recoveryResponseWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+}
+
+func (rw *recoveryResponseWriter) WriteHeader(code int) {
+	if !isInformational(code) {
+		rw.wroteHeader = true
+	}
+	rw.ResponseWriter.WriteHeader(code)
+}
+
+func (rw *recoveryResponseWriter) Write(data []byte) (int, error) {
+	rw.wroteHeader = true
+	return rw.ResponseWriter.Write(data)
+}
+
+// Flush implements the http.Flusher interface if the underlying ResponseWriter supports it
+func (rw *recoveryResponseWriter) Flush() {
+	if flusher, ok := rw.ResponseWriter.(http.Flusher); ok {
+		rw.wroteHeader = true
+		flusher.Flush()
+	}
+}
+
+// Unwrap returns the underlying ResponseWriter for http.ResponseController
+func (rw *recoveryResponseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+// Hijack implements the http.Hijacker interface if the underlying ResponseWriter supports it
+func (rw *recoveryResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if hijacker, ok := rw.ResponseWriter.(http.Hijacker); ok {
+		rw.wroteHeader = true
+		return hijacker.Hijack()
+	}
+	return nil, nil, fmt.Errorf("ResponseWriter does not support Hijack")
+}
@@ -0,0 +1,123 @@
+package middleware
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestRecoverAnswersJSON500(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	core, logs := observer.New(zap.ErrorLevel)
+	handler := NewRecoveryMiddleware(reg, zap.New(core)).Recover(NewLoggingMiddleware(zap.NewNop()).LogRequest(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/panic" {
+				panic("nil map")
+			}
+			w.WriteHeader(http.StatusOK)
+		})))
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/panic")
+	if err != nil {
+		t.Fatalf("Do: %v", err)
+	}
+	var body map[string]string
+	err = json.NewDecoder(resp.Body).Decode(&body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if resp.StatusCode != http.StatusInternalServerError || resp.Header.Get("Content-Type") != "application/json" {
+		t.Errorf("status %d Content-Type %q, want a JSON 500", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	requestID := resp.Header.Get(RequestIDHeader)
+	if body["error"] != "Internal server error" || requestID == "" || body["request_id"] != requestID {
+		t.Errorf("body %v, want the error and the request ID %q", body, requestID)
+	}
+
+	entries := logs.FilterMessage("Panic recovered").All()
+	if len(entries) != 1 {
+		t.Fatalf("%d panic logs, want 1", len(entries))
+	}
+	if fields := entries[0].ContextMap(); fields["request_id"] != requestID || fields["stack"] == "" {
+		t.Errorf("log fields %v, want the request ID and stack", fields)
+	}
+	if got := metricLabels(t, reg, "api_gateway_recovered_panics_total"); len(got) != 1 {
+		t.Errorf("recovered_panics_total not recorded")
+	}
+
+	// The server keeps serving
+	resp, err = http.Get(server.URL + "/ok")
+	if err != nil {
+		t.Fatalf("after the panic: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("after the panic: status %d, want 200", resp.StatusCode)
+	}
+}
+
+func TestRecoverAbortsStartedResponses(t *testing.T) {
+	tests := []struct {
+		name       string
+		handler    http.HandlerFunc
+		wantLogged int
+	}{
+		{
+			name: "panic after the response started",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write([]byte("partial"))
+				panic("broken template")
+			},
+			wantLogged: 1,
+		},
+		{
+			// The reverse proxy aborts this way when a backend breaks off mid-body
+			name: "deliberate abort",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write([]byte("partial"))
+				panic(http.ErrAbortHandler)
+			},
+			wantLogged: 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			core, logs := observer.New(zap.ErrorLevel)
+			recovery := NewRecoveryMiddleware(prometheus.NewRegistry(), zap.New(core))
+
+			var recovered interface{}
+			func() {
+				defer func() { recovered = recover() }()
+				recovery.Recover(tt.handler).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+			}()
+			if recovered != http.ErrAbortHandler {
+				t.Errorf("recovered %v, want http.ErrAbortHandler", recovered)
+			}
+			if got := logs.FilterMessage("Panic recovered").Len(); got != tt.wantLogged {
+				t.Errorf("%d panic logs, want %d", got, tt.wantLogged)
+			}
+
+			// Through a server the client sees the connection cut, not a complete body
+			server := httptest.NewServer(recovery.Recover(tt.handler))
+			defer server.Close()
+			resp, err := http.Get(server.URL)
+			if err != nil {
+				return
+			}
+			_, err = io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if err == nil {
+				t.Error("aborted response read as complete")
+			}
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/canxphung/DA_CNPM_242/api_gateway/internal/config"
+)
+
+// SafeMethodPolicy decides which requests may be retried or served from a
+// cache. GET, HEAD and OPTIONS are always safe; configured routes can also
+// opt other methods in, e.g. query-over-POST endpoints.
+type SafeMethodPolicy struct {
+	routes []config.SafeRoute
+}
+
+// NewSafeMethodPolicy creates a policy from the configured safe routes
+func NewSafeMethodPolicy(routes []config.SafeRoute) *SafeMethodPolicy {
+	return &SafeMethodPolicy{routes: routes}
+}
+
+// Allows reports whether a request with this method and gateway path is safe
+func (p *SafeMethodPolicy) Allows(method, path string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	for _, route := range p.routes {
+		if !strings.HasPrefix(path, route.PathPrefix) {
+			continue
+		}
+		for _, routeMethod := range route.Methods {
+			if strings.EqualFold(routeMethod, method) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// MarkSafeRequests records the policy decision in the request context, so the
+// response cache follows the same policy as the proxy's retries. It must run
+// before the cache.
+func (p *SafeMethodPolicy) MarkSafeRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(WithSafeRequest(r.Context(), p.Allows(r.Method, r.URL.Path))))
+	})
+}
+
+type safeRequestContextKey struct{}
+
+// WithSafeRequest records the policy decision in the request context, where it
+// stays available after the proxy has rewritten the path
+func WithSafeRequest(ctx context.Context, safe bool) context.Context {
+	return context.WithValue(ctx, safeRequestContextKey{}, safe)
+}
+
+// IsSafeRequest reports whether the request was marked safe by the policy
+func IsSafeRequest(ctx context.Context) bool {
+	safe, _ := ctx.Value(safeRequestContextKey{}).(bool)
+	return safe
+}
@@ -0,0 +1,98 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/canxphung/DA_CNPM_242/api_gateway/internal/config"
+)
+
+// SecurityHeadersMiddleware adds security headers to responses
+type SecurityHeadersMiddleware struct {
+	headers map[string]string
+	// hsts is sent only on requests received over TLS
+	hsts string
+}
+
+// NewSecurityHeadersMiddleware creates a new security headers middleware
+func NewSecurityHeadersMiddleware(cfg *config.SecurityHeadersConfig) *SecurityHeadersMiddleware {
+	headers := map[string]string{}
+	if cfg.ContentTypeOptions {
+		headers["X-Content-Type-Options"] = "nosniff"
+	}
+	if cfg.FrameOptions != "" {
+		headers["X-Frame-Options"] = cfg.FrameOptions
+	}
+	if cfg.ReferrerPolicy != "" {
+		headers["Referrer-Policy"] = cfg.ReferrerPolicy
+	}
+	if cfg.ContentSecurityPolicy != "" {
+		headers["Content-Security-Policy"] = cfg.ContentSecurityPolicy
+	}
+
+	return &SecurityHeadersMiddleware{
+		headers: headers,
+		hsts:    cfg.StrictTransportSecurity,
+	}
+}
+
+// SetHeaders adds the configured headers to each response just before it is
+// sent, so that headers a backend set on a proxied response take precedence
+func (m *SecurityHeadersMiddleware) SetHeaders(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers := m.headers
+		if m.hsts != "" && r.TLS != nil {
+			headers = make(map[string]string, len(m.headers)+1)
+			for name, value := range m.headers {
+				headers[name] = value
+			}
+			headers["Strict-Transport-Security"] = m.hsts
+		}
+
+		next.ServeHTTP(&securityHeadersWriter{ResponseWriter: w, headers: headers}, r)
+	})
+}
+
+// securityHeadersWriter fills in missing security headers when the response starts
+type securityHeadersWriter struct {
+	http.ResponseWriter
+	headers map[string]string
+	applied bool
+}
+
+func (sw *securityHeadersWriter) apply() {
+	if sw.applied {
+		return
+	}
+	sw.applied = true
+	header := sw.ResponseWriter.Header()
+	for name, value := range sw.headers {
+		if header.Get(name) == "" {
+			header.Set(name, value)
+		}
+	}
+}
+
+func (sw *securityHeadersWriter) WriteHeader(code int) {
+	if !isInformational(code) {
+		sw.apply()
+	}
+	sw.ResponseWriter.WriteHeader(code)
+}
+
+func (sw *securityHeadersWriter) Write(data []byte) (int, error) {
+	sw.apply()
+	return sw.ResponseWriter.Write(data)
+}
+
+// Flush implements the http.Flusher interface if the underlying ResponseWriter supports it
+func (sw *securityHeadersWriter) Flush() {
+	if flusher, ok := sw.ResponseWriter.(http.Flusher); ok {
+		sw.apply()
+		flusher.Flush()
+	}
+}
+
+// Unwrap returns the underlying ResponseWriter for http.ResponseController
+func (sw *securityHeadersWriter) Unwrap() http.ResponseWriter {
+	return sw.ResponseWriter
+}
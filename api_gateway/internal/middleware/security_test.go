@@ -0,0 +1,79 @@
+package middleware
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/canxphung/DA_CNPM_242/api_gateway/internal/config"
+)
+
+func TestSetSecurityHeaders(t *testing.T) {
+	all := config.SecurityHeadersConfig{
+		ContentTypeOptions:      true,
+		FrameOptions:            "DENY",
+		ReferrerPolicy:          "no-referrer",
+		ContentSecurityPolicy:   "default-src 'none'",
+		StrictTransportSecurity: "max-age=31536000",
+	}
+	tests := []struct {
+		name    string
+		cfg     config.SecurityHeadersConfig
+		tls     bool
+		backend map[string]string
+		want    map[string]string
+	}{
+		{
+			name: "plain HTTP omits HSTS",
+			cfg:  all,
+			want: map[string]string{
+				"X-Content-Type-Options": "nosniff", "X-Frame-Options": "DENY", "Referrer-Policy": "no-referrer",
+				"Content-Security-Policy": "default-src 'none'", "Strict-Transport-Security": "",
+			},
+		},
+		{
+			name: "TLS adds HSTS",
+			cfg:  all,
+			tls:  true,
+			want: map[string]string{"X-Frame-Options": "DENY", "Strict-Transport-Security": "max-age=31536000"},
+		},
+		{
+			name:    "backend headers take precedence",
+			cfg:     all,
+			backend: map[string]string{"X-Frame-Options": "SAMEORIGIN", "Content-Security-Policy": "default-src 'self'"},
+			want:    map[string]string{"X-Frame-Options": "SAMEORIGIN", "Content-Security-Policy": "default-src 'self'", "Referrer-Policy": "no-referrer"},
+		},
+		{
+			name: "headers toggled off",
+			cfg:  config.SecurityHeadersConfig{FrameOptions: "DENY"},
+			tls:  true,
+			want: map[string]string{
+				"X-Frame-Options": "DENY", "X-Content-Type-Options": "", "Referrer-Policy": "",
+				"Content-Security-Policy": "", "Strict-Transport-Security": "",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := NewSecurityHeadersMiddleware(&tt.cfg).SetHeaders(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				for name, value := range tt.backend {
+					w.Header().Set(name, value)
+				}
+				_, _ = w.Write([]byte("{}"))
+			}))
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/core-operations/plants", nil)
+			if tt.tls {
+				req.TLS = &tls.ConnectionState{}
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			for name, value := range tt.want {
+				if got := rec.Header().Get(name); got != value {
+					t.Errorf("%s = %q, want %q", name, got, value)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,32 @@
+package middleware
+
+import (
+	"strings"
+
+	"github.com/canxphung/DA_CNPM_242/api_gateway/internal/config"
+)
+
+// servicePrefixes maps each gateway path prefix to its service ID
+type servicePrefixes map[string]string
+
+func newServicePrefixes(services []config.ServiceDefinition) servicePrefixes {
+	prefixes := make(servicePrefixes)
+	for _, service := range services {
+		for _, prefix := range service.Prefixes {
+			prefixes["/api/v1"+prefix] = service.ID
+		}
+	}
+	return prefixes
+}
+
+// serviceFor returns the ID of the service whose prefix covers path, or ""
+// for paths outside every service
+func (p servicePrefixes) serviceFor(path string) string {
+	// Try the longest candidate prefix first, dropping one segment at a time
+	for candidate := path; strings.HasPrefix(candidate, "/api/v1/"); candidate = candidate[:strings.LastIndex(candidate, "/")] {
+		if service, ok := p[candidate]; ok {
+			return service
+		}
+	}
+	return ""
+}
@@ -0,0 +1,123 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// errStreamIdle is returned to handlers writing to a stream that was cancelled for inactivity
+var errStreamIdle = errors.New("stream cancelled after idle timeout")
+
+// StreamIdleMiddleware cancels streaming responses that stop sending data
+type StreamIdleMiddleware struct {
+	idleTimeout time.Duration
+	logger      *zap.Logger
+}
+
+// NewStreamIdleMiddleware creates a new stream idle timeout middleware.
+// A zero timeout disables the check.
+func NewStreamIdleMiddleware(idleTimeout time.Duration, logger *zap.Logger) *StreamIdleMiddleware {
+	return &StreamIdleMiddleware{
+		idleTimeout: idleTimeout,
+		logger:      logger,
+	}
+}
+
+// EnforceIdleTimeout cancels the request context when a streaming response has not
+// written any bytes for the configured idle timeout. A response counts as streaming
+// once it is flushed; regular responses are never affected, and streams that keep
+// flushing are never cancelled however long they run.
+func (m *StreamIdleMiddleware) EnforceIdleTimeout(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if m.idleTimeout <= 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+
+		iw := &idleTimeoutWriter{
+			ResponseWriter: w,
+			timeout:        m.idleTimeout,
+			onIdle: func() {
+				m.logger.Warn("Streaming response idle, cancelling connection",
+					zap.String("method", r.Method),
+					zap.String("path", r.URL.Path),
+					zap.Duration("idle_timeout", m.idleTimeout))
+				cancel()
+			},
+		}
+		defer iw.stop()
+
+		next.ServeHTTP(iw, r.WithContext(ctx))
+	})
+}
+
+// idleTimeoutWriter arms an idle timer on the first flush and resets it on every write
+type idleTimeoutWriter struct {
+	http.ResponseWriter
+	timeout  time.Duration
+	onIdle   func()
+	mu       sync.Mutex
+	timer    *time.Timer
+	timedOut bool
+}
+
+// touch records activity, arming the timer when the response starts streaming
+func (iw *idleTimeoutWriter) touch(streaming bool) bool {
+	iw.mu.Lock()
+	defer iw.mu.Unlock()
+
+	if iw.timedOut {
+		return false
+	}
+	if iw.timer != nil {
+		iw.timer.Reset(iw.timeout)
+	} else if streaming {
+		iw.timer = time.AfterFunc(iw.timeout, iw.expire)
+	}
+	return true
+}
+
+func (iw *idleTimeoutWriter) expire() {
+	iw.mu.Lock()
+	iw.timedOut = true
+	iw.mu.Unlock()
+	iw.onIdle()
+}
+
+func (iw *idleTimeoutWriter) stop() {
+	iw.mu.Lock()
+	defer iw.mu.Unlock()
+	if iw.timer != nil {
+		iw.timer.Stop()
+	}
+}
+
+func (iw *idleTimeoutWriter) Write(data []byte) (int, error) {
+	if !iw.touch(false) {
+		return 0, errStreamIdle
+	}
+	return iw.ResponseWriter.Write(data)
+}
+
+// Flush implements the http.Flusher interface and marks the response as streaming
+func (iw *idleTimeoutWriter) Flush() {
+	if !iw.touch(true) {
+		return
+	}
+	if flusher, ok := iw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap returns the underlying ResponseWriter for http.ResponseController
+func (iw *idleTimeoutWriter) Unwrap() http.ResponseWriter {
+	return iw.ResponseWriter
+}
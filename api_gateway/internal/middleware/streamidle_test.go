@@ -0,0 +1,83 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// streamingHandler writes a chunk every interval, flushing each one when flush
+// is set, and records whether its context was cancelled and the last write error
+type streamingHandler struct {
+	chunks    int
+	interval  time.Duration
+	flush     bool
+	cancelled bool
+	err       error
+}
+
+func (h *streamingHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	for i := 0; i < h.chunks; i++ {
+		if i > 0 {
+			select {
+			case <-time.After(h.interval):
+			case <-r.Context().Done():
+				h.cancelled = true
+				_, h.err = w.Write([]byte("late"))
+				return
+			}
+		}
+		if _, err := w.Write([]byte("data\n")); err != nil {
+			h.err = err
+			return
+		}
+		if h.flush {
+			w.(http.Flusher).Flush()
+		}
+	}
+}
+
+func TestEnforceIdleTimeout(t *testing.T) {
+	tests := []struct {
+		name          string
+		idleTimeout   time.Duration
+		handler       *streamingHandler
+		wantCancelled bool
+	}{
+		{"active stream outlives the timeout", 100 * time.Millisecond, &streamingHandler{chunks: 10, interval: 20 * time.Millisecond, flush: true}, false},
+		{"idle stream is cancelled", 50 * time.Millisecond, &streamingHandler{chunks: 2, interval: time.Second, flush: true}, true},
+		{"slow regular response is not a stream", 50 * time.Millisecond, &streamingHandler{chunks: 2, interval: 200 * time.Millisecond}, false},
+		{"zero timeout disables the check", 0, &streamingHandler{chunks: 2, interval: 150 * time.Millisecond, flush: true}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := NewStreamIdleMiddleware(tt.idleTimeout, zap.NewNop()).EnforceIdleTimeout(tt.handler)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/greenhouse-ai/api/stream", nil))
+
+			if tt.handler.cancelled != tt.wantCancelled {
+				t.Errorf("cancelled = %v, want %v", tt.handler.cancelled, tt.wantCancelled)
+			}
+			if tt.wantCancelled {
+				// Nothing more reaches the client once the stream is cancelled
+				if !errors.Is(tt.handler.err, errStreamIdle) {
+					t.Errorf("write after cancel: %v, want errStreamIdle", tt.handler.err)
+				}
+				if rec.Body.String() != "data\n" {
+					t.Errorf("body %q, want only the data sent before the cancel", rec.Body.String())
+				}
+				return
+			}
+			if tt.handler.err != nil {
+				t.Errorf("write error %v", tt.handler.err)
+			}
+			if want := tt.handler.chunks * len("data\n"); rec.Body.Len() != want {
+				t.Errorf("body has %d bytes, want %d", rec.Body.Len(), want)
+			}
+		})
+	}
+}
@@ -0,0 +1,187 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/canxphung/DA_CNPM_242/api_gateway/internal/config"
+	"github.com/canxphung/DA_CNPM_242/api_gateway/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// errStreamRejected is returned to handlers whose streaming response was refused at capacity
+var errStreamRejected = errors.New("streaming connection limit reached")
+
+// IsStreamingRequest reports whether the client is asking for a long-lived
+// streaming response (Server-Sent Events or a protocol upgrade)
+func IsStreamingRequest(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "text/event-stream") ||
+		r.Header.Get("Upgrade") != ""
+}
+
+// isStreamingResponse reports whether the response headers describe an event stream
+func isStreamingResponse(header http.Header) bool {
+	return strings.HasPrefix(header.Get("Content-Type"), "text/event-stream")
+}
+
+// StreamLimitMiddleware caps the number of concurrent streaming connections
+type StreamLimitMiddleware struct {
+	maxConnections int64
+	routes         []string
+	active         atomic.Int64
+	activeGauge    prometheus.Gauge
+	rejected       prometheus.Counter
+	overload       *OverloadResponder
+	logger         *zap.Logger
+}
+
+// NewStreamLimitMiddleware creates a new streaming connection limit middleware
+func NewStreamLimitMiddleware(cfg *config.StreamingConfig, overload *OverloadResponder, reg prometheus.Registerer, logger *zap.Logger) *StreamLimitMiddleware {
+	const namespace = "api_gateway"
+
+	activeGauge := metrics.RegisterOrReuse(reg, prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "streaming_connections",
+			Help:      "Current number of open streaming connections",
+		},
+	))
+
+	rejected := metrics.RegisterOrReuse(reg, prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "streaming_rejected_total",
+			Help:      "Total number of streaming connections rejected at capacity",
+		},
+	))
+
+	return &StreamLimitMiddleware{
+		maxConnections: int64(cfg.MaxConnections),
+		routes:         cfg.Routes,
+		activeGauge:    activeGauge,
+		rejected:       rejected,
+		overload:       overload,
+		logger:         logger,
+	}
+}
+
+// LimitStreams rejects new streaming connections once the cap is reached.
+// Streams are detected up front from the request (Accept/Upgrade headers or a
+// configured streaming route) or, failing that, from an event-stream response.
+// Normal requests are never limited.
+func (m *StreamLimitMiddleware) LimitStreams(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if IsStreamingRequest(r) || m.isStreamingRoute(r.URL.Path) {
+			if !m.acquire() {
+				m.reject(w, r)
+				return
+			}
+			defer m.release()
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		sw := &streamDetectWriter{ResponseWriter: w, limiter: m, request: r}
+		defer func() {
+			if sw.acquired {
+				m.release()
+			}
+		}()
+		next.ServeHTTP(sw, r)
+	})
+}
+
+func (m *StreamLimitMiddleware) isStreamingRoute(path string) bool {
+	for _, route := range m.routes {
+		if strings.HasPrefix(path, route) {
+			return true
+		}
+	}
+	return false
+}
+
+// acquire takes a streaming slot if one is available
+func (m *StreamLimitMiddleware) acquire() bool {
+	for {
+		current := m.active.Load()
+		if m.maxConnections > 0 && current >= m.maxConnections {
+			return false
+		}
+		if m.active.CompareAndSwap(current, current+1) {
+			m.activeGauge.Inc()
+			return true
+		}
+	}
+}
+
+func (m *StreamLimitMiddleware) release() {
+	m.active.Add(-1)
+	m.activeGauge.Dec()
+}
+
+func (m *StreamLimitMiddleware) reject(w http.ResponseWriter, r *http.Request) {
+	m.rejected.Inc()
+	m.logger.Warn("Streaming connection limit reached",
+		zap.String("method", r.Method),
+		zap.String("path", r.URL.Path),
+		zap.Int64("max_connections", m.maxConnections))
+	m.overload.Reject(w, OverloadStreams, "Too many streaming connections", 0)
+}
+
+// streamDetectWriter takes a streaming slot when the response turns out to be an event stream
+type streamDetectWriter struct {
+	http.ResponseWriter
+	limiter     *StreamLimitMiddleware
+	request     *http.Request
+	wroteHeader bool
+	acquired    bool
+	rejected    bool
+}
+
+func (sw *streamDetectWriter) WriteHeader(code int) {
+	// Informational responses don't carry the final headers
+	if isInformational(code) || sw.wroteHeader {
+		sw.ResponseWriter.WriteHeader(code)
+		return
+	}
+	sw.wroteHeader = true
+
+	if isStreamingResponse(sw.Header()) {
+		if !sw.limiter.acquire() {
+			sw.rejected = true
+			sw.Header().Del("Content-Type")
+			sw.limiter.reject(sw.ResponseWriter, sw.request)
+			return
+		}
+		sw.acquired = true
+	}
+	sw.ResponseWriter.WriteHeader(code)
+}
+
+func (sw *streamDetectWriter) Write(data []byte) (int, error) {
+	if !sw.wroteHeader {
+		sw.WriteHeader(http.StatusOK)
+	}
+	if sw.rejected {
+		return 0, errStreamRejected
+	}
+	return sw.ResponseWriter.Write(data)
+}
+
+// Flush implements the http.Flusher interface
+func (sw *streamDetectWriter) Flush() {
+	if sw.rejected {
+		return
+	}
+	if flusher, ok := sw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap returns the underlying ResponseWriter for http.ResponseController
+func (sw *streamDetectWriter) Unwrap() http.ResponseWriter {
+	return sw.ResponseWriter
+}
@@ -0,0 +1,143 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/canxphung/DA_CNPM_242/api_gateway/internal/config"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+func newTestStreamLimit(maxConnections int, routes ...string) (*StreamLimitMiddleware, *prometheus.Registry) {
+	reg := prometheus.NewRegistry()
+	overload := NewOverloadResponder(&config.OverloadConfig{RateLimitStatus: http.StatusTooManyRequests, CapacityStatus: http.StatusServiceUnavailable})
+	return NewStreamLimitMiddleware(&config.StreamingConfig{MaxConnections: maxConnections, Routes: routes}, overload, reg, zap.NewNop()), reg
+}
+
+// holdStream serves an event stream through handler and keeps it open until
+// the returned function is called
+func holdStream(t *testing.T, handler func(http.Handler) http.Handler) func() {
+	t.Helper()
+	started, done, finished := make(chan struct{}), make(chan struct{}), make(chan struct{})
+	stream := handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.WriteHeader(http.StatusOK)
+		close(started)
+		<-done
+	}))
+	go func() {
+		defer close(finished)
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/greenhouse-ai/api/chat", nil)
+		req.Header.Set("Accept", "text/event-stream")
+		stream.ServeHTTP(httptest.NewRecorder(), req)
+	}()
+	<-started
+	return func() {
+		close(done)
+		<-finished
+	}
+}
+
+func gaugeValue(t *testing.T, reg *prometheus.Registry, name string) float64 {
+	t.Helper()
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("Gather: %v", err)
+	}
+	for _, family := range families {
+		if family.GetName() == name {
+			return family.GetMetric()[0].GetGauge().GetValue()
+		}
+	}
+	return 0
+}
+
+func TestLimitStreamsAtCapacity(t *testing.T) {
+	limiter, reg := newTestStreamLimit(1, "/debug/stream")
+	release := holdStream(t, limiter.LimitStreams)
+
+	if got := gaugeValue(t, reg, "api_gateway_streaming_connections"); got != 1 {
+		t.Errorf("streaming_connections = %v, want 1", got)
+	}
+
+	tests := []struct {
+		name   string
+		path   string
+		header map[string]string
+		want   int
+	}{
+		{"event stream request", "/api/v1/greenhouse-ai/api/chat", map[string]string{"Accept": "text/event-stream"}, http.StatusServiceUnavailable},
+		{"upgrade request", "/api/v1/greenhouse-ai/ws", map[string]string{"Upgrade": "websocket"}, http.StatusServiceUnavailable},
+		{"configured streaming route", "/debug/stream", nil, http.StatusServiceUnavailable},
+		{"normal request", "/api/v1/core-operations/plants", nil, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			called := false
+			handler := limiter.LimitStreams(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { called = true }))
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			for key, value := range tt.header {
+				req.Header.Set(key, value)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.want || called != (tt.want == http.StatusOK) {
+				t.Errorf("status %d, next called %v: want %d", rec.Code, called, tt.want)
+			}
+			if tt.want != http.StatusOK && rec.Header().Get("Retry-After") == "" {
+				t.Error("rejection without Retry-After")
+			}
+		})
+	}
+
+	release()
+	if got := gaugeValue(t, reg, "api_gateway_streaming_connections"); got != 0 {
+		t.Errorf("streaming_connections after close = %v, want 0", got)
+	}
+	// The freed slot is available again
+	finish := holdStream(t, limiter.LimitStreams)
+	finish()
+}
+
+func TestLimitStreamsDetectsEventStreamResponse(t *testing.T) {
+	limiter, reg := newTestStreamLimit(1)
+	release := holdStream(t, limiter.LimitStreams)
+	defer release()
+
+	// The request does not announce a stream, but the response is one
+	var writeErr error
+	handler := limiter.LimitStreams(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, writeErr = w.Write([]byte("data: reading\n\n"))
+	}))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/greenhouse-ai/api/live", nil))
+
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("status %d, want 503", rec.Code)
+	}
+	if !errors.Is(writeErr, errStreamRejected) {
+		t.Errorf("write error %v, want errStreamRejected", writeErr)
+	}
+	if rec.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("Content-Type = %q, want the JSON rejection", rec.Header().Get("Content-Type"))
+	}
+	if got := gaugeValue(t, reg, "api_gateway_streaming_connections"); got != 1 {
+		t.Errorf("streaming_connections = %v, want only the held stream", got)
+	}
+}
+
+func TestLimitStreamsUnlimited(t *testing.T) {
+	limiter, _ := newTestStreamLimit(0)
+	var releases []func()
+	for i := 0; i < 5; i++ {
+		releases = append(releases, holdStream(t, limiter.LimitStreams))
+	}
+	for _, release := range releases {
+		release()
+	}
+}
@@ -0,0 +1,185 @@
+package proxy
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/canxphung/DA_CNPM_242/api_gateway/internal/health"
+)
+
+// canaryHeader lets a client pin itself to the canary ("true") or stable ("false") target
+const canaryHeader = "X-Canary"
+
+// Target names used in metrics and traces
+const (
+	stableTargetName = "stable"
+	canaryTargetName = "canary"
+)
+
+// weightedTarget is one backend of a service and its share of the traffic
+type weightedTarget struct {
+	name   string
+	url    *url.URL
+	weight int
+}
+
+// targetSelector spreads a service's requests over its weighted targets.
+// The first target is the stable one; any others are canaries.
+type targetSelector struct {
+	targets []weightedTarget
+	total   int
+}
+
+// newTargetSelector parses a service URL setting. A plain URL is a single
+// target; several targets are separated by ";" and each ends in ":<weight>",
+// e.g. "http://stable:8002:9;http://canary:8002:1".
+func newTargetSelector(raw string) (*targetSelector, error) {
+	var entries []string
+	for _, entry := range strings.Split(raw, ";") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			entries = append(entries, entry)
+		}
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("no target URL configured")
+	}
+
+	if len(entries) == 1 {
+		target, err := url.Parse(entries[0])
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse target URL: %w", err)
+		}
+		return &targetSelector{
+			targets: []weightedTarget{{name: stableTargetName, url: target, weight: 1}},
+			total:   1,
+		}, nil
+	}
+
+	selector := &targetSelector{}
+	for i, entry := range entries {
+		separator := strings.LastIndex(entry, ":")
+		if separator < 0 {
+			return nil, fmt.Errorf("weighted target %q has no weight", entry)
+		}
+		weight, err := strconv.Atoi(entry[separator+1:])
+		if err != nil || weight < 0 {
+			return nil, fmt.Errorf("invalid weight for target %q", entry)
+		}
+		target, err := url.Parse(entry[:separator])
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse target URL %q: %w", entry[:separator], err)
+		}
+
+		name := stableTargetName
+		if i > 0 {
+			name = canaryTargetName
+			if len(entries) > 2 {
+				name += "-" + strconv.Itoa(i)
+			}
+		}
+		selector.targets = append(selector.targets, weightedTarget{name: name, url: target, weight: weight})
+		selector.total += weight
+	}
+	if selector.total == 0 {
+		return nil, fmt.Errorf("weighted targets %q have no traffic", raw)
+	}
+	return selector, nil
+}
+
+// stable returns the stable target
+func (s *targetSelector) stable() weightedTarget {
+	return s.targets[0]
+}
+
+// pick chooses the target for a request among those up reports as healthy.
+// The X-Canary header forces a choice while that target is up; otherwise the
+// request ID is hashed, so a given request always lands on the same target
+// (e.g. when retried) while the traffic as a whole follows the weights.
+// When no target is up every target is eligible.
+func (s *targetSelector) pick(req *http.Request, requestID string, up func(weightedTarget) bool) weightedTarget {
+	if len(s.targets) == 1 {
+		return s.targets[0]
+	}
+
+	switch strings.ToLower(strings.TrimSpace(req.Header.Get(canaryHeader))) {
+	case "true", "1":
+		if up(s.targets[1]) {
+			return s.targets[1]
+		}
+	case "false", "0":
+		if up(s.targets[0]) {
+			return s.targets[0]
+		}
+	}
+
+	// Unhealthy targets leave the rotation, and their share goes to the rest
+	eligible := make([]weightedTarget, 0, len(s.targets))
+	total := 0
+	for _, target := range s.targets {
+		if target.weight > 0 && up(target) {
+			eligible = append(eligible, target)
+			total += target.weight
+		}
+	}
+	if total == 0 {
+		eligible, total = s.targets, s.total
+	}
+
+	var point int
+	if requestID != "" {
+		hash := fnv.New32a()
+		hash.Write([]byte(requestID))
+		point = int(hash.Sum32() % uint32(total))
+	} else {
+		point = rand.Intn(total)
+	}
+
+	for _, target := range eligible {
+		if point < target.weight {
+			return target
+		}
+		point -= target.weight
+	}
+	return s.targets[0]
+}
+
+// anyUp reports whether at least one target that takes traffic is up
+func (s *targetSelector) anyUp(up func(weightedTarget) bool) bool {
+	for _, target := range s.targets {
+		if target.weight > 0 && up(target) {
+			return true
+		}
+	}
+	return false
+}
+
+// healthName is the name the health checker tracks a target under: the
+// service ID for the stable target and "<service>/<target>" for canaries
+func healthName(serviceID string, target weightedTarget) string {
+	if target.name == stableTargetName {
+		return serviceID
+	}
+	return serviceID + "/" + target.name
+}
+
+// HealthTargets returns a health check target for every weighted target of a
+// service URL setting, so canaries are checked alongside the stable target
+func HealthTargets(serviceID, raw, healthPath string) []health.Target {
+	selector, err := newTargetSelector(raw)
+	if err != nil {
+		return []health.Target{{Service: serviceID, URL: raw + healthPath}}
+	}
+	targets := make([]health.Target, 0, len(selector.targets))
+	for _, target := range selector.targets {
+		targets = append(targets, health.Target{
+			Service: healthName(serviceID, target),
+			URL:     strings.TrimSuffix(target.url.String(), "/") + healthPath,
+		})
+	}
+	return targets
+}
@@ -0,0 +1,216 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/canxphung/DA_CNPM_242/api_gateway/internal/config"
+	"github.com/canxphung/DA_CNPM_242/api_gateway/internal/health"
+	"github.com/canxphung/DA_CNPM_242/api_gateway/internal/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+func allUp(weightedTarget) bool { return true }
+
+func newTestSelector(t *testing.T, raw string) *targetSelector {
+	t.Helper()
+	selector, err := newTargetSelector(raw)
+	if err != nil {
+		t.Fatalf("newTargetSelector(%q): %v", raw, err)
+	}
+	return selector
+}
+
+// countPicks picks a target for n distinct request IDs
+func countPicks(selector *targetSelector, n int, header string, up func(weightedTarget) bool) map[string]int {
+	counts := map[string]int{}
+	for i := 0; i < n; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/core-operations/plants", nil)
+		if header != "" {
+			req.Header.Set(canaryHeader, header)
+		}
+		counts[selector.pick(req, fmt.Sprintf("req-%d", i), up).name]++
+	}
+	return counts
+}
+
+func TestPickFollowsWeights(t *testing.T) {
+	selector := newTestSelector(t, "http://stable:8002:9;http://canary:8002:1")
+
+	counts := countPicks(selector, 10000, "", allUp)
+	if canary := counts[canaryTargetName]; canary < 800 || canary > 1200 {
+		t.Errorf("canary got %d of 10000 requests, want about 1000", canary)
+	}
+
+	// The same request ID always lands on the same target
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	first := selector.pick(req, "req-42", allUp)
+	for i := 0; i < 10; i++ {
+		if got := selector.pick(req, "req-42", allUp); got.name != first.name {
+			t.Fatalf("req-42 moved from %s to %s", first.name, got.name)
+		}
+	}
+}
+
+func TestPickCanaryHeader(t *testing.T) {
+	selector := newTestSelector(t, "http://stable:8002:9;http://canary:8002:1")
+
+	if counts := countPicks(selector, 100, "true", allUp); counts[canaryTargetName] != 100 {
+		t.Errorf("X-Canary: true picks = %v, want all canary", counts)
+	}
+	if counts := countPicks(selector, 100, "false", allUp); counts[stableTargetName] != 100 {
+		t.Errorf("X-Canary: false picks = %v, want all stable", counts)
+	}
+}
+
+func TestPickSkipsDownTargets(t *testing.T) {
+	selector := newTestSelector(t, "http://stable:8002:9;http://canary:8002:1")
+	canaryDown := func(target weightedTarget) bool { return target.name != canaryTargetName }
+
+	if counts := countPicks(selector, 1000, "", canaryDown); counts[stableTargetName] != 1000 {
+		t.Errorf("picks with the canary down = %v, want all stable", counts)
+	}
+	if counts := countPicks(selector, 100, "true", canaryDown); counts[stableTargetName] != 100 {
+		t.Errorf("X-Canary: true with the canary down = %v, want all stable", counts)
+	}
+	if !selector.anyUp(canaryDown) {
+		t.Error("anyUp = false with the stable target up")
+	}
+	if selector.anyUp(func(weightedTarget) bool { return false }) {
+		t.Error("anyUp = true with every target down")
+	}
+}
+
+func TestHealthTargets(t *testing.T) {
+	targets := HealthTargets("core-operations", "http://stable:8002/:9;http://canary:8002:1", "/health")
+	want := []health.Target{
+		{Service: "core-operations", URL: "http://stable:8002/health"},
+		{Service: "core-operations/canary", URL: "http://canary:8002/health"},
+	}
+	if len(targets) != len(want) {
+		t.Fatalf("targets = %v, want %v", targets, want)
+	}
+	for i := range want {
+		if targets[i] != want[i] {
+			t.Errorf("target %d = %v, want %v", i, targets[i], want[i])
+		}
+	}
+
+	if single := HealthTargets("user-auth", "http://user-auth:8000", "/health"); len(single) != 1 || single[0].Service != "user-auth" {
+		t.Errorf("single target = %v", single)
+	}
+}
+
+// newTestBackend answers health checks with healthStatus and every other
+// request with its name
+func newTestBackend(t *testing.T, name string, healthStatus int) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/health" {
+			w.WriteHeader(healthStatus)
+			return
+		}
+		_, _ = w.Write([]byte(name))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestProxyRoutesAroundDownCanary(t *testing.T) {
+	stable := newTestBackend(t, stableTargetName, http.StatusOK)
+	canary := newTestBackend(t, canaryTargetName, http.StatusServiceUnavailable)
+	serviceURL := stable.URL + ":9;" + canary.URL + ":1"
+
+	reg := prometheus.NewRegistry()
+	checker := health.NewChecker(&config.HealthCheckConfig{
+		Interval:         time.Hour,
+		Timeout:          time.Second,
+		FailureThreshold: 1,
+		FailFast:         true,
+	}, HealthTargets("core-operations", serviceURL, "/health"), reg, zap.NewNop())
+	checker.Start()
+	t.Cleanup(checker.Stop)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for checker.Statuses()["core-operations/canary"].State != health.StateDown {
+		if time.Now().After(deadline) {
+			t.Fatalf("canary not marked down: %v", checker.Statuses())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	cfg := &config.ProxyConfig{Services: map[string]config.ServiceProxyConfig{"core-operations": {}}}
+	cors := middleware.NewCORSMiddleware(&config.CORSConfig{}, zap.NewNop())
+	overload := middleware.NewOverloadResponder(&config.OverloadConfig{RateLimitStatus: http.StatusTooManyRequests, CapacityStatus: http.StatusServiceUnavailable})
+	p, err := NewServiceProxy(serviceURL, "core-operations", cfg, NewMetrics(reg), overload, cors, checker, zap.NewNop())
+	if err != nil {
+		t.Fatalf("NewServiceProxy: %v", err)
+	}
+
+	for i := 0; i < 50; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/core-operations/plants", nil)
+		if i%2 == 0 {
+			req.Header.Set(canaryHeader, "true")
+		}
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK || rec.Body.String() != stableTargetName {
+			t.Fatalf("request %d: status %d body %q, want the stable backend", i, rec.Code, rec.Body.String())
+		}
+	}
+}
+
+func TestProxyRejectsDownBackend(t *testing.T) {
+	backend := newTestBackend(t, stableTargetName, http.StatusServiceUnavailable)
+
+	reg := prometheus.NewRegistry()
+	checker := health.NewChecker(&config.HealthCheckConfig{
+		Interval:         30 * time.Second,
+		Timeout:          time.Second,
+		FailureThreshold: 1,
+		FailFast:         true,
+	}, HealthTargets("core-operations", backend.URL, "/health"), reg, zap.NewNop())
+	checker.Start()
+	t.Cleanup(checker.Stop)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for checker.Statuses()["core-operations"].State != health.StateDown {
+		if time.Now().After(deadline) {
+			t.Fatalf("backend not marked down: %v", checker.Statuses())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	cfg := &config.ProxyConfig{Services: map[string]config.ServiceProxyConfig{"core-operations": {}}}
+	cors := middleware.NewCORSMiddleware(&config.CORSConfig{AllowedOrigins: []string{"http://dashboard.greenhouse.local"}}, zap.NewNop())
+	overload := middleware.NewOverloadResponder(&config.OverloadConfig{RateLimitStatus: http.StatusTooManyRequests, CapacityStatus: http.StatusServiceUnavailable, RetryAfter: time.Second})
+	p, err := NewServiceProxy(backend.URL, "core-operations", cfg, NewMetrics(reg), overload, cors, checker, zap.NewNop())
+	if err != nil {
+		t.Fatalf("NewServiceProxy: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/core-operations/plants", nil)
+	req.Header.Set("Origin", "http://dashboard.greenhouse.local")
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("status %d, want 503", rec.Code)
+	}
+	// Clients are told to come back after the next health check
+	if got := rec.Header().Get("Retry-After"); got != "30" {
+		t.Errorf("Retry-After = %q, want the check interval of 30", got)
+	}
+	var body middleware.OverloadResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || body.Reason != middleware.OverloadBackendDown || body.RetryAfter != 30 {
+		t.Errorf("body %q, want the shared overload response for a down backend", rec.Body.String())
+	}
+	if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "http://dashboard.greenhouse.local" {
+		t.Errorf("Access-Control-Allow-Origin = %q, want the allowed origin", got)
+	}
+}
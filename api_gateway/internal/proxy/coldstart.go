@@ -0,0 +1,90 @@
+package proxy
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/canxphung/DA_CNPM_242/api_gateway/internal/config"
+	"github.com/canxphung/DA_CNPM_242/api_gateway/internal/middleware"
+	"go.uber.org/zap"
+)
+
+// coldStartTransport retries a request once when the backend's response
+// headers time out while it is likely still warming up: before it has
+// answered at all, or after it has been idle for longer than idleAfter.
+type coldStartTransport struct {
+	next      http.RoundTripper
+	idleAfter time.Duration
+	serviceID string
+	logger    *zap.Logger
+	// lastResponse is the UnixNano time of the last response; 0 until the first one
+	lastResponse atomic.Int64
+}
+
+// newColdStartTransport wraps next, or returns it unchanged when cold-start retries are disabled
+func newColdStartTransport(next http.RoundTripper, cfg config.ColdStartConfig, serviceID string, logger *zap.Logger) http.RoundTripper {
+	if !cfg.Enabled {
+		return next
+	}
+	return &coldStartTransport{
+		next:      next,
+		idleAfter: cfg.IdleAfter,
+		serviceID: serviceID,
+		logger:    logger,
+	}
+}
+
+func (t *coldStartTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	cold := t.isCold()
+
+	resp, err := t.next.RoundTrip(req)
+	if err == nil {
+		t.lastResponse.Store(time.Now().UnixNano())
+		return resp, nil
+	}
+	// Only safe requests are retried: the backend may have processed the first attempt
+	if !cold || !isHeaderTimeout(err) || req.Context().Err() != nil || !middleware.IsSafeRequest(req.Context()) || !canReplay(req) {
+		return resp, err
+	}
+
+	t.logger.Info("Backend header timeout during warmup, retrying once",
+		zap.String("service", t.serviceID),
+		zap.String("method", req.Method),
+		zap.String("path", req.URL.Path),
+		zap.Error(err))
+
+	retry := req.Clone(req.Context())
+	if req.GetBody != nil {
+		body, bodyErr := req.GetBody()
+		if bodyErr != nil {
+			return nil, err
+		}
+		retry.Body = body
+	}
+
+	resp, err = t.next.RoundTrip(retry)
+	if err == nil {
+		t.lastResponse.Store(time.Now().UnixNano())
+	}
+	return resp, err
+}
+
+// isCold reports whether the backend is inside its warmup window
+func (t *coldStartTransport) isCold() bool {
+	last := t.lastResponse.Load()
+	return last == 0 || time.Since(time.Unix(0, last)) > t.idleAfter
+}
+
+// isHeaderTimeout reports whether the round trip failed waiting for response headers
+func isHeaderTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// canReplay reports whether the request body can be sent a second time
+func canReplay(req *http.Request) bool {
+	return req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+}
@@ -0,0 +1,288 @@
+package proxy
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/canxphung/DA_CNPM_242/api_gateway/internal/config"
+	"github.com/canxphung/DA_CNPM_242/api_gateway/internal/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// headerTimeoutError is what http.Transport returns when response headers time out
+type headerTimeoutError struct{}
+
+func (headerTimeoutError) Error() string   { return "net/http: timeout awaiting response headers" }
+func (headerTimeoutError) Timeout() bool   { return true }
+func (headerTimeoutError) Temporary() bool { return true }
+
+// timingOutTransport fails the first timeouts round trips with a header timeout
+type timingOutTransport struct {
+	timeouts int32
+	calls    atomic.Int32
+	bodies   []string
+}
+
+func (tr *timingOutTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		body, _ := io.ReadAll(req.Body)
+		tr.bodies = append(tr.bodies, string(body))
+	}
+	if tr.calls.Add(1) <= tr.timeouts {
+		return nil, headerTimeoutError{}
+	}
+	return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody, Request: req}, nil
+}
+
+func newTestColdStart(next http.RoundTripper, idleAfter time.Duration) *coldStartTransport {
+	return newColdStartTransport(next, config.ColdStartConfig{Enabled: true, IdleAfter: idleAfter}, "greenhouse-ai", zap.NewNop()).(*coldStartTransport)
+}
+
+// coldStartRequest builds a request marked safe or not, as the proxy does
+func coldStartRequest(t *testing.T, method, body string, safe bool) *http.Request {
+	t.Helper()
+	var reader io.Reader
+	if body != "" {
+		reader = strings.NewReader(body)
+	}
+	req, err := http.NewRequest(method, "http://greenhouse-ai/api/predict", reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return req.WithContext(middleware.WithSafeRequest(req.Context(), safe))
+}
+
+func TestColdStartRetriesFirstTimeout(t *testing.T) {
+	next := &timingOutTransport{timeouts: 1}
+	transport := newTestColdStart(next, time.Minute)
+
+	resp, err := transport.RoundTrip(coldStartRequest(t, http.MethodGet, "", true))
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("cold request: resp=%v err=%v, want the retry's 200", resp, err)
+	}
+	if calls := next.calls.Load(); calls != 2 {
+		t.Errorf("backend calls = %d, want 2", calls)
+	}
+}
+
+func TestColdStartRetriesOnce(t *testing.T) {
+	next := &timingOutTransport{timeouts: 5}
+	transport := newTestColdStart(next, time.Minute)
+
+	if _, err := transport.RoundTrip(coldStartRequest(t, http.MethodGet, "", true)); !isHeaderTimeout(err) {
+		t.Fatalf("err = %v, want the retry's header timeout", err)
+	}
+	if calls := next.calls.Load(); calls != 2 {
+		t.Errorf("backend calls = %d, want 2", calls)
+	}
+}
+
+func TestColdStartWarmBackendNotRetried(t *testing.T) {
+	next := &timingOutTransport{}
+	transport := newTestColdStart(next, time.Minute)
+	if _, err := transport.RoundTrip(coldStartRequest(t, http.MethodGet, "", true)); err != nil {
+		t.Fatalf("warming request: %v", err)
+	}
+
+	// A timeout right after a response is a slow backend, not a cold one
+	next.timeouts = next.calls.Load() + 1
+	if _, err := transport.RoundTrip(coldStartRequest(t, http.MethodGet, "", true)); !isHeaderTimeout(err) {
+		t.Fatalf("err = %v, want the header timeout", err)
+	}
+	if calls := next.calls.Load(); calls != 2 {
+		t.Errorf("backend calls = %d, want 2 (no retry)", calls)
+	}
+}
+
+func TestColdStartRetriesAfterIdle(t *testing.T) {
+	next := &timingOutTransport{}
+	transport := newTestColdStart(next, time.Minute)
+	if _, err := transport.RoundTrip(coldStartRequest(t, http.MethodGet, "", true)); err != nil {
+		t.Fatalf("warming request: %v", err)
+	}
+	// The backend last answered longer ago than idleAfter
+	transport.lastResponse.Store(time.Now().Add(-2 * time.Minute).UnixNano())
+
+	next.timeouts = next.calls.Load() + 1
+	resp, err := transport.RoundTrip(coldStartRequest(t, http.MethodGet, "", true))
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("idle request: resp=%v err=%v, want the retry's 200", resp, err)
+	}
+	if calls := next.calls.Load(); calls != 3 {
+		t.Errorf("backend calls = %d, want 3", calls)
+	}
+}
+
+func TestColdStartNotRetried(t *testing.T) {
+	tests := []struct {
+		name string
+		next http.RoundTripper
+		req  func(t *testing.T) *http.Request
+	}{
+		{
+			name: "unsafe request",
+			next: &timingOutTransport{timeouts: 1},
+			req: func(t *testing.T) *http.Request {
+				return coldStartRequest(t, http.MethodPost, `{"plant":"basil"}`, false)
+			},
+		},
+		{
+			name: "other errors",
+			next: roundTripFunc(func(*http.Request) (*http.Response, error) { return nil, errors.New("connection refused") }),
+			req:  func(t *testing.T) *http.Request { return coldStartRequest(t, http.MethodGet, "", true) },
+		},
+		{
+			name: "body that cannot be replayed",
+			next: &timingOutTransport{timeouts: 1},
+			req: func(t *testing.T) *http.Request {
+				req := coldStartRequest(t, http.MethodPut, `{"plant":"basil"}`, true)
+				req.GetBody = nil
+				return req
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls atomic.Int32
+			counting := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+				calls.Add(1)
+				return tt.next.RoundTrip(req)
+			})
+
+			if _, err := newTestColdStart(counting, time.Minute).RoundTrip(tt.req(t)); err == nil {
+				t.Fatal("first attempt's error was not returned")
+			}
+			if calls.Load() != 1 {
+				t.Errorf("backend calls = %d, want 1", calls.Load())
+			}
+		})
+	}
+}
+
+func TestColdStartReplaysBody(t *testing.T) {
+	next := &timingOutTransport{timeouts: 1}
+	transport := newTestColdStart(next, time.Minute)
+
+	resp, err := transport.RoundTrip(coldStartRequest(t, http.MethodPut, `{"plant":"basil"}`, true))
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("resp=%v err=%v", resp, err)
+	}
+	if len(next.bodies) != 2 || next.bodies[0] != next.bodies[1] || next.bodies[1] != `{"plant":"basil"}` {
+		t.Errorf("bodies = %q, want the same body twice", next.bodies)
+	}
+}
+
+func TestColdStartDisabled(t *testing.T) {
+	next := &timingOutTransport{}
+	if transport := newColdStartTransport(next, config.ColdStartConfig{}, "greenhouse-ai", zap.NewNop()); transport != next {
+		t.Errorf("disabled cold start wrapped the transport: %T", transport)
+	}
+}
+
+// newTestServiceProxy builds a proxy for serviceID with the given per-service settings
+func newTestServiceProxy(t *testing.T, targetURL, serviceID string, serviceCfg config.ServiceProxyConfig) *ServiceProxy {
+	t.Helper()
+	cfg := &config.ProxyConfig{Services: map[string]config.ServiceProxyConfig{serviceID: serviceCfg}}
+	cors := middleware.NewCORSMiddleware(&config.CORSConfig{}, zap.NewNop())
+	overload := middleware.NewOverloadResponder(&config.OverloadConfig{RateLimitStatus: http.StatusTooManyRequests, CapacityStatus: http.StatusServiceUnavailable})
+	p, err := NewServiceProxy(targetURL, serviceID, cfg, NewMetrics(prometheus.NewRegistry()), overload, cors, nil, zap.NewNop())
+	if err != nil {
+		t.Fatalf("NewServiceProxy: %v", err)
+	}
+	return p
+}
+
+// backendTransport returns the http.Transport below the proxy's wrapping transports
+func backendTransport(t *testing.T, p *ServiceProxy) *http.Transport {
+	t.Helper()
+	next := p.proxy.Transport
+	for {
+		switch transport := next.(type) {
+		case *retryTransport:
+			next = transport.next
+		case *coldStartTransport:
+			next = transport.next
+		case *timingTransport:
+			next = transport.next
+		case *http.Transport:
+			return transport
+		default:
+			t.Fatalf("unexpected transport %T", transport)
+			return nil
+		}
+	}
+}
+
+func TestResponseHeaderTimeoutPerService(t *testing.T) {
+	tests := []struct {
+		name       string
+		serviceID  string
+		serviceCfg config.ServiceProxyConfig
+		want       time.Duration
+	}{
+		{"AI service default is extended for cold starts", "greenhouse-ai", config.ServiceProxyConfig{}, 120 * time.Second},
+		{"configured AI timeout", "greenhouse-ai", config.ServiceProxyConfig{ResponseHeaderTimeout: 300 * time.Second}, 300 * time.Second},
+		{"other built-in service", "user-auth", config.ServiceProxyConfig{}, 15 * time.Second},
+		{"configured service timeout", "weather", config.ServiceProxyConfig{ResponseHeaderTimeout: 5 * time.Second}, 5 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestServiceProxy(t, "http://backend:8000", tt.serviceID, tt.serviceCfg)
+			if got := backendTransport(t, p).ResponseHeaderTimeout; got != tt.want {
+				t.Errorf("ResponseHeaderTimeout = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// slowFirstBackend delays its first response headers by delay
+func slowFirstBackend(t *testing.T, delay time.Duration) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			time.Sleep(delay)
+		}
+		_, _ = w.Write([]byte("prediction"))
+	}))
+	t.Cleanup(server.Close)
+	return server, &calls
+}
+
+func TestProxyHeaderTimeout(t *testing.T) {
+	backend, calls := slowFirstBackend(t, 300*time.Millisecond)
+	p := newTestServiceProxy(t, backend.URL, "greenhouse-ai", config.ServiceProxyConfig{ResponseHeaderTimeout: 50 * time.Millisecond})
+
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/greenhouse-ai/api/predict", nil))
+	if rec.Code != http.StatusGatewayTimeout {
+		t.Errorf("status %d, want 504 after the configured header timeout", rec.Code)
+	}
+	if calls.Load() != 1 {
+		t.Errorf("backend calls = %d, want 1 without cold-start retries", calls.Load())
+	}
+}
+
+func TestProxyRetriesColdStart(t *testing.T) {
+	backend, calls := slowFirstBackend(t, 300*time.Millisecond)
+	p := newTestServiceProxy(t, backend.URL, "greenhouse-ai", config.ServiceProxyConfig{
+		ResponseHeaderTimeout: 50 * time.Millisecond,
+		ColdStart:             config.ColdStartConfig{Enabled: true, IdleAfter: time.Minute},
+	})
+
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/greenhouse-ai/api/predict", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "prediction" {
+		t.Errorf("status %d body %q, want the retry's answer", rec.Code, rec.Body.String())
+	}
+	if calls.Load() != 2 {
+		t.Errorf("backend calls = %d, want 2", calls.Load())
+	}
+}
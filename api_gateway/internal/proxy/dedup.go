@@ -0,0 +1,201 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/canxphung/DA_CNPM_242/api_gateway/internal/config"
+	"github.com/canxphung/DA_CNPM_242/api_gateway/internal/store"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// writeDeduplicator collapses identical writes from the same client that arrive
+// while an earlier copy is in flight (or within a short window after it
+// completed) into a single backend call whose response is replayed to every copy
+type writeDeduplicator struct {
+	window       time.Duration
+	maxBodyBytes int64
+	calls        *store.Store[*dedupCall]
+}
+
+// dedupCall is one backend call shared by identical requests
+type dedupCall struct {
+	done chan struct{}
+	// response is nil when the call panicked, e.g. with http.ErrAbortHandler
+	// after the backend failed mid-response
+	response *responseRecorder
+}
+
+// newWriteDeduplicator returns nil when deduplication is disabled for the service
+func newWriteDeduplicator(cfg config.DedupConfig, serviceID string, reg prometheus.Registerer) *writeDeduplicator {
+	if !cfg.Enabled {
+		return nil
+	}
+
+	window := cfg.Window
+	if window <= 0 {
+		window = 500 * time.Millisecond
+	}
+	maxBodyBytes := cfg.MaxBodyBytes
+	if maxBodyBytes <= 0 {
+		maxBodyBytes = 64 << 10
+	}
+	maxEntries := cfg.MaxEntries
+	if maxEntries <= 0 {
+		maxEntries = 10000
+	}
+
+	return &writeDeduplicator{
+		window:       window,
+		maxBodyBytes: maxBodyBytes,
+		// In-flight calls never expire; completed ones are kept for the window
+		calls: store.New[*dedupCall]("dedup_"+serviceID, maxEntries, 0, reg),
+	}
+}
+
+// serve forwards the request through next unless an identical request is already
+// being handled, in which case it waits for and replays that response.
+// It reports whether the response was replayed from another request.
+func (d *writeDeduplicator) serve(w http.ResponseWriter, r *http.Request, next http.Handler) bool {
+	body, err := io.ReadAll(io.LimitReader(r.Body, d.maxBodyBytes+1))
+	if err != nil {
+		r.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), r.Body))
+		next.ServeHTTP(w, r)
+		return false
+	}
+
+	// Bodies above the cap are not deduplicated
+	if int64(len(body)) > d.maxBodyBytes {
+		r.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), r.Body))
+		next.ServeHTTP(w, r)
+		return false
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	key := dedupKey(r, body)
+
+	call, inFlight := d.calls.GetOrSet(key, &dedupCall{done: make(chan struct{})})
+	if inFlight {
+		select {
+		case <-call.done:
+		case <-r.Context().Done():
+			// This client went away; nothing was replayed to it
+			return false
+		}
+		if call.response == nil {
+			// The shared call failed without a response; the backend may have
+			// processed the write, so it is not sent again
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadGateway)
+			_, _ = w.Write([]byte(`{"error":"Service temporarily unavailable", "details":"duplicate of a request that failed"}`))
+			return true
+		}
+		call.response.replay(w, true)
+		return true
+	}
+
+	d.lead(key, call, r, next).replay(w, false)
+	return false
+}
+
+// lead makes the shared backend call and publishes its response to the
+// waiters. Waiters are released even if the call panics, and the entry is
+// then dropped so that later copies are not stuck behind it.
+func (d *writeDeduplicator) lead(key string, call *dedupCall, r *http.Request, next http.Handler) *responseRecorder {
+	defer func() {
+		if call.response == nil {
+			d.calls.Delete(key)
+		}
+		close(call.done)
+	}()
+
+	// The call is shared, so it outlives this client disconnecting
+	recorder := newResponseRecorder()
+	next.ServeHTTP(recorder, r.WithContext(context.WithoutCancel(r.Context())))
+	call.response = recorder
+
+	// Keep the result around briefly to absorb near-simultaneous retries
+	d.calls.SetWithTTL(key, call, d.window)
+	return recorder
+}
+
+// dedupKey identifies a write by client, credentials, target and body
+func dedupKey(r *http.Request, body []byte) string {
+	client, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		client = r.RemoteAddr
+	}
+
+	h := sha256.New()
+	for _, part := range []string{client, r.Header.Get("Authorization"), r.Header.Get("X-API-Key"), r.Method, r.URL.RequestURI()} {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// isWriteMethod reports whether the method modifies state on the backend
+func isWriteMethod(method string) bool {
+	switch method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		return true
+	default:
+		return false
+	}
+}
+
+// responseRecorder buffers a complete response so it can be replayed
+type responseRecorder struct {
+	header      http.Header
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func newResponseRecorder() *responseRecorder {
+	return &responseRecorder{header: make(http.Header), status: http.StatusOK}
+}
+
+func (rr *responseRecorder) Header() http.Header {
+	return rr.header
+}
+
+func (rr *responseRecorder) WriteHeader(code int) {
+	// Informational responses are not replayed
+	if code < http.StatusOK || rr.wroteHeader {
+		return
+	}
+	rr.status = code
+	rr.wroteHeader = true
+}
+
+func (rr *responseRecorder) Write(data []byte) (int, error) {
+	rr.wroteHeader = true
+	return rr.body.Write(data)
+}
+
+// Flush implements the http.Flusher interface; the response is buffered anyway
+func (rr *responseRecorder) Flush() {}
+
+// replay writes the recorded response to a client
+func (rr *responseRecorder) replay(w http.ResponseWriter, deduplicated bool) {
+	for key, values := range rr.header {
+		// Each client keeps its own request ID
+		if key == "X-Request-Id" {
+			continue
+		}
+		w.Header()[key] = values
+	}
+	if deduplicated {
+		w.Header().Set("X-Deduplicated", "true")
+	}
+	w.WriteHeader(rr.status)
+	_, _ = w.Write(rr.body.Bytes())
+}
@@ -0,0 +1,221 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/canxphung/DA_CNPM_242/api_gateway/internal/config"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func newTestDeduplicator(t *testing.T) *writeDeduplicator {
+	t.Helper()
+	return newWriteDeduplicator(config.DedupConfig{Enabled: true, Window: time.Second}, "test", prometheus.NewRegistry())
+}
+
+func newDedupRequest(ctx context.Context) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/core-operation/plants", strings.NewReader(`{"name":"basil"}`))
+	req.RemoteAddr = "192.0.2.1:1234"
+	return req.WithContext(ctx)
+}
+
+// blockingBackend counts calls and holds each one until release is closed
+type blockingBackend struct {
+	calls   atomic.Int32
+	entered chan struct{}
+	release chan struct{}
+	// canceled records whether a call saw its context cancelled
+	canceled atomic.Bool
+	// panicWith, when set, is raised instead of answering
+	panicWith interface{}
+}
+
+func newBlockingBackend() *blockingBackend {
+	return &blockingBackend{entered: make(chan struct{}, 10), release: make(chan struct{})}
+}
+
+func (b *blockingBackend) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b.calls.Add(1)
+	b.entered <- struct{}{}
+	<-b.release
+	if r.Context().Err() != nil {
+		b.canceled.Store(true)
+	}
+	if b.panicWith != nil {
+		panic(b.panicWith)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_, _ = w.Write([]byte(`{"id":1}`))
+}
+
+type dedupResult struct {
+	deduplicated bool
+	recorder     *httptest.ResponseRecorder
+	panicked     interface{}
+}
+
+// serveAsync runs serve in a goroutine, recovering a propagated panic
+func serveAsync(d *writeDeduplicator, req *http.Request, next http.Handler) <-chan dedupResult {
+	results := make(chan dedupResult, 1)
+	go func() {
+		result := dedupResult{recorder: httptest.NewRecorder()}
+		defer func() {
+			result.panicked = recover()
+			results <- result
+		}()
+		result.deduplicated = d.serve(result.recorder, req, next)
+	}()
+	return results
+}
+
+func waitResult(t *testing.T, results <-chan dedupResult) dedupResult {
+	t.Helper()
+	select {
+	case result := <-results:
+		return result
+	case <-time.After(2 * time.Second):
+		t.Fatal("request did not complete")
+		return dedupResult{}
+	}
+}
+
+// waitForWaiters gives the duplicates time to find the in-flight call
+func waitForWaiters() {
+	time.Sleep(50 * time.Millisecond)
+}
+
+func TestDedupConcurrentDuplicates(t *testing.T) {
+	d := newTestDeduplicator(t)
+	backend := newBlockingBackend()
+
+	leader := serveAsync(d, newDedupRequest(context.Background()), backend)
+	<-backend.entered
+
+	var waiters []<-chan dedupResult
+	for i := 0; i < 3; i++ {
+		waiters = append(waiters, serveAsync(d, newDedupRequest(context.Background()), backend))
+	}
+	waitForWaiters()
+	close(backend.release)
+
+	result := waitResult(t, leader)
+	if result.deduplicated || result.recorder.Code != http.StatusCreated {
+		t.Fatalf("leader: deduplicated=%v status=%d", result.deduplicated, result.recorder.Code)
+	}
+	for i, waiter := range waiters {
+		result := waitResult(t, waiter)
+		if !result.deduplicated || result.recorder.Code != http.StatusCreated || result.recorder.Body.String() != `{"id":1}` {
+			t.Errorf("waiter %d: deduplicated=%v status=%d body=%q", i, result.deduplicated, result.recorder.Code, result.recorder.Body.String())
+		}
+		if result.recorder.Header().Get("X-Deduplicated") != "true" {
+			t.Errorf("waiter %d: missing X-Deduplicated", i)
+		}
+	}
+	if calls := backend.calls.Load(); calls != 1 {
+		t.Errorf("backend calls = %d, want 1", calls)
+	}
+}
+
+func TestDedupLeaderPanic(t *testing.T) {
+	d := newTestDeduplicator(t)
+	backend := newBlockingBackend()
+	backend.panicWith = http.ErrAbortHandler
+
+	leader := serveAsync(d, newDedupRequest(context.Background()), backend)
+	<-backend.entered
+	waiter := serveAsync(d, newDedupRequest(context.Background()), backend)
+	waitForWaiters()
+	close(backend.release)
+
+	if result := waitResult(t, leader); result.panicked != http.ErrAbortHandler {
+		t.Errorf("leader panic = %v, want http.ErrAbortHandler to propagate", result.panicked)
+	}
+	result := waitResult(t, waiter)
+	if !result.deduplicated || result.recorder.Code != http.StatusBadGateway {
+		t.Errorf("waiter: deduplicated=%v status=%d, want a 502", result.deduplicated, result.recorder.Code)
+	}
+
+	// The failed call must not block later copies
+	backend.panicWith = nil
+	result = waitResult(t, serveAsync(d, newDedupRequest(context.Background()), backend))
+	if result.deduplicated || result.recorder.Code != http.StatusCreated {
+		t.Errorf("after panic: deduplicated=%v status=%d", result.deduplicated, result.recorder.Code)
+	}
+	if calls := backend.calls.Load(); calls != 2 {
+		t.Errorf("backend calls = %d, want 2", calls)
+	}
+}
+
+func TestDedupWaiterCancelled(t *testing.T) {
+	d := newTestDeduplicator(t)
+	backend := newBlockingBackend()
+
+	leader := serveAsync(d, newDedupRequest(context.Background()), backend)
+	<-backend.entered
+
+	ctx, cancel := context.WithCancel(context.Background())
+	waiter := serveAsync(d, newDedupRequest(ctx), backend)
+	waitForWaiters()
+	cancel()
+
+	if result := waitResult(t, waiter); result.deduplicated {
+		t.Error("a cancelled waiter was counted as deduplicated")
+	}
+
+	close(backend.release)
+	waitResult(t, leader)
+}
+
+func TestDedupLeaderCancelDoesNotAbortSharedCall(t *testing.T) {
+	d := newTestDeduplicator(t)
+	backend := newBlockingBackend()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	leader := serveAsync(d, newDedupRequest(ctx), backend)
+	<-backend.entered
+	waiter := serveAsync(d, newDedupRequest(context.Background()), backend)
+	waitForWaiters()
+
+	cancel()
+	close(backend.release)
+	waitResult(t, leader)
+
+	result := waitResult(t, waiter)
+	if result.recorder.Code != http.StatusCreated {
+		t.Errorf("waiter status = %d, want the backend's 201", result.recorder.Code)
+	}
+	if backend.canceled.Load() {
+		t.Error("the shared call saw the leader's cancellation")
+	}
+}
+
+func TestDedupDistinctBodiesNotCollapsed(t *testing.T) {
+	d := newTestDeduplicator(t)
+	var calls atomic.Int32
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	var wg sync.WaitGroup
+	for _, body := range []string{`{"name":"basil"}`, `{"name":"mint"}`} {
+		wg.Add(1)
+		go func(body string) {
+			defer wg.Done()
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/core-operation/plants", strings.NewReader(body))
+			d.serve(httptest.NewRecorder(), req, next)
+		}(body)
+	}
+	wg.Wait()
+
+	if calls.Load() != 2 {
+		t.Errorf("backend calls = %d, want 2", calls.Load())
+	}
+}
@@ -0,0 +1,99 @@
+package proxy
+
+import (
+	"github.com/canxphung/DA_CNPM_242/api_gateway/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Metrics holds the Prometheus collectors shared by all service proxies
+type Metrics struct {
+	versionRequests      *prometheus.CounterVec
+	targetRequests       *prometheus.CounterVec
+	deduplicatedRequests *prometheus.CounterVec
+	activeStreams        *prometheus.GaugeVec
+	rejectedStreams      *prometheus.CounterVec
+	retriedRequests      *prometheus.CounterVec
+	upstreamDuration     *prometheus.HistogramVec
+	// registry is kept for collectors owned by individual proxies (e.g. their stores)
+	registry prometheus.Registerer
+}
+
+// NewMetrics creates the proxy metrics and registers them with the registry
+func NewMetrics(reg prometheus.Registerer) *Metrics {
+	const namespace = "api_gateway"
+
+	versionRequests := metrics.RegisterOrReuse(reg, prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "proxy_version_requests_total",
+			Help:      "Total number of proxied requests by service and routed API version",
+		},
+		[]string{"service", "version"},
+	))
+
+	targetRequests := metrics.RegisterOrReuse(reg, prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "proxy_target_requests_total",
+			Help:      "Total number of proxied requests by service and weighted target (stable or canary)",
+		},
+		[]string{"service", "target"},
+	))
+
+	deduplicatedRequests := metrics.RegisterOrReuse(reg, prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "proxy_deduplicated_requests_total",
+			Help:      "Total number of duplicate writes answered from an identical in-flight request",
+		},
+		[]string{"service"},
+	))
+
+	activeStreams := metrics.RegisterOrReuse(reg, prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "proxy_active_streams",
+			Help:      "Current number of streaming sessions open to each backend service",
+		},
+		[]string{"service"},
+	))
+
+	rejectedStreams := metrics.RegisterOrReuse(reg, prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "proxy_streams_rejected_total",
+			Help:      "Total number of streaming sessions rejected at the per-service cap",
+		},
+		[]string{"service"},
+	))
+
+	retriedRequests := metrics.RegisterOrReuse(reg, prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "proxy_retried_requests_total",
+			Help:      "Total number of proxied requests that were retried, by service and number of retries",
+		},
+		[]string{"service", "retries"},
+	))
+
+	upstreamDuration := metrics.RegisterOrReuse(reg, prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "upstream_duration_seconds",
+			Help:      "Time from sending a request to a backend until its response headers arrive, by service and status class",
+			Buckets:   prometheus.DefBuckets,
+		},
+		[]string{"service", "status_class"},
+	))
+
+	return &Metrics{
+		versionRequests:      versionRequests,
+		targetRequests:       targetRequests,
+		deduplicatedRequests: deduplicatedRequests,
+		activeStreams:        activeStreams,
+		rejectedStreams:      rejectedStreams,
+		retriedRequests:      retriedRequests,
+		upstreamDuration:     upstreamDuration,
+		registry:             reg,
+	}
+}
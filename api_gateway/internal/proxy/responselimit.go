@@ -0,0 +1,40 @@
+package proxy
+
+import (
+	"errors"
+	"io"
+)
+
+// errResponseTooLarge aborts a backend response that exceeds the service's size cap
+var errResponseTooLarge = errors.New("backend response exceeds the size limit")
+
+// limitedBody fails the read that would take the body past limit bytes,
+// which makes the reverse proxy abort the response to the client
+type limitedBody struct {
+	io.ReadCloser
+	remaining int64
+	onExceed  func()
+}
+
+func newLimitedBody(body io.ReadCloser, limit int64, onExceed func()) *limitedBody {
+	return &limitedBody{ReadCloser: body, remaining: limit, onExceed: onExceed}
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	// Read one byte past the limit so an exact-size body is not rejected
+	if int64(len(p)) > b.remaining+1 {
+		p = p[:b.remaining+1]
+	}
+	n, err := b.ReadCloser.Read(p)
+	if int64(n) > b.remaining {
+		n = int(b.remaining)
+		b.remaining = 0
+		if b.onExceed != nil {
+			b.onExceed()
+			b.onExceed = nil
+		}
+		return n, errResponseTooLarge
+	}
+	b.remaining -= int64(n)
+	return n, err
+}
@@ -0,0 +1,118 @@
+package proxy
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/canxphung/DA_CNPM_242/api_gateway/internal/config"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// newSizedBackend answers with ?size= bytes of body, declaring the length
+// when ?declared is set and streaming it chunked otherwise
+func newSizedBackend(t *testing.T) *httptest.Server {
+	t.Helper()
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		size, _ := strconv.Atoi(r.URL.Query().Get("size"))
+		if contentType := r.URL.Query().Get("type"); contentType != "" {
+			w.Header().Set("Content-Type", contentType)
+		}
+		if r.URL.Query().Has("declared") {
+			w.Header().Set("Content-Length", strconv.Itoa(size))
+		}
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		_, _ = w.Write([]byte(strings.Repeat("x", size)))
+	}))
+	t.Cleanup(backend.Close)
+	return backend
+}
+
+func TestProxyResponseSizeCap(t *testing.T) {
+	backend := newSizedBackend(t)
+
+	tests := []struct {
+		name          string
+		query         string
+		limitStreams  bool
+		wantStatus    int
+		wantBody      int
+		wantAborted   bool
+		wantLoggedErr string
+	}{
+		{"under the cap", "size=512", false, http.StatusOK, 512, false, ""},
+		{"exactly the cap", "size=1024", false, http.StatusOK, 1024, false, ""},
+		{"declared length over the cap", "size=4096&declared", false, http.StatusBadGateway, -1, false, "Backend response exceeds size limit"},
+		{"streamed body over the cap", "size=4096", false, http.StatusOK, -1, true, "Backend response exceeded size limit, aborting"},
+		{"event stream is exempt", "size=4096&type=text/event-stream", false, http.StatusOK, 4096, false, ""},
+		{"event stream capped when configured", "size=4096&type=text/event-stream", true, http.StatusOK, -1, true, "Backend response exceeded size limit, aborting"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			core, logs := observer.New(zapcore.ErrorLevel)
+			p, _ := newTestProxy(t, backend.URL, "core-operations", &config.ProxyConfig{
+				Services: map[string]config.ServiceProxyConfig{"core-operations": {MaxResponseBytes: 1024, LimitStreamingResponses: tt.limitStreams}},
+			}, zap.New(core))
+			// Record how the handler ended, then let the server handle it as usual
+			var recovered interface{}
+			gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				defer func() {
+					if recovered = recover(); recovered != nil {
+						panic(recovered)
+					}
+				}()
+				p.ServeHTTP(w, r)
+			}))
+			defer gateway.Close()
+
+			resp, err := http.Get(gateway.URL + "/api/v1/core-operations/export?" + tt.query)
+			if err != nil {
+				t.Fatalf("Get: %v", err)
+			}
+			body, readErr := io.ReadAll(resp.Body)
+			resp.Body.Close()
+
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if tt.wantBody >= 0 && len(body) != tt.wantBody {
+				t.Errorf("client got %d bytes, want %d", len(body), tt.wantBody)
+			}
+
+			gateway.Close()
+			if tt.wantAborted {
+				// The handler aborts and the connection is cut rather than the body
+				// ending cleanly, so the client cannot take a truncated response for
+				// a complete one
+				if recovered != http.ErrAbortHandler {
+					t.Errorf("handler ended with %v, want http.ErrAbortHandler", recovered)
+				}
+				if !errors.Is(readErr, io.ErrUnexpectedEOF) {
+					t.Errorf("read error %v after %d bytes, want the response aborted", readErr, len(body))
+				}
+			} else if recovered != nil || readErr != nil {
+				t.Errorf("handler panic %v, read error %v: want a complete response", recovered, readErr)
+			}
+			entries := logs.All()
+			if tt.wantLoggedErr == "" {
+				if len(entries) != 0 {
+					t.Errorf("unexpected error logs: %v", entries)
+				}
+				return
+			}
+			if logs.FilterMessage(tt.wantLoggedErr).Len() != 1 {
+				t.Fatalf("error logs %v, want %q", entries, tt.wantLoggedErr)
+			}
+			if fields := logs.FilterMessage(tt.wantLoggedErr).All()[0].ContextMap(); fields["service"] != "core-operations" || fields["path"] != "/export" {
+				t.Errorf("log fields %v, want the service and path", fields)
+			}
+		})
+	}
+}
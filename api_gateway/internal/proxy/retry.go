@@ -0,0 +1,169 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/canxphung/DA_CNPM_242/api_gateway/internal/config"
+	"github.com/canxphung/DA_CNPM_242/api_gateway/internal/middleware"
+	"go.uber.org/zap"
+)
+
+// retryTransport replays safe requests that failed with a connection error or
+// a 502/503/504, waiting an exponentially growing, jittered delay between attempts.
+type retryTransport struct {
+	next      http.RoundTripper
+	cfg       config.RetryConfig
+	serviceID string
+	metrics   *Metrics
+	logger    *zap.Logger
+}
+
+// newRetryTransport wraps next, or returns it unchanged when retries are disabled
+func newRetryTransport(next http.RoundTripper, cfg config.RetryConfig, serviceID string, metrics *Metrics, logger *zap.Logger) http.RoundTripper {
+	if cfg.MaxRetries == 0 {
+		return next
+	}
+	return &retryTransport{
+		next:      next,
+		cfg:       cfg,
+		serviceID: serviceID,
+		metrics:   metrics,
+		logger:    logger,
+	}
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	if !middleware.IsSafeRequest(ctx) {
+		return t.next.RoundTrip(req)
+	}
+
+	req, replayable, err := t.bufferBody(req)
+	if err != nil {
+		return nil, err
+	}
+	if !replayable {
+		return t.next.RoundTrip(req)
+	}
+
+	for attempt := 0; ; attempt++ {
+		attemptReq := req
+		if attempt > 0 {
+			attemptReq = req.Clone(ctx)
+			if req.GetBody != nil {
+				if attemptReq.Body, err = req.GetBody(); err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		resp, err := t.next.RoundTrip(attemptReq)
+		if attempt == t.cfg.MaxRetries || ctx.Err() != nil || !shouldRetry(resp, err) {
+			t.recordRetries(ctx, attempt)
+			return resp, err
+		}
+
+		fields := []zap.Field{
+			zap.String("service", t.serviceID),
+			zap.String("method", req.Method),
+			zap.String("path", req.URL.Path),
+			zap.Int("attempt", attempt+1),
+		}
+		if err != nil {
+			fields = append(fields, zap.Error(err))
+		} else {
+			fields = append(fields, zap.Int("status", resp.StatusCode))
+			// Drain a little so the connection can be reused
+			_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+			resp.Body.Close()
+		}
+		t.logger.Warn("Backend request failed, retrying", fields...)
+
+		timer := time.NewTimer(t.backoff(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			t.recordRetries(ctx, attempt)
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// bufferBody makes the request body replayable by reading it into memory.
+// Bodies over MaxBodyBytes are passed through unbuffered and not retried.
+func (t *retryTransport) bufferBody(req *http.Request) (*http.Request, bool, error) {
+	if canReplay(req) {
+		return req, true, nil
+	}
+
+	buffered, err := io.ReadAll(io.LimitReader(req.Body, t.cfg.MaxBodyBytes+1))
+	if err != nil {
+		req.Body.Close()
+		return nil, false, err
+	}
+
+	req = req.Clone(req.Context())
+	if int64(len(buffered)) > t.cfg.MaxBodyBytes {
+		// Too large to replay: send what was read followed by the rest
+		req.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(buffered), req.Body), req.Body}
+		return req, false, nil
+	}
+
+	req.Body.Close()
+	req.Body = io.NopCloser(bytes.NewReader(buffered))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(buffered)), nil
+	}
+	return req, true, nil
+}
+
+// backoff returns the delay before retry number attempt+1: BaseDelay doubled
+// per previous retry, capped at MaxDelay, with the upper half randomised so
+// clients failing together do not retry together
+func (t *retryTransport) backoff(attempt int) time.Duration {
+	delay := t.cfg.BaseDelay << attempt
+	if delay > t.cfg.MaxDelay || delay <= 0 {
+		delay = t.cfg.MaxDelay
+	}
+	half := delay / 2
+	if half <= 0 {
+		return delay
+	}
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+// recordRetries counts a request that needed retries and notes them in its trace
+func (t *retryTransport) recordRetries(ctx context.Context, retries int) {
+	if retries == 0 {
+		return
+	}
+	t.metrics.retriedRequests.WithLabelValues(t.serviceID, strconv.Itoa(retries)).Inc()
+	if trace := traceFromContext(ctx); trace != nil {
+		trace.retries = retries
+	}
+}
+
+// shouldRetry reports whether an attempt failed in a way another attempt may fix:
+// the connection failed, or a proxy/overloaded backend answered 502, 503 or 504.
+// Timeouts and cancellations are not retried; the backend may still be working.
+func shouldRetry(resp *http.Response, err error) bool {
+	if err != nil {
+		return !isHeaderTimeout(err) && !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+	}
+	switch resp.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
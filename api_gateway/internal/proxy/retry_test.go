@@ -0,0 +1,248 @@
+package proxy
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/canxphung/DA_CNPM_242/api_gateway/internal/config"
+	"github.com/canxphung/DA_CNPM_242/api_gateway/internal/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+func testRetryConfig() config.RetryConfig {
+	return config.RetryConfig{MaxRetries: 2, BaseDelay: time.Millisecond, MaxDelay: 5 * time.Millisecond, MaxBodyBytes: 1024}
+}
+
+// flakyBackend fails the first failures requests with failStatus, then answers 200
+type flakyBackend struct {
+	failures   int32
+	failStatus int
+	calls      atomic.Int32
+	bodies     []string
+}
+
+func (b *flakyBackend) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	b.bodies = append(b.bodies, string(body))
+	if b.calls.Add(1) <= b.failures {
+		w.WriteHeader(b.failStatus)
+		return
+	}
+	_, _ = w.Write([]byte("ok"))
+}
+
+// roundTripFunc adapts a function to http.RoundTripper
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
+
+// sendThroughRetry sends a request through a retry transport, marked safe or not
+func sendThroughRetry(t *testing.T, transport http.RoundTripper, method, url, body string, safe bool) *http.Response {
+	t.Helper()
+	var reader io.Reader
+	if body != "" {
+		// Hide the concrete type, so the body is not replayable by itself
+		reader = io.MultiReader(strings.NewReader(body))
+	}
+	req, err := http.NewRequest(method, url, reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req = req.WithContext(middleware.WithSafeRequest(req.Context(), safe))
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("RoundTrip: %v", err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func TestRetryFlakyBackend(t *testing.T) {
+	backend := &flakyBackend{failures: 1, failStatus: http.StatusServiceUnavailable}
+	server := httptest.NewServer(backend)
+	defer server.Close()
+
+	reg := prometheus.NewRegistry()
+	transport := newRetryTransport(http.DefaultTransport, testRetryConfig(), "core-operations", NewMetrics(reg), zap.NewNop())
+
+	resp := sendThroughRetry(t, transport, http.MethodGet, server.URL+"/plants", "", true)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status %d, want 200 after one retry", resp.StatusCode)
+	}
+	if calls := backend.calls.Load(); calls != 2 {
+		t.Errorf("backend calls = %d, want 2", calls)
+	}
+	if retried := counterValue(t, reg, "api_gateway_proxy_retried_requests_total", map[string]string{"service": "core-operations", "retries": "1"}); retried != 1 {
+		t.Errorf("retried_requests_total{retries=1} = %v, want 1", retried)
+	}
+}
+
+func TestRetryStopsAtMaxRetries(t *testing.T) {
+	backend := &flakyBackend{failures: 100, failStatus: http.StatusBadGateway}
+	server := httptest.NewServer(backend)
+	defer server.Close()
+
+	transport := newRetryTransport(http.DefaultTransport, testRetryConfig(), "core-operations", NewMetrics(prometheus.NewRegistry()), zap.NewNop())
+
+	if resp := sendThroughRetry(t, transport, http.MethodGet, server.URL, "", true); resp.StatusCode != http.StatusBadGateway {
+		t.Errorf("status %d, want the last 502", resp.StatusCode)
+	}
+	if calls := backend.calls.Load(); calls != 3 {
+		t.Errorf("backend calls = %d, want 1 + 2 retries", calls)
+	}
+}
+
+func TestRetrySkipsUnsafeAndClientErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		safe       bool
+		failStatus int
+	}{
+		{"unsafe POST", http.MethodPost, false, http.StatusServiceUnavailable},
+		{"GET answered 404", http.MethodGet, true, http.StatusNotFound},
+		{"GET answered 429", http.MethodGet, true, http.StatusTooManyRequests},
+		{"GET answered 500", http.MethodGet, true, http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := &flakyBackend{failures: 1, failStatus: tt.failStatus}
+			server := httptest.NewServer(backend)
+			defer server.Close()
+			transport := newRetryTransport(http.DefaultTransport, testRetryConfig(), "core-operations", NewMetrics(prometheus.NewRegistry()), zap.NewNop())
+
+			if resp := sendThroughRetry(t, transport, tt.method, server.URL, "", tt.safe); resp.StatusCode != tt.failStatus {
+				t.Errorf("status %d, want %d passed through", resp.StatusCode, tt.failStatus)
+			}
+			if calls := backend.calls.Load(); calls != 1 {
+				t.Errorf("backend calls = %d, want 1", calls)
+			}
+		})
+	}
+}
+
+func TestRetryConnectionError(t *testing.T) {
+	var calls atomic.Int32
+	next := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		if calls.Add(1) == 1 {
+			return nil, errors.New("connection refused")
+		}
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody, Request: req}, nil
+	})
+	transport := newRetryTransport(next, testRetryConfig(), "greenhouse-ai", NewMetrics(prometheus.NewRegistry()), zap.NewNop())
+
+	if resp := sendThroughRetry(t, transport, http.MethodGet, "http://greenhouse-ai/models", "", true); resp.StatusCode != http.StatusOK {
+		t.Errorf("status %d, want 200", resp.StatusCode)
+	}
+	if calls.Load() != 2 {
+		t.Errorf("calls = %d, want 2", calls.Load())
+	}
+}
+
+func TestRetryReplaysBufferedBody(t *testing.T) {
+	backend := &flakyBackend{failures: 1, failStatus: http.StatusServiceUnavailable}
+	server := httptest.NewServer(backend)
+	defer server.Close()
+	transport := newRetryTransport(http.DefaultTransport, testRetryConfig(), "core-operations", NewMetrics(prometheus.NewRegistry()), zap.NewNop())
+
+	// A safe-route POST, e.g. a query endpoint
+	if resp := sendThroughRetry(t, transport, http.MethodPost, server.URL+"/query", `{"q":"humidity"}`, true); resp.StatusCode != http.StatusOK {
+		t.Fatalf("status %d, want 200", resp.StatusCode)
+	}
+	if len(backend.bodies) != 2 || backend.bodies[0] != `{"q":"humidity"}` || backend.bodies[1] != backend.bodies[0] {
+		t.Errorf("bodies = %q, want the same body twice", backend.bodies)
+	}
+}
+
+func TestRetryLargeBodyIsNotRetried(t *testing.T) {
+	backend := &flakyBackend{failures: 1, failStatus: http.StatusServiceUnavailable}
+	server := httptest.NewServer(backend)
+	defer server.Close()
+	transport := newRetryTransport(http.DefaultTransport, testRetryConfig(), "core-operations", NewMetrics(prometheus.NewRegistry()), zap.NewNop())
+
+	large := strings.Repeat("x", 4096)
+	if resp := sendThroughRetry(t, transport, http.MethodPost, server.URL+"/query", large, true); resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("status %d, want the 503 passed through", resp.StatusCode)
+	}
+	if len(backend.bodies) != 1 || backend.bodies[0] != large {
+		t.Errorf("backend got %d bodies, want the whole body once", len(backend.bodies))
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	transport := &retryTransport{cfg: config.RetryConfig{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}}
+
+	for attempt, want := range []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 800 * time.Millisecond, time.Second, time.Second} {
+		for i := 0; i < 20; i++ {
+			if delay := transport.backoff(attempt); delay < want/2 || delay > want {
+				t.Errorf("backoff(%d) = %v, want within [%v, %v]", attempt, delay, want/2, want)
+			}
+		}
+	}
+}
+
+func TestSafeRoutesAreRetriedAndCached(t *testing.T) {
+	backend := &flakyBackend{}
+	server := httptest.NewServer(backend)
+	defer server.Close()
+
+	cfg := &config.ProxyConfig{
+		Services:   map[string]config.ServiceProxyConfig{"greenhouse-ai": {}},
+		Retry:      testRetryConfig(),
+		SafeRoutes: []config.SafeRoute{{PathPrefix: "/api/v1/greenhouse-ai/api/query", Methods: []string{"POST"}}},
+	}
+	cors := middleware.NewCORSMiddleware(&config.CORSConfig{}, zap.NewNop())
+	overload := middleware.NewOverloadResponder(&config.OverloadConfig{RateLimitStatus: http.StatusTooManyRequests, CapacityStatus: http.StatusServiceUnavailable})
+	p, err := NewServiceProxy(server.URL, "greenhouse-ai", cfg, NewMetrics(prometheus.NewRegistry()), overload, cors, nil, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache := middleware.NewCacheMiddleware(&config.CacheConfig{
+		MaxEntries:   100,
+		MaxBodyBytes: 1024,
+		Routes:       []config.CacheRoute{{PathPrefix: "/api/v1/greenhouse-ai/api", TTL: time.Minute, Shared: true}},
+	}, prometheus.NewRegistry(), zap.NewNop())
+	// The same chain as main: the policy marks the request before the cache sees it
+	handler := middleware.NewSafeMethodPolicy(cfg.SafeRoutes).MarkSafeRequests(cache.CacheResponses(p))
+
+	post := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, path, strings.NewReader(`{"q":"humidity"}`)))
+		return rec
+	}
+
+	tests := []struct {
+		name      string
+		path      string
+		wantFirst int
+		wantCache string
+		wantCalls int32
+	}{
+		{"declared-safe POST", "/api/v1/greenhouse-ai/api/query", http.StatusOK, "HIT", 2},
+		{"undeclared POST", "/api/v1/greenhouse-ai/api/predict", http.StatusServiceUnavailable, "", 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend.calls.Store(0)
+			backend.failures = 1
+			backend.failStatus = http.StatusServiceUnavailable
+
+			if rec := post(tt.path); rec.Code != tt.wantFirst {
+				t.Errorf("first POST: status %d, want %d", rec.Code, tt.wantFirst)
+			}
+			if rec := post(tt.path); rec.Header().Get("X-Cache") != tt.wantCache {
+				t.Errorf("second POST: X-Cache %q, want %q", rec.Header().Get("X-Cache"), tt.wantCache)
+			}
+			if calls := backend.calls.Load(); calls != tt.wantCalls {
+				t.Errorf("backend calls = %d, want %d", calls, tt.wantCalls)
+			}
+		})
+	}
+}
@@ -1,6 +1,8 @@
 package proxy
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
@@ -9,168 +11,185 @@ import (
 	"strings"
 	"time"
 
+	"github.com/canxphung/DA_CNPM_242/api_gateway/internal/config"
+	"github.com/canxphung/DA_CNPM_242/api_gateway/internal/health"
+	"github.com/canxphung/DA_CNPM_242/api_gateway/internal/middleware"
 	"go.uber.org/zap"
 )
 
+// statusClientClosedRequest is recorded when the client disconnects before the
+// backend answers (non-standard, as used by nginx)
+const statusClientClosedRequest = 499
+
 // ServiceProxy handles proxying requests to backend services
 type ServiceProxy struct {
-	target    *url.URL
-	proxy     *httputil.ReverseProxy
-	logger    *zap.Logger
-	serviceID string
+	target     *url.URL
+	targets    *targetSelector
+	targetUp   func(weightedTarget) bool
+	proxy      *httputil.ReverseProxy
+	logger     *zap.Logger
+	serviceID  string
+	traceLevel string
+	dedup      *writeDeduplicator
+	streams    *streamCap
+	safe       *middleware.SafeMethodPolicy
+	metrics    *Metrics
+	overload   *middleware.OverloadResponder
+	cors       *middleware.CORSMiddleware
+	health     *health.Checker
 }
 
 // NewServiceProxy creates a new service proxy
-func NewServiceProxy(targetURL string, serviceID string, logger *zap.Logger) (*ServiceProxy, error) {
+func NewServiceProxy(targetURL string, serviceID string, cfg *config.ProxyConfig, metrics *Metrics, overload *middleware.OverloadResponder, cors *middleware.CORSMiddleware, checker *health.Checker, logger *zap.Logger) (*ServiceProxy, error) {
 	logger.Info("Creating service proxy",
 		zap.String("target_url", targetURL),
 		zap.String("service_id", serviceID))
 
-	// Validate serviceID
-	validServiceIDs := map[string]bool{
-		"user-auth":       true,
-		"auth":            true,
-		"core-operations": true,
-		"core-operation":  true,
-		"greenhouse-ai":   true,
-	}
-
-	if _, isValid := validServiceIDs[serviceID]; !isValid {
+	// Validate serviceID: every configured service has proxy settings
+	if _, isValid := cfg.Services[serviceID]; !isValid {
 		return nil, fmt.Errorf("invalid service ID: %s", serviceID)
 	}
 
-	target, err := url.Parse(targetURL)
+	targets, err := newTargetSelector(targetURL)
 	if err != nil {
 		logger.Error("Failed to parse target URL",
 			zap.String("target_url", targetURL),
 			zap.Error(err))
-		return nil, fmt.Errorf("failed to parse target URL: %w", err)
+		return nil, err
+	}
+	target := targets.stable().url
+
+	for _, weighted := range targets.targets {
+		logger.Info("Target URL parsed successfully",
+			zap.String("target", weighted.name),
+			zap.String("scheme", weighted.url.Scheme),
+			zap.String("host", weighted.url.Host),
+			zap.String("path", weighted.url.Path),
+			zap.Int("weight", weighted.weight))
 	}
 
-	logger.Info("Target URL parsed successfully",
-		zap.String("scheme", target.Scheme),
-		zap.String("host", target.Host),
-		zap.String("path", target.Path))
+	// Targets failing their health checks leave the rotation
+	targetUp := func(weighted weightedTarget) bool {
+		return checker == nil || checker.IsUp(healthName(serviceID, weighted))
+	}
 
 	proxy := httputil.NewSingleHostReverseProxy(target)
 
+	rewriter, err := newPathRewriter(serviceID, cfg.Services[serviceID].Rewrite)
+	if err != nil {
+		return nil, err
+	}
+
+	versions, err := newVersionRouter(cfg.Services[serviceID].Versioning, target)
+	if err != nil {
+		return nil, err
+	}
+
+	// Identify gateway traffic to the backend
+	userAgent := cfg.UserAgent
+	if override := cfg.Services[serviceID].UserAgent; override != "" {
+		userAgent = override
+	}
+	userAgent = strings.ReplaceAll(userAgent, "{service}", serviceID)
+
 	// Set buffer pool for better memory management
 	proxy.BufferPool = newBufferPool()
 
 	// Customize the director to modify the request before sending it to the backend
 	originalDirector := proxy.Director
 	proxy.Director = func(req *http.Request) {
-		logger.Debug("Proxy Director: Processing request",
-			zap.String("service", serviceID),
-			zap.String("original_path", req.URL.Path),
-			zap.String("method", req.Method))
-
-		logger.Debug("PROXY_DIRECTOR_ENTRY", zap.String("service", serviceID), zap.String("original_client_path", req.URL.Path))
-
 		// Call original director
 		originalDirector(req)
 
-		req.URL.Scheme = target.Scheme
-		req.URL.Host = target.Host
+		// Split traffic between the stable and canary targets
+		trace := traceFromContext(req.Context())
+		requestID := ""
+		if trace != nil {
+			requestID = trace.requestID
+		}
+		chosen := targets.pick(req, requestID, targetUp)
+		metrics.targetRequests.WithLabelValues(serviceID, chosen.name).Inc()
+
+		req.URL.Scheme = chosen.url.Scheme
+		req.URL.Host = chosen.url.Host
 		req.Header.Set("X-Backend-CORS-Handled", "true")
 
 		originalPath := req.URL.Path
-		proxiedPath := originalPath
-		logger.Debug("PROXY_DIRECTOR_AFTER_ORIGINAL",
-			zap.String("service", serviceID),
-			zap.String("path_after_originalDirector", req.URL.Path),
-			zap.String("target_scheme", target.Scheme),
-			zap.String("target_host", target.Host),
-		)
 
-		// Remove /api/v1
+		// Remove /api/v1 and map the rest to the backend's layout
 		const gatewayAPIPrefix = "/api/v1"
-		proxiedPath = strings.TrimPrefix(proxiedPath, gatewayAPIPrefix)
-
-		// Normalize path to avoid multiple leading slashes
-		proxiedPath = "/" + strings.TrimLeft(proxiedPath, "/")
-
-		// Process path based on serviceID
-		switch serviceID {
-		case "user-auth":
-			servicePrefix := "/" + serviceID
-			proxiedPath = strings.TrimPrefix(proxiedPath, servicePrefix)
-			if strings.HasPrefix(proxiedPath, "/users/") {
-				req.URL.Path = "/api/v1" + proxiedPath
-			} else {
-				req.URL.Path = gatewayAPIPrefix + proxiedPath
-			}
-
-		case "auth":
-			req.URL.Path = gatewayAPIPrefix + proxiedPath
-
-		case "core-operation", "core-operations":
-			servicePrefix := "/" + serviceID
-			proxiedPath = strings.TrimPrefix(proxiedPath, servicePrefix)
-			if !strings.HasPrefix(proxiedPath, "/api/") &&
-				!strings.HasPrefix(proxiedPath, "/health") &&
-				!strings.HasPrefix(proxiedPath, "/version") &&
-				!strings.HasPrefix(proxiedPath, "/docs") {
-				req.URL.Path = "/api" + proxiedPath
-			} else {
-				req.URL.Path = proxiedPath
-			}
-
-		case "greenhouse-ai":
-			servicePrefix := "/" + serviceID
-			proxiedPath = strings.TrimPrefix(proxiedPath, servicePrefix)
-			if !strings.HasPrefix(proxiedPath, "/api") &&
-				!strings.HasPrefix(proxiedPath, "/health") &&
-				!strings.HasPrefix(proxiedPath, "/docs") {
-				req.URL.Path = "/api" + proxiedPath
-			} else {
-				req.URL.Path = proxiedPath
-			}
-
-		default:
-			logger.Warn("Unknown service ID, using default path handling",
-				zap.String("service_id", serviceID))
-			servicePrefix := "/" + serviceID
-			proxiedPath = strings.TrimPrefix(proxiedPath, servicePrefix)
-			req.URL.Path = proxiedPath
+		proxiedPath := "/" + strings.TrimLeft(strings.TrimPrefix(originalPath, gatewayAPIPrefix), "/")
+		var rewrite string
+		req.URL.Path, rewrite = rewriter.rewrite(proxiedPath)
+
+		// Route to the backend serving the requested API version
+		version := versions.route(req)
+		metrics.versionRequests.WithLabelValues(serviceID, version).Inc()
+
+		if trace != nil {
+			trace.target = chosen.name
+			trace.version = version
+			trace.rewrite = rewrite
+			trace.backendPath = req.URL.Path
+			trace.backendURL = req.URL.String()
 		}
 
-		// Ensure path starts with a single slash
-		req.URL.Path = "/" + strings.TrimLeft(req.URL.Path, "/")
-
-		logger.Debug("Proxy Director: Request prepared",
-			zap.String("final_path", req.URL.Path),
-			zap.String("backend_url", fmt.Sprintf("%s://%s%s", req.URL.Scheme, req.URL.Host, req.URL.Path)))
-
-		logger.Info("PROXY_DIRECTOR_FINAL_TARGET", // INFO để dễ thấy
-			zap.String("service", serviceID),
-			zap.String("method", req.Method),
-			zap.String("final_backend_scheme", req.URL.Scheme),
-			zap.String("final_backend_host", req.URL.Host),
-			zap.String("final_backend_path", req.URL.Path), // Đây là path sẽ gửi đi
-			zap.String("full_backend_url", req.URL.String()),
-		)
 		// Add headers
 		req.Header.Set("X-Forwarded-For", req.RemoteAddr)
 		req.Header.Set("X-Forwarded-Proto", "http")
 		req.Header.Set("X-Gateway-Service", serviceID)
+		if requestID != "" {
+			req.Header.Set(middleware.RequestIDHeader, requestID)
+		}
 		req.Header.Set("X-Original-Path", originalPath)
+		if userAgent != "" {
+			if original := req.Header.Get("User-Agent"); cfg.PreserveUserAgent && original != "" {
+				req.Header.Set("X-Original-User-Agent", original)
+			}
+			req.Header.Set("User-Agent", userAgent)
+		}
 	}
 
 	// Custom error handler with better error handling
 	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		if trace := traceFromContext(r.Context()); trace != nil {
+			trace.err = err
+		}
+
+		// The client went away: the backend request was cancelled through the
+		// request context, so this is not a backend failure
+		if errors.Is(err, context.Canceled) && r.Context().Err() != nil {
+			logger.Info("Client cancelled request, backend request aborted",
+				zap.String("service", serviceID),
+				zap.String("request_url", r.URL.String()))
+			w.WriteHeader(statusClientClosedRequest)
+			return
+		}
+
+		// The body outgrew the gateway's limit while being sent, which is the
+		// client's fault rather than the backend's
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			logger.Warn("Request body too large, backend request aborted",
+				zap.String("service", serviceID),
+				zap.String("request_url", r.URL.String()),
+				zap.Int64("limit", tooLarge.Limit))
+			if origin := r.Header.Get("Origin"); cors.AllowsOrigin(origin) {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+				w.Header().Set("Access-Control-Allow-Credentials", "true")
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			_, _ = w.Write([]byte(`{"error":"Request body too large"}`))
+			return
+		}
+
 		logger.Error("Proxy error occurred",
 			zap.String("service", serviceID),
 			zap.String("request_url", r.URL.String()),
-			zap.String("target_host", target.Host),
+			zap.String("target_host", r.URL.Host),
 			zap.Error(err))
 
-		logger.Error("PROXY_ERROR_HANDLER", // ERROR để dễ thấy
-			zap.String("service", serviceID),
-			zap.String("request_url_at_error", r.URL.String()),
-			zap.String("target_host_at_error", target.Host),
-			zap.Error(err), // Lỗi chi tiết
-		)
 		// Determine appropriate status code
 		statusCode := http.StatusBadGateway
 		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
@@ -179,7 +198,7 @@ func NewServiceProxy(targetURL string, serviceID string, logger *zap.Logger) (*S
 		}
 
 		// Set CORS headers for error responses
-		if origin := r.Header.Get("Origin"); isValidOrigin(origin) {
+		if origin := r.Header.Get("Origin"); cors.AllowsOrigin(origin) {
 			w.Header().Set("Access-Control-Allow-Origin", origin)
 			w.Header().Set("Access-Control-Allow-Credentials", "true")
 		}
@@ -192,23 +211,14 @@ func NewServiceProxy(targetURL string, serviceID string, logger *zap.Logger) (*S
 		_, _ = w.Write([]byte(errorMsg))
 	}
 
+	maxResponseBytes := cfg.Services[serviceID].MaxResponseBytes
+	limitStreams := cfg.Services[serviceID].LimitStreamingResponses
+
 	// Modify response with minimal intervention
 	proxy.ModifyResponse = func(resp *http.Response) error {
-		logger.Debug("Response received from backend",
-			zap.String("service", serviceID),
-			zap.Int("status", resp.StatusCode),
-			zap.String("content_type", resp.Header.Get("Content-Type")),
-			zap.Int64("content_length", resp.ContentLength),
-			zap.Any("headers", resp.Header))
-		logger.Info("PROXY_MODIFY_RESPONSE", // INFO để dễ thấy
-			zap.String("service", serviceID),
-			zap.Int("backend_status_code", resp.StatusCode),
-			zap.String("backend_content_type", resp.Header.Get("Content-Type")),
-			zap.String("backend_content_length_header", resp.Header.Get("Content-Length")),
-			zap.Int64("backend_content_length_parsed", resp.ContentLength), // Do Go tự parse
-			zap.Strings("backend_transfer_encoding", resp.Header["Transfer-Encoding"]),
-			zap.Any("ALL_BACKEND_HEADERS", resp.Header), // Log tất cả các header từ backend
-		)
+		if trace := traceFromContext(resp.Request.Context()); trace != nil {
+			trace.backendStatus = resp.StatusCode
+		}
 
 		// Remove backend CORS headers to prevent conflicts
 		resp.Header.Del("Access-Control-Allow-Origin")
@@ -221,11 +231,34 @@ func NewServiceProxy(targetURL string, serviceID string, logger *zap.Logger) (*S
 		// Add proxy identification
 		resp.Header.Set("X-Proxied-By", "API-Gateway")
 
+		// Cap the response size; event streams are exempt unless configured otherwise
+		if maxResponseBytes > 0 && (limitStreams || !isEventStream(resp.Header)) {
+			if resp.ContentLength > maxResponseBytes {
+				logger.Error("Backend response exceeds size limit",
+					zap.String("service", serviceID),
+					zap.String("path", resp.Request.URL.Path),
+					zap.Int64("content_length", resp.ContentLength),
+					zap.Int64("max_response_bytes", maxResponseBytes))
+				return errResponseTooLarge
+			}
+			path := resp.Request.URL.Path
+			resp.Body = newLimitedBody(resp.Body, maxResponseBytes, func() {
+				logger.Error("Backend response exceeded size limit, aborting",
+					zap.String("service", serviceID),
+					zap.String("path", path),
+					zap.Int64("max_response_bytes", maxResponseBytes))
+			})
+		}
+
 		return nil
 	}
 
 	// Configure transport with appropriate timeouts
-	proxy.Transport = &http.Transport{
+	headerTimeout := cfg.Services[serviceID].ResponseHeaderTimeout
+	if headerTimeout == 0 {
+		headerTimeout = getTimeoutForService(serviceID)
+	}
+	transport := &http.Transport{
 		Proxy: http.ProxyFromEnvironment,
 		DialContext: (&net.Dialer{
 			Timeout:   30 * time.Second,
@@ -238,41 +271,37 @@ func NewServiceProxy(targetURL string, serviceID string, logger *zap.Logger) (*S
 		ExpectContinueTimeout: 1 * time.Second,
 		MaxIdleConnsPerHost:   10,
 		DisableCompression:    false,
-		ResponseHeaderTimeout: getTimeoutForService(serviceID),
+		ResponseHeaderTimeout: headerTimeout,
 	}
+	proxy.Transport = newRetryTransport(
+		newColdStartTransport(newTimingTransport(transport, serviceID, metrics), cfg.Services[serviceID].ColdStart, serviceID, logger),
+		cfg.Retry, serviceID, metrics, logger)
 
 	return &ServiceProxy{
-		target:    target,
-		proxy:     proxy,
-		logger:    logger,
-		serviceID: serviceID,
+		target:     target,
+		targets:    targets,
+		targetUp:   targetUp,
+		proxy:      proxy,
+		logger:     logger,
+		serviceID:  serviceID,
+		traceLevel: cfg.TraceLevel,
+		dedup:      newWriteDeduplicator(cfg.Services[serviceID].Dedup, serviceID, metrics.registry),
+		streams:    newStreamCap(cfg.Services[serviceID].MaxStreams),
+		safe:       middleware.NewSafeMethodPolicy(cfg.SafeRoutes),
+		metrics:    metrics,
+		overload:   overload,
+		cors:       cors,
+		health:     checker,
 	}, nil
 }
 
-// isValidOrigin checks if the provided origin is allowed
-func isValidOrigin(origin string) bool {
-	if origin == "" {
-		return false
-	}
-	// Add logic to validate against a list of allowed origins
-	// For example, use a configuration file or environment variable
-	allowedOrigins := []string{
-		"http://localhost:3000", // Example allowed origin
-		"https://example.com",
-	}
-	for _, allowed := range allowedOrigins {
-		if origin == allowed {
-			return true
-		}
-	}
-	return false
-}
-
-// getTimeoutForService returns appropriate timeout for each service
+// getTimeoutForService returns the default response header timeout for each service,
+// used when proxy.services.<id>.responseHeaderTimeout is not configured
 func getTimeoutForService(serviceID string) time.Duration {
 	switch serviceID {
 	case "greenhouse-ai":
-		return 60 * time.Second
+		// Model loading on cold start can delay the first header well past a minute
+		return 120 * time.Second
 	case "user-auth", "auth":
 		return 15 * time.Second
 	case "core-operation", "core-operations":
@@ -284,12 +313,80 @@ func getTimeoutForService(serviceID string) time.Duration {
 
 // ServeHTTP handles the HTTP request by forwarding it through the reverse proxy
 func (p *ServiceProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	middleware.SetRoutedService(r.Context(), p.serviceID)
+
 	// Handle OPTIONS requests directly
 	if r.Method == "OPTIONS" {
 		p.handleOptionsRequest(w, r)
 		return
 	}
 
+	// Fail fast rather than waiting on backends the health checker has marked down
+	if p.health != nil && !p.targets.anyUp(p.targetUp) {
+		p.rejectDown(w, r)
+		return
+	}
+
+	// Streaming sessions are capped per service, separately from normal requests
+	if p.streams != nil && middleware.IsStreamingRequest(r) {
+		if !p.streams.acquire() {
+			p.rejectStream(w, r)
+			return
+		}
+		p.metrics.activeStreams.WithLabelValues(p.serviceID).Inc()
+		defer func() {
+			p.streams.release()
+			p.metrics.activeStreams.WithLabelValues(p.serviceID).Dec()
+		}()
+	}
+
+	// Collapse identical concurrent writes into one backend call when enabled.
+	// Expect: 100-continue bodies are left to the backend to accept first.
+	if p.dedup != nil && isWriteMethod(r.Method) && r.Header.Get("Expect") == "" {
+		if p.dedup.serve(w, r, http.HandlerFunc(p.forward)) {
+			p.metrics.deduplicatedRequests.WithLabelValues(p.serviceID).Inc()
+		}
+		return
+	}
+
+	p.forward(w, r)
+}
+
+// rejectStream answers a streaming request refused because the service is at its cap
+func (p *ServiceProxy) rejectStream(w http.ResponseWriter, r *http.Request) {
+	p.metrics.rejectedStreams.WithLabelValues(p.serviceID).Inc()
+	p.logger.Warn("Service streaming limit reached",
+		zap.String("service", p.serviceID),
+		zap.String("method", r.Method),
+		zap.String("path", r.URL.Path),
+		zap.Int64("max_streams", p.streams.max))
+
+	if origin := r.Header.Get("Origin"); p.cors.AllowsOrigin(origin) {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+	}
+
+	p.overload.Reject(w, middleware.OverloadStreams, "Too many streaming sessions to "+p.serviceID, 0)
+}
+
+// rejectDown answers a request to a backend that is failing its health checks
+func (p *ServiceProxy) rejectDown(w http.ResponseWriter, r *http.Request) {
+	p.logger.Warn("Backend is down, rejecting request",
+		zap.String("service", p.serviceID),
+		zap.String("method", r.Method),
+		zap.String("path", r.URL.Path))
+
+	if origin := r.Header.Get("Origin"); p.cors.AllowsOrigin(origin) {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+	}
+
+	// The backend can only be marked up again at its next health check
+	p.overload.Reject(w, middleware.OverloadBackendDown, "Service "+p.serviceID+" is failing health checks", p.health.Interval())
+}
+
+// forward sends the request to the backend and logs the routing trace
+func (p *ServiceProxy) forward(w http.ResponseWriter, r *http.Request) {
 	// Ensure the ResponseWriter supports flushing
 	var flusher http.Flusher
 	if f, ok := w.(http.Flusher); !ok {
@@ -299,19 +396,33 @@ func (p *ServiceProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		flusher = f
 	}
 
+	// Collect routing decisions for the consolidated trace entry
+	start := time.Now()
+	trace := &routeTrace{
+		requestID:    middleware.RequestIDFromContext(r.Context()),
+		method:       r.Method,
+		incomingPath: r.URL.Path,
+	}
+	ctx := withTrace(r.Context(), trace)
+	ctx = middleware.WithSafeRequest(ctx, p.safe.Allows(r.Method, r.URL.Path))
+	r = r.WithContext(ctx)
+	tw := &traceResponseWriter{ResponseWriter: newEventStreamWriter(w, p.serviceID, p.logger), status: http.StatusOK}
+
 	// Forward the request
-	p.proxy.ServeHTTP(w, r)
+	p.proxy.ServeHTTP(tw, r)
 
 	// Flush if possible
 	if flusher != nil {
 		flusher.Flush()
 	}
+
+	p.logTrace(trace, tw.status, time.Since(start))
 }
 
 // handleOptionsRequest handles CORS preflight requests
 func (p *ServiceProxy) handleOptionsRequest(w http.ResponseWriter, r *http.Request) {
 	origin := r.Header.Get("Origin")
-	if isValidOrigin(origin) {
+	if p.cors.AllowsOrigin(origin) {
 		w.Header().Set("Access-Control-Allow-Origin", origin)
 		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS, PATCH, HEAD")
 		w.Header().Set("Access-Control-Allow-Headers", "Accept, Authorization, Content-Type, X-CSRF-Token, X-Requested-With, Origin, X-Request-ID")
@@ -361,5 +472,10 @@ func (f *flushResponseWriter) Flush() {
 	}
 }
 
+// Unwrap returns the underlying ResponseWriter for http.ResponseController
+func (f *flushResponseWriter) Unwrap() http.ResponseWriter {
+	return f.ResponseWriter
+}
+
 // Ensure flushResponseWriter implements http.Flusher
 var _ http.Flusher = &flushResponseWriter{}
@@ -0,0 +1,362 @@
+package proxy
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/canxphung/DA_CNPM_242/api_gateway/internal/config"
+	"github.com/canxphung/DA_CNPM_242/api_gateway/internal/middleware"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestHandleOptionsRequestUsesConfiguredOrigins(t *testing.T) {
+	cors := middleware.NewCORSMiddleware(&config.CORSConfig{AllowedOrigins: []string{"http://dashboard.greenhouse.local"}}, zap.NewNop())
+	p := &ServiceProxy{cors: cors}
+
+	tests := []struct {
+		origin string
+		want   string
+	}{
+		{"http://dashboard.greenhouse.local", "http://dashboard.greenhouse.local"},
+		{"http://localhost:3000", ""},
+		{"https://evil.example", ""},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodOptions, "/api/v1/user-auth/login", nil)
+		req.Header.Set("Origin", tt.origin)
+		rec := httptest.NewRecorder()
+		p.handleOptionsRequest(rec, req)
+
+		if got := rec.Header().Get("Access-Control-Allow-Origin"); got != tt.want {
+			t.Errorf("origin %s: Access-Control-Allow-Origin = %q, want %q", tt.origin, got, tt.want)
+		}
+		if tt.want == "" && rec.Header().Get("Access-Control-Allow-Credentials") != "" {
+			t.Errorf("origin %s: credentials allowed for a disallowed origin", tt.origin)
+		}
+	}
+}
+
+func TestProxyBodyOverLimitIs413(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+	}))
+	t.Cleanup(backend.Close)
+	p := newTestServiceProxy(t, backend.URL, "core-operations", config.ServiceProxyConfig{})
+	limit := middleware.NewBodyLimitMiddleware(&config.RequestConfig{MaxBodyBytes: 1024}, zap.NewNop())
+	handler := limit.LimitBodySize(p)
+
+	for _, tt := range []struct {
+		size int
+		want int
+	}{
+		{1024, http.StatusOK},
+		{1025, http.StatusRequestEntityTooLarge},
+	} {
+		// A chunked upload declares no length, so the cap trips while proxying
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/core-operations/sensors/batch",
+			io.MultiReader(strings.NewReader(strings.Repeat("x", tt.size))))
+		req.ContentLength = -1
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != tt.want {
+			t.Errorf("%d-byte chunked body: status %d, want %d", tt.size, rec.Code, tt.want)
+		}
+	}
+}
+
+func TestProxyStreamIdleTimeout(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		// wantWhole is whether the client receives the data written after the pause
+		wantWhole bool
+	}{
+		// A download that pauses is not a stream and must not be cut off
+		{"slow download", "application/octet-stream", true},
+		{"idle event stream", "text/event-stream", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", tt.contentType)
+				if tt.contentType != "text/event-stream" {
+					w.Header().Set("Content-Length", "10")
+				}
+				_, _ = w.Write([]byte("first"))
+				w.(http.Flusher).Flush()
+				select {
+				case <-time.After(200 * time.Millisecond):
+				case <-r.Context().Done():
+					return
+				}
+				_, _ = w.Write([]byte("after"))
+			}))
+			t.Cleanup(backend.Close)
+			p := newTestServiceProxy(t, backend.URL, "core-operations", config.ServiceProxyConfig{})
+			handler := middleware.NewStreamIdleMiddleware(50*time.Millisecond, zap.NewNop()).EnforceIdleTimeout(p)
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/core-operations/reports/export", nil))
+			if whole := rec.Body.String() == "firstafter"; whole != tt.wantWhole {
+				t.Errorf("body %q, want the whole response %v", rec.Body.String(), tt.wantWhole)
+			}
+		})
+	}
+}
+
+func TestProxyForwardsClientCancellation(t *testing.T) {
+	received, backendCancelled := make(chan struct{}), make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(received)
+		select {
+		case <-r.Context().Done():
+			close(backendCancelled)
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	t.Cleanup(backend.Close)
+
+	core, logs := observer.New(zapcore.InfoLevel)
+	p, _ := newTestProxy(t, backend.URL, "greenhouse-ai", &config.ProxyConfig{}, zap.New(core))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/greenhouse-ai/api/predict", nil).WithContext(ctx)
+	go func() {
+		<-received
+		cancel()
+	}()
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, req)
+
+	select {
+	case <-backendCancelled:
+	case <-time.After(2 * time.Second):
+		t.Fatal("backend request not cancelled with the client's")
+	}
+	if rec.Code != statusClientClosedRequest {
+		t.Errorf("status %d, want %d", rec.Code, statusClientClosedRequest)
+	}
+	// A client going away is not a backend failure
+	if errors := logs.FilterLevelExact(zapcore.ErrorLevel).All(); len(errors) != 0 {
+		t.Errorf("logged %q at error level", errors[0].Message)
+	}
+	if logs.FilterMessage("Client cancelled request, backend request aborted").Len() != 1 {
+		t.Error("cancellation not logged")
+	}
+}
+
+func TestProxyUserAgent(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Seen-User-Agent", r.Header.Get("User-Agent"))
+		w.Header().Set("X-Seen-Original-User-Agent", r.Header.Get("X-Original-User-Agent"))
+	}))
+	t.Cleanup(backend.Close)
+
+	tests := []struct {
+		name         string
+		cfg          config.ProxyConfig
+		want         string
+		wantOriginal string
+	}{
+		{
+			name: "gateway User-Agent with the service",
+			cfg:  config.ProxyConfig{UserAgent: "greenhouse-gateway/1.0 ({service})", PreserveUserAgent: true},
+			want: "greenhouse-gateway/1.0 (core-operations)", wantOriginal: "dashboard/2.3",
+		},
+		{
+			name: "per-service override",
+			cfg: config.ProxyConfig{
+				UserAgent: "greenhouse-gateway/1.0",
+				Services:  map[string]config.ServiceProxyConfig{"core-operations": {UserAgent: "gateway-for-{service}"}},
+			},
+			want: "gateway-for-core-operations",
+		},
+		{
+			name: "client User-Agent not preserved",
+			cfg:  config.ProxyConfig{UserAgent: "greenhouse-gateway/1.0"},
+			want: "greenhouse-gateway/1.0",
+		},
+		{
+			name: "no gateway User-Agent passes the client's through",
+			cfg:  config.ProxyConfig{PreserveUserAgent: true},
+			want: "dashboard/2.3",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, _ := newTestProxy(t, backend.URL, "core-operations", &tt.cfg, zap.NewNop())
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/core-operations/plants", nil)
+			req.Header.Set("User-Agent", "dashboard/2.3")
+			rec := httptest.NewRecorder()
+			p.ServeHTTP(rec, req)
+
+			if got := rec.Header().Get("X-Seen-User-Agent"); got != tt.want {
+				t.Errorf("User-Agent = %q, want %q", got, tt.want)
+			}
+			if got := rec.Header().Get("X-Seen-Original-User-Agent"); got != tt.wantOriginal {
+				t.Errorf("X-Original-User-Agent = %q, want %q", got, tt.wantOriginal)
+			}
+		})
+	}
+}
+
+// countingBody records how many bytes the client actually sent
+type countingBody struct {
+	r    io.Reader
+	read atomic.Int64
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.r.Read(p)
+	b.read.Add(int64(n))
+	return n, err
+}
+
+func TestProxyExpectContinue(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Seen-Expect", r.Header.Get("Expect"))
+		if r.Header.Get("Authorization") == "" {
+			// Rejected before the body is read, so no 100 Continue is sent
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("X-Seen-Length", strconv.Itoa(len(body)))
+		w.WriteHeader(http.StatusCreated)
+	}))
+	t.Cleanup(backend.Close)
+
+	logger := zap.NewNop()
+	p, reg := newTestProxy(t, backend.URL, "core-operations", &config.ProxyConfig{}, logger)
+	contentLength := middleware.NewContentLengthMiddleware(&config.RequestConfig{ValidateContentLength: true, ContentLengthBufferLimit: 1 << 20}, logger)
+	metrics := middleware.NewMetricsMiddleware(reg, &config.MetricsConfig{}, nil)
+	gateway := httptest.NewServer(middleware.NewLoggingMiddleware(logger).LogRequest(metrics.CollectMetrics(contentLength.ValidateContentLength(p))))
+	t.Cleanup(gateway.Close)
+
+	// A long continue timeout: the client only sends the body early if the
+	// 100 Continue never arrives
+	client := &http.Client{Transport: &http.Transport{ExpectContinueTimeout: 5 * time.Second}}
+	payload := strings.Repeat("x", 64<<10)
+
+	tests := []struct {
+		name     string
+		auth     string
+		want     int
+		wantSent int64
+	}{
+		{"accepted upload", "Bearer token", http.StatusCreated, int64(len(payload))},
+		{"rejected before the body", "", http.StatusUnauthorized, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := &countingBody{r: strings.NewReader(payload)}
+			req, err := http.NewRequest(http.MethodPost, gateway.URL+"/api/v1/core-operations/uploads", body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.ContentLength = int64(len(payload))
+			req.Header.Set("Expect", "100-continue")
+			if tt.auth != "" {
+				req.Header.Set("Authorization", tt.auth)
+			}
+
+			start := time.Now()
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Fatalf("Do: %v", err)
+			}
+			resp.Body.Close()
+
+			if resp.StatusCode != tt.want {
+				t.Errorf("status %d, want %d", resp.StatusCode, tt.want)
+			}
+			if elapsed := time.Since(start); elapsed > 2*time.Second {
+				t.Errorf("took %v, the client waited out the continue timeout", elapsed)
+			}
+			if got := resp.Header.Get("X-Seen-Expect"); got != "100-continue" {
+				t.Errorf("backend saw Expect %q, want 100-continue", got)
+			}
+			if got := body.read.Load(); got != tt.wantSent {
+				t.Errorf("client sent %d body bytes, want %d", got, tt.wantSent)
+			}
+			if tt.want == http.StatusCreated && resp.Header.Get("X-Seen-Length") != strconv.Itoa(len(payload)) {
+				t.Errorf("backend read %s bytes, want %d", resp.Header.Get("X-Seen-Length"), len(payload))
+			}
+		})
+	}
+
+	// Closing waits for the handlers to record their metrics.
+	// The interim 100 is not recorded as the final status.
+	gateway.Close()
+	for _, status := range []string{"Created", "Unauthorized"} {
+		if got := counterValue(t, reg, "api_gateway_requests_total", map[string]string{"status": status}); got != 1 {
+			t.Errorf("requests_total{status=%q} = %v, want 1", status, got)
+		}
+	}
+	if got := counterValue(t, reg, "api_gateway_requests_total", map[string]string{"status": "Continue"}); got != 0 {
+		t.Errorf("requests_total recorded %v interim 100 responses", got)
+	}
+}
+
+func TestProxyForwardsRequestID(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Seen-Request-ID", r.Header.Get(middleware.RequestIDHeader))
+	}))
+	t.Cleanup(backend.Close)
+	p, _ := newTestProxy(t, backend.URL, "core-operations", &config.ProxyConfig{}, zap.NewNop())
+	handler := middleware.NewLoggingMiddleware(zap.NewNop()).LogRequest(p)
+
+	for _, incoming := range []string{"edge-42", ""} {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/core-operations/plants", nil)
+		if incoming != "" {
+			req.Header.Set(middleware.RequestIDHeader, incoming)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		seen, responseID := rec.Header().Get("X-Seen-Request-ID"), rec.Header().Get(middleware.RequestIDHeader)
+		if seen == "" || seen != responseID || (incoming != "" && seen != incoming) {
+			t.Errorf("incoming %q: backend saw %q, client got %q", incoming, seen, responseID)
+		}
+	}
+}
+
+func TestProxySecurityHeaders(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The docs UI needs its own policy
+		w.Header().Set("Content-Security-Policy", "default-src 'self'")
+		_, _ = w.Write([]byte("<html></html>"))
+	}))
+	t.Cleanup(backend.Close)
+	p, _ := newTestProxy(t, backend.URL, "core-operations", &config.ProxyConfig{}, zap.NewNop())
+	handler := middleware.NewSecurityHeadersMiddleware(&config.SecurityHeadersConfig{
+		ContentTypeOptions:      true,
+		FrameOptions:            "DENY",
+		ContentSecurityPolicy:   "default-src 'none'",
+		StrictTransportSecurity: "max-age=31536000",
+	}).SetHeaders(p)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/core-operations/docs", nil))
+
+	want := map[string]string{
+		"X-Content-Type-Options":    "nosniff",
+		"X-Frame-Options":           "DENY",
+		"Content-Security-Policy":   "default-src 'self'",
+		"Strict-Transport-Security": "",
+	}
+	for name, value := range want {
+		if got := rec.Header().Get(name); got != value {
+			t.Errorf("%s = %q, want %q", name, got, value)
+		}
+	}
+}
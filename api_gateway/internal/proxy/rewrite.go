@@ -0,0 +1,116 @@
+package proxy
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/canxphung/DA_CNPM_242/api_gateway/internal/config"
+)
+
+// rewriteRule is a compiled config.RewriteRule
+type rewriteRule struct {
+	config.RewriteRule
+	regex *regexp.Regexp
+}
+
+// pathRewriter maps a gateway path (below /api/v1) to the backend path
+type pathRewriter struct {
+	serviceID string
+	rules     []rewriteRule
+}
+
+// newPathRewriter compiles a service's rewrite rules. Without rules the
+// service prefix is stripped and the rest passed through.
+func newPathRewriter(serviceID string, rules []config.RewriteRule) (*pathRewriter, error) {
+	if len(rules) == 0 {
+		rules = []config.RewriteRule{{Name: "strip-service-prefix", StripPrefix: "/" + serviceID}}
+	}
+
+	compiled := make([]rewriteRule, 0, len(rules))
+	for i, rule := range rules {
+		if rule.Name == "" {
+			rule.Name = "rule-" + strconv.Itoa(i+1)
+		}
+		var regex *regexp.Regexp
+		if rule.Regex != "" {
+			var err error
+			if regex, err = regexp.Compile(rule.Regex); err != nil {
+				return nil, fmt.Errorf("invalid regex in rewrite rule %s for service %s: %w", rule.Name, serviceID, err)
+			}
+		}
+		compiled = append(compiled, rewriteRule{RewriteRule: rule, regex: regex})
+	}
+
+	return &pathRewriter{serviceID: serviceID, rules: compiled}, nil
+}
+
+// BackendPath returns the backend path the proxy requests for a path below
+// the service's canonical gateway prefix, applying the service's rewrite rules
+func BackendPath(service config.ServiceDefinition, rules []config.RewriteRule, path string) (string, error) {
+	rewriter, err := newPathRewriter(service.ID, rules)
+	if err != nil {
+		return "", err
+	}
+	backendPath, _ := rewriter.rewrite(service.Prefixes[0] + path)
+	return backendPath, nil
+}
+
+// rewrite applies the rules in order and returns the backend path together
+// with a description of the rules that changed it, for the routing trace
+func (pr *pathRewriter) rewrite(path string) (string, string) {
+	var applied []string
+	for _, rule := range pr.rules {
+		if !rule.applies(path) {
+			continue
+		}
+		rewritten := path
+		if rule.StripPrefix != "" && hasPathPrefix(rewritten, rule.StripPrefix) {
+			rewritten = strings.TrimPrefix(rewritten, strings.TrimRight(rule.StripPrefix, "/"))
+		}
+		if rule.AddPrefix != "" {
+			rewritten = strings.TrimRight(rule.AddPrefix, "/") + rewritten
+		}
+		if rule.regex != nil {
+			rewritten = rule.regex.ReplaceAllString(rewritten, rule.Replacement)
+		}
+		if rewritten != path {
+			applied = append(applied, rule.Name)
+			path = rewritten
+		}
+	}
+
+	// Ensure path starts with a single slash
+	path = "/" + strings.TrimLeft(path, "/")
+
+	if len(applied) == 0 {
+		return path, pr.serviceID + ":passthrough"
+	}
+	return path, pr.serviceID + ":" + strings.Join(applied, ",")
+}
+
+// applies reports whether the rule's When and Unless conditions hold for path
+func (r rewriteRule) applies(path string) bool {
+	for _, prefix := range r.Unless {
+		if strings.HasPrefix(path, prefix) {
+			return false
+		}
+	}
+	if len(r.When) == 0 {
+		return true
+	}
+	for _, prefix := range r.When {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasPathPrefix reports whether prefix matches whole leading segments of path,
+// so "/core-operation" strips "/core-operation/x" but not "/core-operations/x"
+func hasPathPrefix(path, prefix string) bool {
+	prefix = strings.TrimRight(prefix, "/")
+	return path == prefix || strings.HasPrefix(path, prefix+"/")
+}
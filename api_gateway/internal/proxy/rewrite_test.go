@@ -0,0 +1,124 @@
+package proxy
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/canxphung/DA_CNPM_242/api_gateway/internal/config"
+	"github.com/spf13/viper"
+)
+
+// sampleRewriteYAML is a rule set as it would appear in config.yaml
+const sampleRewriteYAML = `
+proxy:
+  services:
+    core-operations:
+      rewrite:
+        - name: strip-service-prefix
+          stripPrefix: /core-operations
+        - name: strip-service-alias
+          stripPrefix: /core-operation
+        - name: legacy-sensors
+          when: ["/sensors"]
+          regex: "^/sensors/([^/]+)/readings$"
+          replacement: "/api/v2/readings/$1"
+        - name: add-api-prefix
+          addPrefix: /api
+          unless: ["/api/", "/health"]
+`
+
+// loadSampleRules reads the rewrite rules of a service from sampleRewriteYAML
+// the way config.LoadConfig reads proxy.services
+func loadSampleRules(t *testing.T, serviceID string) []config.RewriteRule {
+	t.Helper()
+	v := viper.New()
+	v.SetConfigType("yaml")
+	if err := v.ReadConfig(strings.NewReader(sampleRewriteYAML)); err != nil {
+		t.Fatalf("read sample config: %v", err)
+	}
+	var services map[string]config.ServiceProxyConfig
+	if err := v.UnmarshalKey("proxy.services", &services); err != nil {
+		t.Fatalf("unmarshal proxy.services: %v", err)
+	}
+	return services[serviceID].Rewrite
+}
+
+func TestRewriteSampleRuleSet(t *testing.T) {
+	rules := loadSampleRules(t, "core-operations")
+	if len(rules) != 4 {
+		t.Fatalf("loaded %d rules, want 4: %+v", len(rules), rules)
+	}
+	rewriter, err := newPathRewriter("core-operations", rules)
+	if err != nil {
+		t.Fatalf("newPathRewriter: %v", err)
+	}
+
+	tests := []struct {
+		path    string
+		want    string
+		applied string
+	}{
+		{"/core-operations/plants", "/api/plants", "core-operations:strip-service-prefix,add-api-prefix"},
+		{"/core-operation/plants/7", "/api/plants/7", "core-operations:strip-service-alias,add-api-prefix"},
+		{"/core-operations/health", "/health", "core-operations:strip-service-prefix"},
+		{"/core-operations/api/zones", "/api/zones", "core-operations:strip-service-prefix"},
+		{"/core-operations/sensors/s-1/readings", "/api/v2/readings/s-1", "core-operations:strip-service-prefix,legacy-sensors"},
+		// The alias is stripped only as a whole segment
+		{"/core-operationsx/plants", "/api/core-operationsx/plants", "core-operations:add-api-prefix"},
+	}
+	for _, tt := range tests {
+		got, applied := rewriter.rewrite(tt.path)
+		if got != tt.want || applied != tt.applied {
+			t.Errorf("rewrite(%q) = %q (%s), want %q (%s)", tt.path, got, applied, tt.want, tt.applied)
+		}
+	}
+}
+
+func TestRewriteInvalidRegex(t *testing.T) {
+	_, err := newPathRewriter("greenhouse-ai", []config.RewriteRule{{Name: "broken", Regex: "(["}})
+	if err == nil || !strings.Contains(err.Error(), "broken") {
+		t.Errorf("err = %v, want an error naming the rule", err)
+	}
+}
+
+func TestBackendPathMatchesHealthEndpoints(t *testing.T) {
+	// The default rules of the built-in services, and no rules for a custom one
+	tests := []struct {
+		service config.ServiceDefinition
+		rules   []config.RewriteRule
+		path    string
+		want    string
+	}{
+		{
+			service: config.ServiceDefinition{ID: "user-auth", Prefixes: []string{"/user-auth"}},
+			rules:   []config.RewriteRule{{StripPrefix: "/user-auth"}, {AddPrefix: "/api/v1"}},
+			path:    "/monitoring/health",
+			want:    "/api/v1/monitoring/health",
+		},
+		{
+			service: config.ServiceDefinition{ID: "core-operations", Prefixes: []string{"/core-operations", "/core-operation"}},
+			rules: []config.RewriteRule{
+				{StripPrefix: "/core-operations"}, {StripPrefix: "/core-operation"},
+				{AddPrefix: "/api", Unless: []string{"/api/", "/health", "/version", "/docs"}},
+			},
+			path: "/health",
+			want: "/health",
+		},
+		{
+			service: config.ServiceDefinition{ID: "weather", Prefixes: []string{"/weather"}},
+			path:    "/status",
+			want:    "/status",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.service.ID, func(t *testing.T) {
+			got, err := BackendPath(tt.service, tt.rules, tt.path)
+			if err != nil {
+				t.Fatalf("BackendPath: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("BackendPath(%q) = %q, want %q", tt.path, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package proxy
+
+import (
+	"errors"
+	"mime"
+	"net/http"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// eventStreamWriter prepares the client connection when the backend answers
+// with Server-Sent Events: the server's write timeout, meant for ordinary
+// responses, would cut a long-lived stream off, and buffering proxies in front
+// of the gateway must pass events through as they are written. Streams that
+// stop sending are still ended by the stream idle timeout.
+type eventStreamWriter struct {
+	http.ResponseWriter
+	serviceID string
+	logger    *zap.Logger
+}
+
+func newEventStreamWriter(w http.ResponseWriter, serviceID string, logger *zap.Logger) *eventStreamWriter {
+	return &eventStreamWriter{ResponseWriter: w, serviceID: serviceID, logger: logger}
+}
+
+func (ew *eventStreamWriter) WriteHeader(code int) {
+	if code != http.StatusOK || !isEventStream(ew.Header()) {
+		ew.ResponseWriter.WriteHeader(code)
+		return
+	}
+
+	ew.Header().Set("X-Accel-Buffering", "no")
+	controller := http.NewResponseController(ew.ResponseWriter)
+	if err := controller.SetWriteDeadline(time.Time{}); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		ew.logger.Warn("Failed to clear write deadline for event stream",
+			zap.String("service", ew.serviceID),
+			zap.Error(err))
+	}
+
+	ew.ResponseWriter.WriteHeader(code)
+	// Send the headers now so the client sees the stream open before the first event
+	_ = controller.Flush()
+}
+
+// Flush implements the http.Flusher interface
+func (ew *eventStreamWriter) Flush() {
+	if flusher, ok := ew.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap returns the underlying ResponseWriter for http.ResponseController
+func (ew *eventStreamWriter) Unwrap() http.ResponseWriter {
+	return ew.ResponseWriter
+}
+
+// isEventStream reports whether the response headers announce Server-Sent Events
+func isEventStream(header http.Header) bool {
+	mediaType, _, err := mime.ParseMediaType(header.Get("Content-Type"))
+	return err == nil && mediaType == "text/event-stream"
+}
@@ -0,0 +1,37 @@
+package proxy
+
+import (
+	"sync/atomic"
+)
+
+// streamCap limits the number of concurrent streaming sessions to one backend.
+// It is independent of the gateway-wide streaming limit and of normal requests.
+type streamCap struct {
+	max    int64
+	active atomic.Int64
+}
+
+// newStreamCap returns nil when max is 0 (unlimited)
+func newStreamCap(max int) *streamCap {
+	if max <= 0 {
+		return nil
+	}
+	return &streamCap{max: int64(max)}
+}
+
+// acquire takes a session slot if one is available
+func (c *streamCap) acquire() bool {
+	for {
+		current := c.active.Load()
+		if current >= c.max {
+			return false
+		}
+		if c.active.CompareAndSwap(current, current+1) {
+			return true
+		}
+	}
+}
+
+func (c *streamCap) release() {
+	c.active.Add(-1)
+}
@@ -0,0 +1,92 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/canxphung/DA_CNPM_242/api_gateway/internal/config"
+	"go.uber.org/zap"
+)
+
+func TestStreamCap(t *testing.T) {
+	if newStreamCap(0) != nil {
+		t.Error("zero cap is not unlimited")
+	}
+
+	c := newStreamCap(2)
+	if !c.acquire() || !c.acquire() {
+		t.Fatal("slots under the cap refused")
+	}
+	if c.acquire() {
+		t.Fatal("slot granted over the cap")
+	}
+	c.release()
+	if !c.acquire() {
+		t.Error("released slot not reusable")
+	}
+}
+
+func TestProxyCapsStreamsPerService(t *testing.T) {
+	streaming := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Accept") != "text/event-stream" {
+			_, _ = w.Write([]byte("plain"))
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		streaming <- struct{}{}
+		<-r.Context().Done()
+	}))
+	t.Cleanup(backend.Close)
+
+	p, reg := newTestProxy(t, backend.URL, "greenhouse-ai", &config.ProxyConfig{
+		Services: map[string]config.ServiceProxyConfig{"greenhouse-ai": {MaxStreams: 1}},
+	}, zap.NewNop())
+
+	streamRequest := func(ctx context.Context) *http.Request {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/greenhouse-ai/api/chat", nil).WithContext(ctx)
+		req.Header.Set("Accept", "text/event-stream")
+		return req
+	}
+
+	// Hold one stream open
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		p.ServeHTTP(httptest.NewRecorder(), streamRequest(ctx))
+	}()
+	select {
+	case <-streaming:
+	case <-time.After(2 * time.Second):
+		t.Fatal("first stream did not open")
+	}
+
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, streamRequest(context.Background()))
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("stream over the cap: status %d Retry-After %q, want 503 with Retry-After", rec.Code, rec.Header().Get("Retry-After"))
+	}
+
+	// Normal requests are not capped
+	rec = httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/greenhouse-ai/api/models", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "plain" {
+		t.Errorf("normal request: status %d body %q, want it proxied", rec.Code, rec.Body.String())
+	}
+
+	if got := counterValue(t, reg, "api_gateway_proxy_streams_rejected_total", map[string]string{"service": "greenhouse-ai"}); got != 1 {
+		t.Errorf("proxy_streams_rejected_total = %v, want 1", got)
+	}
+
+	cancel()
+	<-done
+	if p.streams.active.Load() != 0 {
+		t.Errorf("%d sessions still counted after the stream closed", p.streams.active.Load())
+	}
+}
@@ -0,0 +1,33 @@
+package proxy
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// timingTransport observes how long each backend attempt takes until its
+// response headers arrive. It wraps the innermost transport, so retries are
+// observed one by one and the time spent in the gateway is left out.
+type timingTransport struct {
+	next      http.RoundTripper
+	serviceID string
+	metrics   *Metrics
+}
+
+// newTimingTransport wraps next with upstream duration metrics
+func newTimingTransport(next http.RoundTripper, serviceID string, metrics *Metrics) http.RoundTripper {
+	return &timingTransport{next: next, serviceID: serviceID, metrics: metrics}
+}
+
+func (t *timingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+
+	statusClass := "error"
+	if err == nil {
+		statusClass = strconv.Itoa(resp.StatusCode/100) + "xx"
+	}
+	t.metrics.upstreamDuration.WithLabelValues(t.serviceID, statusClass).Observe(time.Since(start).Seconds())
+	return resp, err
+}
@@ -0,0 +1,87 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/canxphung/DA_CNPM_242/api_gateway/internal/config"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"go.uber.org/zap"
+)
+
+// upstreamHistogram returns the upstream duration histogram for a status class
+func upstreamHistogram(t *testing.T, reg *prometheus.Registry, statusClass string) *dto.Histogram {
+	t.Helper()
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("Gather: %v", err)
+	}
+	for _, family := range families {
+		if family.GetName() != "api_gateway_upstream_duration_seconds" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			for _, pair := range metric.GetLabel() {
+				if pair.GetName() == "status_class" && pair.GetValue() == statusClass {
+					return metric.GetHistogram()
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func TestUpstreamDurationExcludesGatewayTime(t *testing.T) {
+	const backendDelay = 100 * time.Millisecond
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(backendDelay)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	t.Cleanup(backend.Close)
+
+	p, reg := newTestProxy(t, backend.URL, "core-operations", &config.ProxyConfig{}, zap.NewNop())
+	// Time spent in the gateway before the proxy is not upstream time
+	slowGateway := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(300 * time.Millisecond)
+		p.ServeHTTP(w, r)
+	})
+	slowGateway.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/v1/core-operations/plants", nil))
+
+	histogram := upstreamHistogram(t, reg, "2xx")
+	if histogram == nil || histogram.GetSampleCount() != 1 {
+		t.Fatalf("upstream_duration_seconds{status_class=\"2xx\"} = %v, want one observation", histogram)
+	}
+	if got := histogram.GetSampleSum(); got < backendDelay.Seconds() || got > 0.25 {
+		t.Errorf("observed %.3fs, want roughly the %s backend delay", got, backendDelay)
+	}
+}
+
+func TestUpstreamDurationStatusClass(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	t.Cleanup(backend.Close)
+
+	tests := []struct {
+		name   string
+		target string
+		want   string
+	}{
+		{"backend status", backend.URL, "4xx"},
+		// Nothing listens on the discard port
+		{"transport error", "http://127.0.0.1:9", "error"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, reg := newTestProxy(t, tt.target, "core-operations", &config.ProxyConfig{}, zap.NewNop())
+			p.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/v1/core-operations/plants", nil))
+
+			if histogram := upstreamHistogram(t, reg, tt.want); histogram.GetSampleCount() != 1 {
+				t.Errorf("no observation with status_class %q", tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,113 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// routeTrace collects the routing decisions made for a single proxied request
+// so they can be logged together once the request completes.
+type routeTrace struct {
+	requestID     string
+	method        string
+	incomingPath  string
+	target        string
+	version       string
+	rewrite       string
+	backendPath   string
+	backendURL    string
+	backendStatus int
+	retries       int
+	err           error
+}
+
+type traceContextKey struct{}
+
+// withTrace stores the trace in the request context so the Director,
+// ModifyResponse and ErrorHandler hooks can fill it in
+func withTrace(ctx context.Context, trace *routeTrace) context.Context {
+	return context.WithValue(ctx, traceContextKey{}, trace)
+}
+
+// traceFromContext returns the trace attached to the context, if any
+func traceFromContext(ctx context.Context) *routeTrace {
+	trace, _ := ctx.Value(traceContextKey{}).(*routeTrace)
+	return trace
+}
+
+// logTrace writes the consolidated routing entry at the configured level
+func (p *ServiceProxy) logTrace(trace *routeTrace, status int, duration time.Duration) {
+	var level zapcore.Level
+	switch p.traceLevel {
+	case "info":
+		level = zapcore.InfoLevel
+	case "debug":
+		level = zapcore.DebugLevel
+	default:
+		return
+	}
+
+	ce := p.logger.Check(level, "Proxy request trace")
+	if ce == nil {
+		return
+	}
+
+	fields := []zap.Field{
+		zap.String("request_id", trace.requestID),
+		zap.String("method", trace.method),
+		zap.String("incoming_path", trace.incomingPath),
+		zap.String("service", p.serviceID),
+		zap.String("target", trace.target),
+		zap.String("version", trace.version),
+		zap.String("rewrite", trace.rewrite),
+		zap.String("backend_path", trace.backendPath),
+		zap.String("backend_url", trace.backendURL),
+		zap.Int("backend_status", trace.backendStatus),
+		zap.Int("retries", trace.retries),
+		zap.Int("status", status),
+		zap.Duration("duration", duration),
+	}
+	if trace.err != nil {
+		fields = append(fields, zap.Error(trace.err))
+	}
+	ce.Write(fields...)
+}
+
+// traceResponseWriter captures the final status code written to the client
+type traceResponseWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (tw *traceResponseWriter) WriteHeader(code int) {
+	// 1xx responses (e.g. a backend's 100 Continue) precede the final status;
+	// 101 Switching Protocols is final
+	informational := code < http.StatusOK && code != http.StatusSwitchingProtocols
+	if !tw.wroteHeader && !informational {
+		tw.status = code
+		tw.wroteHeader = true
+	}
+	tw.ResponseWriter.WriteHeader(code)
+}
+
+func (tw *traceResponseWriter) Write(data []byte) (int, error) {
+	tw.wroteHeader = true
+	return tw.ResponseWriter.Write(data)
+}
+
+// Flush implements the http.Flusher interface
+func (tw *traceResponseWriter) Flush() {
+	if flusher, ok := tw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap returns the underlying ResponseWriter for http.ResponseController
+func (tw *traceResponseWriter) Unwrap() http.ResponseWriter {
+	return tw.ResponseWriter
+}
@@ -0,0 +1,133 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/canxphung/DA_CNPM_242/api_gateway/internal/config"
+	"github.com/canxphung/DA_CNPM_242/api_gateway/internal/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// newTestProxy builds a proxy for serviceID from cfg, adding the service's
+// settings when cfg has none, and returns the registry its metrics use
+func newTestProxy(t *testing.T, targetURL, serviceID string, cfg *config.ProxyConfig, logger *zap.Logger) (*ServiceProxy, *prometheus.Registry) {
+	t.Helper()
+	if cfg.Services == nil {
+		cfg.Services = map[string]config.ServiceProxyConfig{}
+	}
+	if _, ok := cfg.Services[serviceID]; !ok {
+		cfg.Services[serviceID] = config.ServiceProxyConfig{}
+	}
+	reg := prometheus.NewRegistry()
+	cors := middleware.NewCORSMiddleware(&config.CORSConfig{}, zap.NewNop())
+	overload := middleware.NewOverloadResponder(&config.OverloadConfig{RateLimitStatus: http.StatusTooManyRequests, CapacityStatus: http.StatusServiceUnavailable})
+	p, err := NewServiceProxy(targetURL, serviceID, cfg, NewMetrics(reg), overload, cors, nil, logger)
+	if err != nil {
+		t.Fatalf("NewServiceProxy: %v", err)
+	}
+	return p, reg
+}
+
+// traceEntries returns the routing trace entries the observer recorded
+func traceEntries(logs *observer.ObservedLogs) []observer.LoggedEntry {
+	return logs.FilterMessage("Proxy request trace").All()
+}
+
+func TestProxyLogsRoutingTrace(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	}))
+	t.Cleanup(backend.Close)
+
+	core, logs := observer.New(zapcore.DebugLevel)
+	p, _ := newTestProxy(t, backend.URL, "core-operations", &config.ProxyConfig{
+		TraceLevel: "info",
+		Services: map[string]config.ServiceProxyConfig{"core-operations": {Rewrite: []config.RewriteRule{
+			{Name: "strip-service-prefix", StripPrefix: "/core-operations"},
+			{Name: "add-api-prefix", AddPrefix: "/api"},
+		}}},
+	}, zap.New(core))
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/core-operations/plants", nil)
+	req.Header.Set(middleware.RequestIDHeader, "req-42")
+	rec := httptest.NewRecorder()
+	middleware.NewLoggingMiddleware(zap.NewNop()).LogRequest(p).ServeHTTP(rec, req)
+
+	entries := traceEntries(logs)
+	if len(entries) != 1 {
+		t.Fatalf("got %d trace entries, want one per request", len(entries))
+	}
+	if entries[0].Level != zapcore.InfoLevel {
+		t.Errorf("trace level %s, want info", entries[0].Level)
+	}
+	fields := entries[0].ContextMap()
+	want := map[string]interface{}{
+		"request_id":     "req-42",
+		"method":         http.MethodPost,
+		"incoming_path":  "/api/v1/core-operations/plants",
+		"service":        "core-operations",
+		"target":         stableTargetName,
+		"rewrite":        "core-operations:strip-service-prefix,add-api-prefix",
+		"backend_path":   "/api/plants",
+		"backend_url":    backend.URL + "/api/plants",
+		"backend_status": int64(http.StatusCreated),
+		"status":         int64(http.StatusCreated),
+	}
+	for key, value := range want {
+		if fields[key] != value {
+			t.Errorf("%s = %v, want %v", key, fields[key], value)
+		}
+	}
+}
+
+func TestProxyTraceRecordsBackendError(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	// Nothing listens on the discard port
+	p, _ := newTestProxy(t, "http://127.0.0.1:9", "core-operations", &config.ProxyConfig{TraceLevel: "debug"}, zap.New(core))
+
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/core-operations/plants", nil))
+
+	entries := traceEntries(logs)
+	if len(entries) != 1 {
+		t.Fatalf("got %d trace entries, want 1", len(entries))
+	}
+	fields := entries[0].ContextMap()
+	if fields["status"] != int64(rec.Code) || rec.Code < http.StatusInternalServerError {
+		t.Errorf("status = %v, want the %d sent to the client", fields["status"], rec.Code)
+	}
+	if fields["backend_status"] != int64(0) || fields["error"] == nil {
+		t.Errorf("backend_status %v error %v, want no backend status and the error", fields["backend_status"], fields["error"])
+	}
+}
+
+func TestProxyTraceLevel(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	t.Cleanup(backend.Close)
+
+	tests := []struct {
+		traceLevel  string
+		loggerLevel zapcore.Level
+		want        int
+	}{
+		{"info", zapcore.InfoLevel, 1},
+		{"debug", zapcore.DebugLevel, 1},
+		{"debug", zapcore.InfoLevel, 0},
+		{"off", zapcore.DebugLevel, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.traceLevel+" at "+tt.loggerLevel.String(), func(t *testing.T) {
+			core, logs := observer.New(tt.loggerLevel)
+			p, _ := newTestProxy(t, backend.URL, "core-operations", &config.ProxyConfig{TraceLevel: tt.traceLevel}, zap.New(core))
+			p.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/v1/core-operations/plants", nil))
+			if got := len(traceEntries(logs)); got != tt.want {
+				t.Errorf("got %d trace entries, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/canxphung/DA_CNPM_242/api_gateway/internal/config"
+)
+
+// defaultVersion is the version reported for requests served by the default backend
+const defaultVersion = "1"
+
+// versionRoute is the backend and path prefix serving one API version
+type versionRoute struct {
+	target     *url.URL
+	pathPrefix string
+}
+
+// versionRouter selects a backend for a request based on its version header
+type versionRouter struct {
+	header string
+	routes map[string]versionRoute
+}
+
+// newVersionRouter builds the version routes for a service
+func newVersionRouter(cfg config.VersioningConfig, defaultTarget *url.URL) (*versionRouter, error) {
+	header := cfg.Header
+	if header == "" {
+		header = "Accept-Version"
+	}
+
+	routes := make(map[string]versionRoute, len(cfg.Versions))
+	for version, versionTarget := range cfg.Versions {
+		target := defaultTarget
+		if versionTarget.URL != "" {
+			parsed, err := url.Parse(versionTarget.URL)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse URL for API version %s: %w", version, err)
+			}
+			target = parsed
+		}
+		routes[version] = versionRoute{
+			target:     target,
+			pathPrefix: "/" + strings.Trim(versionTarget.PathPrefix, "/"),
+		}
+	}
+
+	return &versionRouter{
+		header: header,
+		routes: routes,
+	}, nil
+}
+
+// route points the outgoing request at the backend for the requested version
+// and returns the version that was selected
+func (v *versionRouter) route(req *http.Request) string {
+	version := strings.TrimSpace(req.Header.Get(v.header))
+	route, ok := v.routes[version]
+	if !ok {
+		return defaultVersion
+	}
+
+	req.URL.Scheme = route.target.Scheme
+	req.URL.Host = route.target.Host
+	if route.pathPrefix != "/" {
+		req.URL.Path = route.pathPrefix + req.URL.Path
+	}
+	return version
+}
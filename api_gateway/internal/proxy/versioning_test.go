@@ -0,0 +1,141 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/canxphung/DA_CNPM_242/api_gateway/internal/config"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// counterValue returns the value of the counter series with the given labels
+func counterValue(t *testing.T, reg *prometheus.Registry, name string, labels map[string]string) float64 {
+	t.Helper()
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("Gather: %v", err)
+	}
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+	metrics:
+		for _, metric := range family.GetMetric() {
+			for _, pair := range metric.GetLabel() {
+				if want, ok := labels[pair.GetName()]; ok && want != pair.GetValue() {
+					continue metrics
+				}
+			}
+			return metric.GetCounter().GetValue()
+		}
+	}
+	return 0
+}
+
+// newVersionBackend answers with its name and the path it was asked for
+func newVersionBackend(t *testing.T, name string) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(name + " " + r.URL.Path))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestProxyRoutesByVersionHeader(t *testing.T) {
+	stable := newVersionBackend(t, "v1")
+	v2 := newVersionBackend(t, "v2")
+
+	tests := []struct {
+		name       string
+		versioning config.VersioningConfig
+		header     string
+		value      string
+		want       string
+		wantLabel  string
+	}{
+		{
+			name:       "no header uses the default backend",
+			versioning: config.VersioningConfig{Versions: map[string]config.VersionTarget{"2": {URL: v2.URL}}},
+			want:       "v1 /plants",
+			wantLabel:  "1",
+		},
+		{
+			name:       "known version",
+			versioning: config.VersioningConfig{Versions: map[string]config.VersionTarget{"2": {URL: v2.URL}}},
+			header:     "Accept-Version",
+			value:      " 2 ",
+			want:       "v2 /plants",
+			wantLabel:  "2",
+		},
+		{
+			name:       "unknown version uses the default backend",
+			versioning: config.VersioningConfig{Versions: map[string]config.VersionTarget{"2": {URL: v2.URL}}},
+			header:     "Accept-Version",
+			value:      "3",
+			want:       "v1 /plants",
+			wantLabel:  "1",
+		},
+		{
+			name: "version on the default backend under a path prefix",
+			versioning: config.VersioningConfig{Versions: map[string]config.VersionTarget{
+				"2": {PathPrefix: "/v2/"},
+			}},
+			header:    "Accept-Version",
+			value:     "2",
+			want:      "v1 /v2/plants",
+			wantLabel: "2",
+		},
+		{
+			name: "configured header",
+			versioning: config.VersioningConfig{Header: "X-API-Version", Versions: map[string]config.VersionTarget{
+				"2": {URL: v2.URL, PathPrefix: "/next"},
+			}},
+			header:    "X-API-Version",
+			value:     "2",
+			want:      "v2 /next/plants",
+			wantLabel: "2",
+		},
+		{
+			name: "default header ignored once another is configured",
+			versioning: config.VersioningConfig{Header: "X-API-Version", Versions: map[string]config.VersionTarget{
+				"2": {URL: v2.URL},
+			}},
+			header:    "Accept-Version",
+			value:     "2",
+			want:      "v1 /plants",
+			wantLabel: "1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, reg := newTestProxy(t, stable.URL, "core-operations", &config.ProxyConfig{
+				Services: map[string]config.ServiceProxyConfig{"core-operations": {Versioning: tt.versioning}},
+			}, zap.NewNop())
+
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/core-operations/plants", nil)
+			if tt.header != "" {
+				req.Header.Set(tt.header, tt.value)
+			}
+			rec := httptest.NewRecorder()
+			p.ServeHTTP(rec, req)
+
+			if rec.Body.String() != tt.want {
+				t.Errorf("body %q, want %q", rec.Body.String(), tt.want)
+			}
+			labels := map[string]string{"service": "core-operations", "version": tt.wantLabel}
+			if got := counterValue(t, reg, "api_gateway_proxy_version_requests_total", labels); got != 1 {
+				t.Errorf("version %s requests = %v, want 1", tt.wantLabel, got)
+			}
+		})
+	}
+}
+
+func TestNewVersionRouterRejectsBadURL(t *testing.T) {
+	_, err := newVersionRouter(config.VersioningConfig{Versions: map[string]config.VersionTarget{"2": {URL: "http://[::1"}}}, nil)
+	if err == nil {
+		t.Error("invalid version URL accepted")
+	}
+}
@@ -0,0 +1,183 @@
+// Package store provides the bounded in-memory key/value store shared by the
+// gateway's stateful features (deduplication, rate limiting, caching), so that
+// none of them can grow without limit in a long-running process.
+package store
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/canxphung/DA_CNPM_242/api_gateway/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Store is a size-capped map with least-recently-used eviction and optional
+// per-entry expiry. It is safe for concurrent use.
+type Store[V any] struct {
+	mu         sync.Mutex
+	maxEntries int
+	ttl        time.Duration
+	order      *list.List // front = most recently used
+	items      map[string]*list.Element
+
+	size      prometheus.Gauge
+	evictions prometheus.Counter
+}
+
+type entry[V any] struct {
+	key     string
+	value   V
+	expires time.Time // zero = never
+}
+
+// New creates a store holding at most maxEntries entries (0 = unbounded).
+// Entries added with Set expire after ttl (0 = never). The store's size and
+// evictions are exported labeled by name.
+func New[V any](name string, maxEntries int, ttl time.Duration, reg prometheus.Registerer) *Store[V] {
+	const namespace = "api_gateway"
+
+	size := metrics.RegisterOrReuse(reg, prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "store_entries",
+			Help:      "Current number of entries in each in-memory store",
+		},
+		[]string{"store"},
+	))
+
+	evictions := metrics.RegisterOrReuse(reg, prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "store_evictions_total",
+			Help:      "Total number of entries evicted from each in-memory store to respect its cap",
+		},
+		[]string{"store"},
+	))
+
+	return &Store[V]{
+		maxEntries: maxEntries,
+		ttl:        ttl,
+		order:      list.New(),
+		items:      make(map[string]*list.Element),
+		size:       size.WithLabelValues(name),
+		evictions:  evictions.WithLabelValues(name),
+	}
+}
+
+// Get returns the value stored under key and marks it as recently used
+func (s *Store[V]) Get(key string) (V, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	element, ok := s.lookup(key)
+	if !ok {
+		var zero V
+		return zero, false
+	}
+	s.order.MoveToFront(element)
+	return element.Value.(*entry[V]).value, true
+}
+
+// Set stores the value with the store's default TTL
+func (s *Store[V]) Set(key string, value V) {
+	s.SetWithTTL(key, value, s.ttl)
+}
+
+// SetWithTTL stores the value, expiring it after ttl (0 = never)
+func (s *Store[V]) SetWithTTL(key string, value V, ttl time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.set(key, value, ttl)
+}
+
+// GetOrSet returns the existing value for key if there is one, and otherwise
+// stores value with the default TTL. It reports whether the value was already present.
+func (s *Store[V]) GetOrSet(key string, value V) (V, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if element, ok := s.lookup(key); ok {
+		s.order.MoveToFront(element)
+		return element.Value.(*entry[V]).value, true
+	}
+	s.set(key, value, s.ttl)
+	return value, false
+}
+
+// Delete removes the entry stored under key, if any
+func (s *Store[V]) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if element, ok := s.items[key]; ok {
+		s.remove(element)
+	}
+}
+
+// DeleteExpired removes all expired entries and returns how many were removed
+func (s *Store[V]) DeleteExpired() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	removed := 0
+	for element := s.order.Front(); element != nil; {
+		next := element.Next()
+		if expires := element.Value.(*entry[V]).expires; !expires.IsZero() && now.After(expires) {
+			s.remove(element)
+			removed++
+		}
+		element = next
+	}
+	return removed
+}
+
+// Len returns the number of entries, including expired ones not yet removed
+func (s *Store[V]) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.order.Len()
+}
+
+// lookup returns the live element for key, dropping it if it has expired
+func (s *Store[V]) lookup(key string) (*list.Element, bool) {
+	element, ok := s.items[key]
+	if !ok {
+		return nil, false
+	}
+	if expires := element.Value.(*entry[V]).expires; !expires.IsZero() && time.Now().After(expires) {
+		s.remove(element)
+		return nil, false
+	}
+	return element, true
+}
+
+func (s *Store[V]) set(key string, value V, ttl time.Duration) {
+	var expires time.Time
+	if ttl > 0 {
+		expires = time.Now().Add(ttl)
+	}
+
+	if element, ok := s.items[key]; ok {
+		item := element.Value.(*entry[V])
+		item.value = value
+		item.expires = expires
+		s.order.MoveToFront(element)
+		return
+	}
+
+	s.items[key] = s.order.PushFront(&entry[V]{key: key, value: value, expires: expires})
+	s.size.Inc()
+
+	for s.maxEntries > 0 && s.order.Len() > s.maxEntries {
+		s.remove(s.order.Back())
+		s.evictions.Inc()
+	}
+}
+
+func (s *Store[V]) remove(element *list.Element) {
+	s.order.Remove(element)
+	delete(s.items, element.Value.(*entry[V]).key)
+	s.size.Dec()
+}
@@ -0,0 +1,172 @@
+package store
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// metricValue returns the value of the named store metric for the store, or 0
+func metricValue(t *testing.T, reg *prometheus.Registry, name, store string) float64 {
+	t.Helper()
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+		for _, m := range family.GetMetric() {
+			for _, label := range m.GetLabel() {
+				if label.GetName() == "store" && label.GetValue() == store {
+					if m.GetGauge() != nil {
+						return m.GetGauge().GetValue()
+					}
+					return m.GetCounter().GetValue()
+				}
+			}
+		}
+	}
+	return 0
+}
+
+// expire backdates the entry under key so that it has already expired
+func expire[V any](s *Store[V], key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.items[key].Value.(*entry[V]).expires = time.Now().Add(-time.Second)
+}
+
+func TestStoreEvictsLeastRecentlyUsed(t *testing.T) {
+	tests := []struct {
+		name string
+		// touch runs after a, b and c are stored in order
+		touch       func(s *Store[int])
+		wantEvicted string
+	}{
+		{"oldest entry", func(s *Store[int]) {}, "a"},
+		{"Get marks an entry as used", func(s *Store[int]) { s.Get("a") }, "b"},
+		{"Set on an existing key marks it as used", func(s *Store[int]) { s.Set("a", 10) }, "b"},
+		{"GetOrSet on an existing key marks it as used", func(s *Store[int]) { s.GetOrSet("a", 10) }, "b"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := New[int]("test", 3, 0, prometheus.NewRegistry())
+			s.Set("a", 1)
+			s.Set("b", 2)
+			s.Set("c", 3)
+			tt.touch(s)
+
+			s.Set("d", 4)
+			if s.Len() != 3 {
+				t.Errorf("Len() = %d, want the cap of 3", s.Len())
+			}
+			for _, key := range []string{"a", "b", "c", "d"} {
+				if _, ok := s.Get(key); ok == (key == tt.wantEvicted) {
+					t.Errorf("Get(%q) present = %v, want %q evicted", key, ok, tt.wantEvicted)
+				}
+			}
+		})
+	}
+}
+
+func TestStoreUnbounded(t *testing.T) {
+	s := New[int]("test", 0, 0, prometheus.NewRegistry())
+	for i := 0; i < 1000; i++ {
+		s.Set(strconv.Itoa(i), i)
+	}
+	if s.Len() != 1000 {
+		t.Errorf("Len() = %d, want every entry kept", s.Len())
+	}
+}
+
+func TestStoreExpiry(t *testing.T) {
+	s := New[string]("test", 10, time.Minute, prometheus.NewRegistry())
+	s.Set("default", "v")
+	s.SetWithTTL("forever", "v", 0)
+	s.SetWithTTL("replaced", "v", time.Hour)
+
+	if _, ok := s.Get("default"); !ok {
+		t.Fatal("live entry not found")
+	}
+	expire(s, "default")
+	if _, ok := s.Get("default"); ok {
+		t.Error("expired entry returned by Get")
+	}
+	if s.Len() != 2 {
+		t.Errorf("Len() = %d, want the expired entry dropped on lookup", s.Len())
+	}
+
+	// GetOrSet replaces an expired entry instead of returning it
+	expire(s, "replaced")
+	if value, found := s.GetOrSet("replaced", "new"); found || value != "new" {
+		t.Errorf("GetOrSet on an expired key = %q, %v, want the new value stored", value, found)
+	}
+	if _, ok := s.Get("forever"); !ok {
+		t.Error("entry with no TTL expired")
+	}
+}
+
+func TestStoreDeleteExpired(t *testing.T) {
+	s := New[int]("test", 10, time.Minute, prometheus.NewRegistry())
+	s.Set("a", 1)
+	s.Set("b", 2)
+	s.SetWithTTL("c", 3, 0)
+	expire(s, "a")
+	expire(s, "b")
+
+	if removed := s.DeleteExpired(); removed != 2 {
+		t.Errorf("DeleteExpired() = %d, want 2", removed)
+	}
+	if s.Len() != 1 {
+		t.Errorf("Len() = %d, want 1", s.Len())
+	}
+	if removed := s.DeleteExpired(); removed != 0 {
+		t.Errorf("second DeleteExpired() = %d, want 0", removed)
+	}
+}
+
+func TestStoreMetrics(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	s := New[int]("dedup", 2, time.Minute, reg)
+	// A second store on the same registry reports under its own label
+	other := New[int]("cache", 2, time.Minute, reg)
+	other.Set("x", 1)
+
+	s.Set("a", 1)
+	s.Set("a", 2)
+	if got := metricValue(t, reg, "api_gateway_store_entries", "dedup"); got != 1 {
+		t.Errorf("entries after overwriting a key = %v, want 1", got)
+	}
+
+	s.Set("b", 2)
+	s.Set("c", 3)
+	s.Set("d", 4)
+	if got := metricValue(t, reg, "api_gateway_store_entries", "dedup"); got != 2 {
+		t.Errorf("entries at the cap = %v, want 2", got)
+	}
+	if got := metricValue(t, reg, "api_gateway_store_evictions_total", "dedup"); got != 2 {
+		t.Errorf("evictions = %v, want 2", got)
+	}
+
+	// Deletes and expiry shrink the store without counting as evictions
+	s.Delete("d")
+	expire(s, "c")
+	s.DeleteExpired()
+	if got := metricValue(t, reg, "api_gateway_store_entries", "dedup"); got != 0 {
+		t.Errorf("entries after deleting = %v, want 0", got)
+	}
+	if got := metricValue(t, reg, "api_gateway_store_evictions_total", "dedup"); got != 2 {
+		t.Errorf("evictions after deleting = %v, want still 2", got)
+	}
+
+	if got := metricValue(t, reg, "api_gateway_store_entries", "cache"); got != 1 {
+		t.Errorf("other store's entries = %v, want 1", got)
+	}
+	if got := metricValue(t, reg, "api_gateway_store_evictions_total", "cache"); got != 0 {
+		t.Errorf("other store's evictions = %v, want 0", got)
+	}
+}